@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+// Allocation methods
+const (
+	allocateFixed = "fixed" // fixed percentages per department
+	allocateSpend = "spend" // in proportion to each department's own spend
+	allocateUsage = "usage" // in proportion to a usage metric per department
+)
+
+// allocationRule splits the costs it matches across the departments, the
+// values of the first -group-by key. For example:
+//
+//	{"name": "untagged", "match": {"Project": ""}, "method": "spend"}
+//	{"name": "support", "match": {"SERVICE": "AWS Support (Business)"},
+//	 "method": "fixed", "shares": {"web": 60, "data": 40}}
+//	{"name": "eks", "match": {"Project": "shared-eks"},
+//	 "method": "usage", "usageFile": "eks_cpu_hours.csv"}
+type allocationRule struct {
+	Name string `json:"name"`
+	// Match selects costs by -group-by key; every key must match. An
+	// empty value matches untagged or uncategorized costs.
+	Match  map[string]string `json:"match"`
+	Method string            `json:"method"`
+	// Shares are the percentages of the fixed method, summing to 100
+	Shares map[string]float64 `json:"shares,omitempty"`
+	// Targets limit the departments of the spend method; all by default
+	Targets []string `json:"targets,omitempty"`
+	// Usage is the metric of the usage method per department, inline or
+	// read from a CSV file of department,value rows relative to the rules
+	// file
+	Usage     map[string]float64 `json:"usage,omitempty"`
+	UsageFile string             `json:"usageFile,omitempty"`
+}
+
+type allocationRules struct {
+	Rules []allocationRule `json:"rules"`
+}
+
+// loadAllocationRules reads and checks a rules file against the -group-by
+// keys.
+func loadAllocationRules(path string, groups []types.GroupDefinition) ([]allocationRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules allocationRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid allocation rules %s: %v", path, err)
+	}
+
+	labels := make([]string, len(groups))
+	for i, group := range groups {
+		labels[i] = groupLabel(group)
+	}
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if len(rule.Match) == 0 {
+			return nil, fmt.Errorf("allocation %s matches nothing", rule.Name)
+		}
+		for key := range rule.Match {
+			if !slices.Contains(labels, key) {
+				return nil, fmt.Errorf("allocation %s matches on %s, which is not a -group-by key (%s)", rule.Name, key, strings.Join(labels, ", "))
+			}
+		}
+
+		switch rule.Method {
+		case allocateFixed:
+			total := 0.0
+			for department, share := range rule.Shares {
+				if share < 0 {
+					return nil, fmt.Errorf("allocation %s has a negative share for %s", rule.Name, department)
+				}
+				total += share
+			}
+			if math.Abs(total-100) > 0.01 {
+				return nil, fmt.Errorf("allocation %s shares add up to %g%%, not 100%%", rule.Name, total)
+			}
+		case allocateSpend:
+		case allocateUsage:
+			if rule.UsageFile != "" {
+				usageFile := rule.UsageFile
+				if !filepath.IsAbs(usageFile) {
+					usageFile = filepath.Join(filepath.Dir(path), usageFile)
+				}
+				if rule.Usage, err = readUsageFile(usageFile); err != nil {
+					return nil, fmt.Errorf("allocation %s: %v", rule.Name, err)
+				}
+			}
+			total := 0.0
+			for department, usage := range rule.Usage {
+				if usage < 0 {
+					return nil, fmt.Errorf("allocation %s has negative usage for %s", rule.Name, department)
+				}
+				total += usage
+			}
+			if total == 0 {
+				return nil, fmt.Errorf("allocation %s has no usage to allocate by", rule.Name)
+			}
+		default:
+			return nil, fmt.Errorf("allocation %s has unsupported method %q, expected %s, %s or %s", rule.Name, rule.Method, allocateFixed, allocateSpend, allocateUsage)
+		}
+	}
+	return rules.Rules, nil
+}
+
+// readUsageFile reads department,value rows. A first row whose value is not
+// a number is taken as a header.
+func readUsageFile(path string) (map[string]float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %v", path, err)
+	}
+	usage := make(map[string]float64, len(records))
+	for i, record := range records {
+		if len(record) < 2 {
+			return nil, fmt.Errorf("%s line %d: expected department,value", path, i+1)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			if i == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("%s line %d: invalid value %q", path, i+1, record[1])
+		}
+		usage[strings.TrimSpace(record[0])] += value
+	}
+	return usage, nil
+}
+
+// matches reports whether a cost falls under the rule.
+func (r allocationRule) matches(cost groupedCost, groups []types.GroupDefinition) bool {
+	for i, group := range groups {
+		value, ok := r.Match[groupLabel(group)]
+		if ok && value != cost.Values[i] && value != displayValue(group, cost.Values[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// allocate replaces the costs matched by the rules, each by its first
+// matching rule, with their shares of every department. Departments keep
+// the other -group-by values of the cost they receive. Spend shares are
+// computed per period from the costs no rule matches. A cost that cannot
+// be allocated, e.g. a spend rule in a period without direct spend, is
+// kept as is and reported in the returned warnings.
+func allocate(costs []groupedCost, groups []types.GroupDefinition, rules []allocationRule) ([]groupedCost, []string) {
+	matched := make([]int, len(costs))
+	spend := make(map[string]map[string]float64) // period, department
+	for i, cost := range costs {
+		matched[i] = -1
+		for r, rule := range rules {
+			if rule.matches(cost, groups) {
+				matched[i] = r
+				break
+			}
+		}
+		if matched[i] < 0 {
+			if spend[cost.Start] == nil {
+				spend[cost.Start] = make(map[string]float64)
+			}
+			spend[cost.Start][cost.Values[0]] += cost.Cost
+		}
+	}
+
+	var allocated []groupedCost
+	var warnings []string
+	for i, cost := range costs {
+		if matched[i] < 0 {
+			allocated = append(allocated, cost)
+			continue
+		}
+		rule := rules[matched[i]]
+
+		var weights map[string]float64
+		switch rule.Method {
+		case allocateFixed:
+			weights = rule.Shares
+		case allocateUsage:
+			weights = rule.Usage
+		case allocateSpend:
+			weights = make(map[string]float64)
+			for department, amount := range spend[cost.Start] {
+				if amount > 0 && (len(rule.Targets) == 0 || slices.Contains(rule.Targets, department)) {
+					weights[department] = amount
+				}
+			}
+		}
+		total := 0.0
+		for _, weight := range weights {
+			total += weight
+		}
+		if total == 0 {
+			warnings = append(warnings, fmt.Sprintf("allocation %s: nothing to allocate %s by in %s, kept as is", rule.Name, strings.Join(cost.Values, " / "), cost.Start))
+			allocated = append(allocated, cost)
+			continue
+		}
+
+		departments := make([]string, 0, len(weights))
+		for department := range weights {
+			departments = append(departments, department)
+		}
+		sort.Strings(departments)
+		for _, department := range departments {
+			if weights[department] == 0 {
+				continue
+			}
+			share := cost
+			share.Values = append([]string{department}, cost.Values[1:]...)
+			share.Cost = cost.Cost * weights[department] / total
+			share.AllocatedFrom = rule.Name
+			allocated = append(allocated, share)
+		}
+	}
+	return allocated, warnings
+}
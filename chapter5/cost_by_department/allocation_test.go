@@ -0,0 +1,211 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+// allocationGroups are -group-by tag:Project,SERVICE.
+var allocationGroups = []types.GroupDefinition{
+	{Type: types.GroupDefinitionTypeTag, Key: aws.String("Project")},
+	{Type: types.GroupDefinitionTypeDimension, Key: aws.String(string(types.DimensionService))},
+}
+
+func TestAllocate(t *testing.T) {
+	cost := func(start, project, service string, amount float64) groupedCost {
+		return groupedCost{Start: start, Values: []string{project, service}, Cost: amount}
+	}
+	direct := []groupedCost{
+		cost("2024-05-01", "web", "Amazon EC2", 300),
+		cost("2024-05-01", "data", "Amazon S3", 100),
+	}
+	untagged := &allocationRule{Name: "untagged", Match: map[string]string{"Project": ""}}
+	support := &allocationRule{Name: "support", Match: map[string]string{"SERVICE": "AWS Support"}}
+
+	tests := []struct {
+		name     string
+		costs    []groupedCost
+		rule     allocationRule
+		want     map[string]float64 // department total of the period
+		warnings int
+	}{
+		{
+			name:  "fixed shares",
+			costs: append(direct, cost("2024-05-01", "shared", "AWS Support", 50)),
+			rule:  allocationRule{Name: support.Name, Match: support.Match, Method: allocateFixed, Shares: map[string]float64{"web": 60, "data": 40}},
+			want:  map[string]float64{"web": 330, "data": 120, "shared": 0},
+		},
+		{
+			name:  "spend shares",
+			costs: append(direct, cost("2024-05-01", "", "Amazon EC2", 40)),
+			rule:  allocationRule{Name: untagged.Name, Match: untagged.Match, Method: allocateSpend},
+			want:  map[string]float64{"web": 330, "data": 110, "": 0},
+		},
+		{
+			name:  "spend shares of the targets",
+			costs: append(direct, cost("2024-05-01", "", "Amazon EC2", 40)),
+			rule:  allocationRule{Name: untagged.Name, Match: untagged.Match, Method: allocateSpend, Targets: []string{"data"}},
+			want:  map[string]float64{"web": 300, "data": 140, "": 0},
+		},
+		{
+			name:  "usage shares",
+			costs: append(direct, cost("2024-05-01", "", "Amazon EKS", 90)),
+			rule:  allocationRule{Name: untagged.Name, Match: untagged.Match, Method: allocateUsage, Usage: map[string]float64{"web": 1, "data": 2}},
+			want:  map[string]float64{"web": 330, "data": 160, "": 0},
+		},
+		{
+			name:  "usage of a department without direct cost",
+			costs: append(direct, cost("2024-05-01", "", "Amazon EKS", 90)),
+			rule:  allocationRule{Name: untagged.Name, Match: untagged.Match, Method: allocateUsage, Usage: map[string]float64{"ml": 1, "data": 0}},
+			want:  map[string]float64{"web": 300, "data": 100, "ml": 90, "": 0},
+		},
+		{
+			name: "spend in a period without direct spend",
+			costs: append(direct,
+				cost("2024-05-01", "", "Amazon EC2", 40),
+				cost("2024-06-01", "", "Amazon EC2", 25)),
+			rule:     allocationRule{Name: untagged.Name, Match: untagged.Match, Method: allocateSpend},
+			want:     map[string]float64{"web": 330, "data": 110, "": 25},
+			warnings: 1,
+		},
+		{
+			name: "spend targets without direct spend",
+			costs: append(direct,
+				cost("2024-05-01", "", "Amazon EC2", 40)),
+			rule:     allocationRule{Name: untagged.Name, Match: untagged.Match, Method: allocateSpend, Targets: []string{"ml"}},
+			want:     map[string]float64{"web": 300, "data": 100, "": 40},
+			warnings: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allocated, warnings := allocate(tt.costs, allocationGroups, []allocationRule{tt.rule})
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", warnings, tt.warnings)
+			}
+
+			got := make(map[string]float64)
+			var before, after float64
+			for _, c := range tt.costs {
+				before += c.Cost
+			}
+			for _, c := range allocated {
+				got[c.Values[0]] += c.Cost
+				after += c.Cost
+				if c.AllocatedFrom != "" && c.AllocatedFrom != tt.rule.Name {
+					t.Errorf("cost allocated from %q, want %q", c.AllocatedFrom, tt.rule.Name)
+				}
+			}
+			for department, want := range tt.want {
+				if math.Abs(got[department]-want) > 1e-9 {
+					t.Errorf("%q got %g, want %g", department, got[department], want)
+				}
+			}
+			if math.Abs(after-before) > 1e-9 {
+				t.Errorf("allocation changed the total from %g to %g", before, after)
+			}
+		})
+	}
+}
+
+func TestAllocateKeepsOtherValues(t *testing.T) {
+	costs := []groupedCost{
+		{Start: "2024-05-01", Values: []string{"web", "Amazon EC2"}, Cost: 10},
+		{Start: "2024-05-01", Values: []string{"", "Amazon S3"}, Cost: 4},
+	}
+	rule := allocationRule{Name: "untagged", Match: map[string]string{"Project": untaggedLabel}, Method: allocateSpend}
+	allocated, _ := allocate(costs, allocationGroups, []allocationRule{rule})
+	if len(allocated) != 2 {
+		t.Fatalf("allocated = %+v, want 2 costs", allocated)
+	}
+	share := allocated[1]
+	if share.Values[0] != "web" || share.Values[1] != "Amazon S3" || share.AllocatedFrom != "untagged" || share.Cost != 4 {
+		t.Errorf("share = %+v, want web's Amazon S3 cost of 4 from untagged", share)
+	}
+}
+
+func TestAllocateRoundingResidue(t *testing.T) {
+	// Thirds and sevenths have no exact binary fraction
+	tests := []struct {
+		name    string
+		amount  float64
+		weights map[string]float64
+	}{
+		{"thirds", 100, map[string]float64{"a": 1, "b": 1, "c": 1}},
+		{"sevenths", 0.01, map[string]float64{"a": 1, "b": 2, "c": 4}},
+		{"uneven", 1234.56, map[string]float64{"a": 33.3, "b": 33.3, "c": 33.4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			costs := []groupedCost{{Start: "2024-05-01", Values: []string{"shared", "AWS Support"}, Cost: tt.amount}}
+			rule := allocationRule{Name: "shared", Match: map[string]string{"Project": "shared"}, Method: allocateUsage, Usage: tt.weights}
+			allocated, _ := allocate(costs, allocationGroups, []allocationRule{rule})
+			if len(allocated) != len(tt.weights) {
+				t.Fatalf("allocated %d shares, want %d", len(allocated), len(tt.weights))
+			}
+			var sum float64
+			for _, share := range allocated {
+				sum += share.Cost
+			}
+			if math.Abs(sum-tt.amount) > 1e-9*math.Max(1, tt.amount) {
+				t.Errorf("shares add up to %.12f, want %.12f", sum, tt.amount)
+			}
+		})
+	}
+}
+
+func TestLoadAllocationRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		err   string // part of the error, empty when the rules are valid
+	}{
+		{"shares of 100%", `{"rules": [{"match": {"SERVICE": "AWS Support"}, "method": "fixed", "shares": {"web": 60, "data": 40}}]}`, ""},
+		{"shares within a hundredth", `{"rules": [{"match": {"SERVICE": "AWS Support"}, "method": "fixed", "shares": {"a": 33.333, "b": 33.333, "c": 33.333}}]}`, ""},
+		{"shares short of 100%", `{"rules": [{"match": {"SERVICE": "AWS Support"}, "method": "fixed", "shares": {"web": 60, "data": 30}}]}`, "add up to 90%"},
+		{"negative share", `{"rules": [{"match": {"SERVICE": "AWS Support"}, "method": "fixed", "shares": {"web": 110, "data": -10}}]}`, "negative share"},
+		{"no usage", `{"rules": [{"match": {"Project": ""}, "method": "usage", "usage": {"web": 0}}]}`, "no usage"},
+		{"spend", `{"rules": [{"match": {"Project": ""}, "method": "spend"}]}`, ""},
+		{"unknown key", `{"rules": [{"match": {"Team": ""}, "method": "spend"}]}`, "not a -group-by key"},
+		{"unknown method", `{"rules": [{"match": {"Project": ""}, "method": "even"}]}`, "unsupported method"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.json")
+			if err := os.WriteFile(path, []byte(tt.rules), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := loadAllocationRules(path, allocationGroups)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("error = %v, want one containing %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadAllocationRulesUsageFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "cpu.csv"), []byte("department,cpu_hours\nweb,30\ndata,10\nweb,20\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "rules.json")
+	if err := os.WriteFile(path, []byte(`{"rules": [{"match": {"Project": "shared-eks"}, "method": "usage", "usageFile": "cpu.csv"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := loadAllocationRules(path, allocationGroups)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rules[0].Usage; got["web"] != 50 || got["data"] != 10 || len(got) != 2 {
+		t.Errorf("usage = %v, want web 50 and data 10", got)
+	}
+}
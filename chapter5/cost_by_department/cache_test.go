@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsClosedPeriod(t *testing.T) {
+	day := func(value string) time.Time {
+		parsed, err := time.Parse(dateLayout, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	tests := []struct {
+		end  string
+		now  string
+		want bool
+	}{
+		{"2024-06-01", "2024-06-02", false}, // May is still being finalized
+		{"2024-06-01", "2024-06-05", false},
+		{"2024-06-01", "2024-06-06", true},
+		{"2024-05-15", "2024-06-06", true},  // ends inside a closed month
+		{"2024-06-02", "2024-06-20", false}, // reaches into the current month
+		{"2024-06-02", "2024-07-06", true},
+		{"2024-01-01", "2024-01-05", false}, // across a year end
+		{"2024-01-01", "2024-01-06", true},
+		{"not a date", "2024-06-20", false},
+	}
+	for _, tt := range tests {
+		if got := isClosedPeriod(tt.end, day(tt.now)); got != tt.want {
+			t.Errorf("isClosedPeriod(%s) on %s = %v, want %v", tt.end, tt.now, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+// creditRecordTypes are the record types listed apart from direct costs.
+var creditRecordTypes = []string{"Credit", "Refund"}
+
+// statementAmount is an amount of the billing month next to the prior month.
+type statementAmount struct {
+	Cost  float64
+	Prior float64
+}
+
+func (a statementAmount) Change() float64 {
+	return a.Cost - a.Prior
+}
+
+// ChangePercent is the change relative to the prior month, or "n/a" without
+// prior spend.
+func (a statementAmount) ChangePercent() string {
+	if a.Prior == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", a.Change()/math.Abs(a.Prior)*100)
+}
+
+// statementLine is one row of a statement section.
+type statementLine struct {
+	Name    string // service, allocation rule or record type
+	Service string // service of an allocated cost
+	statementAmount
+}
+
+// statement is the chargeback of one department for one billing month.
+type statement struct {
+	Label      string // the department key, e.g. Project
+	Department string
+	Month      string // YYYY-MM
+	Start      string
+	End        string
+	PriorMonth string
+	Source     string
+	Direct     []statementLine
+	Allocated  []statementLine
+	Credits    []statementLine
+
+	DirectTotal    statementAmount
+	AllocatedTotal statementAmount
+	CreditsTotal   statementAmount
+	Total          statementAmount
+}
+
+// chargebackPeriod is a billing month and the month before it.
+type chargebackPeriod struct {
+	month      time.Time
+	priorStart string
+	start      string
+	end        string
+}
+
+// parseMonth reads a YYYY-MM billing month, the last closed month when
+// empty.
+func parseMonth(value string, now time.Time) (chargebackPeriod, error) {
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	if value != "" {
+		parsed, err := time.Parse("2006-01", value)
+		if err != nil {
+			return chargebackPeriod{}, fmt.Errorf("invalid -month %q, expected YYYY-MM", value)
+		}
+		month = parsed
+	}
+	return chargebackPeriod{
+		month:      month,
+		priorStart: month.AddDate(0, -1, 0).Format(dateLayout),
+		start:      month.Format(dateLayout),
+		end:        month.AddDate(0, 1, 0).Format(dateLayout),
+	}, nil
+}
+
+// runChargeback implements the chargeback subcommand: one statement per
+// department for a billing month, with its direct costs by service, the
+// shared costs allocated to it, its credits and refunds, and the prior
+// month next to each amount. Statements only depend on the cost data, so
+// closed months rendered again from the cache (-offline) or the same CUR
+// files are identical.
+func runChargeback(args []string) error {
+	fs := flag.NewFlagSet("chargeback", flag.ExitOnError)
+	sourceOpts := addSourceFlags(fs)
+	fs.BoolVar(&sourceOpts.offline, "offline", false, "only use cached Cost Explorer responses, failing on a miss")
+	fs.StringVar(&sourceOpts.account, "account", "", "account whose cached responses to use (default the caller's), e.g. with -offline")
+	monthFlag := fs.String("month", "", "billing month YYYY-MM (default the last closed month)")
+	department := fs.String("department", "tag:Project", "grouping that names departments: tag:<key>, category:<cost category> or a dimension")
+	allocation := fs.String("allocation", "", "JSON rules file allocating untagged and shared costs; match keys are the -department label and SERVICE")
+	formats := fs.String("format", "html,md", "comma-separated statement formats: html, md")
+	outDir := fs.String("out-dir", "chargeback", "directory the statements are written to")
+	pdfCommand := fs.String("pdf-command", "", "command rendering each HTML statement to PDF, with {html} and {pdf} placeholders, e.g. \"wkhtmltopdf {html} {pdf}\"")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cost_by_department chargeback [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	groups, err := parseGroupBy(*department)
+	if err != nil {
+		return err
+	}
+	if len(groups) != 1 || isServiceGroup(groups[0]) {
+		return fmt.Errorf("-department takes one grouping other than SERVICE")
+	}
+	groups = append(groups, types.GroupDefinition{
+		Type: types.GroupDefinitionTypeDimension,
+		Key:  aws.String(string(types.DimensionService)),
+	})
+
+	writeHTML, writeMarkdown := false, false
+	for _, format := range strings.Split(*formats, ",") {
+		switch strings.TrimSpace(format) {
+		case "html":
+			writeHTML = true
+		case "md":
+			writeMarkdown = true
+		default:
+			return fmt.Errorf("unsupported statement format %q, expected html or md", format)
+		}
+	}
+	pdfRequested := false
+	fs.Visit(func(f *flag.Flag) { pdfRequested = pdfRequested || f.Name == "pdf-command" })
+	if pdfRequested && len(strings.Fields(*pdfCommand)) == 0 {
+		return fmt.Errorf("-pdf-command is empty, expected a command such as \"wkhtmltopdf {html} {pdf}\"")
+	}
+	if *pdfCommand != "" && !writeHTML {
+		return fmt.Errorf("-pdf-command renders the HTML statements, add html to -format")
+	}
+
+	period, err := parseMonth(*monthFlag, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	var rules []allocationRule
+	if *allocation != "" {
+		if rules, err = loadAllocationRules(*allocation, groups); err != nil {
+			return fmt.Errorf("unable to load allocation rules, %v", err)
+		}
+	}
+
+	months, err := datePeriod(period.priorStart, period.end)
+	if err != nil {
+		return err
+	}
+	client, _, closeSource, err := sourceOpts.open(context.TODO(), false, months)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	statements, warnings, err := chargebackStatements(context.TODO(), client, groups, rules, period)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintln(os.Stderr, warning)
+	}
+	source := "AWS Cost Explorer"
+	if sourceOpts.source == "cur" {
+		source = "AWS Cost and Usage Report"
+	}
+
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		return fmt.Errorf("unable to create %s, %v", *outDir, err)
+	}
+	names := make(map[string]string)
+	for _, s := range statements {
+		s.Source = source
+		name := period.month.Format("2006-01") + "_" + fileSlug(s.Department)
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("departments %q and %q would share the statement %s", other, s.Department, name)
+		}
+		names[name] = s.Department
+
+		base := filepath.Join(*outDir, name)
+		if writeMarkdown {
+			if err := writeFile(base+".md", s.writeMarkdown); err != nil {
+				return fmt.Errorf("unable to write %s.md, %v", base, err)
+			}
+			fmt.Printf("Wrote %s.md\n", base)
+		}
+		if writeHTML {
+			if err := writeFile(base+".html", s.writeHTML); err != nil {
+				return fmt.Errorf("unable to write %s.html, %v", base, err)
+			}
+			fmt.Printf("Wrote %s.html\n", base)
+		}
+		if *pdfCommand != "" {
+			if err := renderPDF(*pdfCommand, base+".html", base+".pdf"); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote %s.pdf\n", base)
+		}
+	}
+	return nil
+}
+
+// chargebackStatements queries the billing month and the month before it,
+// allocates shared costs and builds the statement of every department with
+// costs in either month.
+func chargebackStatements(ctx context.Context, client costExplorerAPI, groups []types.GroupDefinition, rules []allocationRule, period chargebackPeriod) ([]*statement, []string, error) {
+	recordTypes := &types.Expression{Dimensions: &types.DimensionValues{
+		Key:    types.DimensionRecordType,
+		Values: creditRecordTypes,
+	}}
+	input := &costexplorer.GetCostAndUsageInput{
+		TimePeriod: &types.DateInterval{
+			Start: aws.String(period.priorStart),
+			End:   aws.String(period.end),
+		},
+		Granularity: types.GranularityMonthly,
+		Metrics:     []string{"UnblendedCost"},
+		GroupBy:     groups,
+		Filter:      &types.Expression{Not: recordTypes},
+	}
+	results, err := getCostAndUsage(ctx, client, input)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get cost and usage: %v", err)
+	}
+	costs, err := groupedCosts(results, groups, "UnblendedCost")
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read costs, %v", err)
+	}
+	var warnings []string
+	if len(rules) > 0 {
+		costs, warnings = allocate(costs, groups, rules)
+	}
+
+	// Credits and refunds stay with the department they were billed to
+	creditGroups := []types.GroupDefinition{groups[0], {
+		Type: types.GroupDefinitionTypeDimension,
+		Key:  aws.String(string(types.DimensionRecordType)),
+	}}
+	creditInput := *input
+	creditInput.GroupBy = creditGroups
+	creditInput.Filter = recordTypes
+	creditResults, err := getCostAndUsage(ctx, client, &creditInput)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get credits and refunds: %v", err)
+	}
+	credits, err := groupedCosts(creditResults, creditGroups, "UnblendedCost")
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read credits and refunds, %v", err)
+	}
+
+	statements := make(map[string]*statement)
+	statementOf := func(cost groupedCost) *statement {
+		department := displayValue(groups[0], cost.Values[0])
+		s := statements[department]
+		if s == nil {
+			s = &statement{
+				Label:      groupLabel(groups[0]),
+				Department: department,
+				Month:      period.month.Format("2006-01"),
+				Start:      period.start,
+				End:        period.end,
+				PriorMonth: period.month.AddDate(0, -1, 0).Format("2006-01"),
+			}
+			statements[department] = s
+		}
+		return s
+	}
+	// addAmount adds a cost to the line of its month
+	addAmount := func(lines *[]statementLine, name, service string, cost groupedCost) {
+		i := 0
+		for i < len(*lines) && ((*lines)[i].Name != name || (*lines)[i].Service != service) {
+			i++
+		}
+		if i == len(*lines) {
+			*lines = append(*lines, statementLine{Name: name, Service: service})
+		}
+		if cost.Start == period.start {
+			(*lines)[i].Cost += cost.Cost
+		} else {
+			(*lines)[i].Prior += cost.Cost
+		}
+	}
+	for _, cost := range costs {
+		s := statementOf(cost)
+		service := displayValue(groups[1], cost.Values[1])
+		if cost.AllocatedFrom != "" {
+			addAmount(&s.Allocated, cost.AllocatedFrom, service, cost)
+		} else {
+			addAmount(&s.Direct, service, "", cost)
+		}
+	}
+	for _, credit := range credits {
+		addAmount(&statementOf(credit).Credits, credit.Values[1], "", credit)
+	}
+
+	sorted := make([]*statement, 0, len(statements))
+	for _, s := range statements {
+		s.DirectTotal = sortLines(s.Direct)
+		s.AllocatedTotal = sortLines(s.Allocated)
+		s.CreditsTotal = sortLines(s.Credits)
+		s.Total = statementAmount{
+			Cost:  s.DirectTotal.Cost + s.AllocatedTotal.Cost + s.CreditsTotal.Cost,
+			Prior: s.DirectTotal.Prior + s.AllocatedTotal.Prior + s.CreditsTotal.Prior,
+		}
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Department < sorted[j].Department })
+	return sorted, warnings, nil
+}
+
+// sortLines orders lines by the size of their amount, largest first, and
+// returns their total.
+func sortLines(lines []statementLine) statementAmount {
+	sort.Slice(lines, func(i, j int) bool {
+		if math.Abs(lines[i].Cost) != math.Abs(lines[j].Cost) {
+			return math.Abs(lines[i].Cost) > math.Abs(lines[j].Cost)
+		}
+		if lines[i].Name != lines[j].Name {
+			return lines[i].Name < lines[j].Name
+		}
+		return lines[i].Service < lines[j].Service
+	})
+	var total statementAmount
+	for _, line := range lines {
+		total.Cost += line.Cost
+		total.Prior += line.Prior
+	}
+	return total
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// fileSlug turns a department into a file name.
+func fileSlug(department string) string {
+	slug := strings.Trim(unsafeFileChars.ReplaceAllString(department, "-"), "-.")
+	if slug == "" {
+		return "department"
+	}
+	return slug
+}
+
+// renderPDF runs the -pdf-command for one HTML statement.
+func renderPDF(command, htmlPath, pdfPath string) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return fmt.Errorf("no command to render %s", pdfPath)
+	}
+	for i, arg := range args {
+		arg = strings.ReplaceAll(arg, "{html}", htmlPath)
+		args[i] = strings.ReplaceAll(arg, "{pdf}", pdfPath)
+	}
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("unable to render %s, %v: %s", pdfPath, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
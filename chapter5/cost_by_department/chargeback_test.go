@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderPDFRejectsBlankCommand(t *testing.T) {
+	for _, command := range []string{"", "   ", "\t"} {
+		if err := renderPDF(command, "in.html", "out.pdf"); err == nil {
+			t.Errorf("renderPDF(%q) succeeded, want an error", command)
+		}
+	}
+}
+
+func TestRunChargebackRejectsBlankPDFCommand(t *testing.T) {
+	if err := runChargeback([]string{"-pdf-command", "  ", "-source", "cur"}); err == nil {
+		t.Fatal("runChargeback with a blank -pdf-command succeeded, want an error")
+	}
+}
+
+func TestParseMonth(t *testing.T) {
+	now := time.Date(2024, time.March, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value                  string
+		priorStart, start, end string
+	}{
+		{"", "2024-01-01", "2024-02-01", "2024-03-01"},
+		{"2024-01", "2023-12-01", "2024-01-01", "2024-02-01"},
+	}
+	for _, tt := range tests {
+		period, err := parseMonth(tt.value, now)
+		if err != nil {
+			t.Fatalf("parseMonth(%q): %v", tt.value, err)
+		}
+		if period.priorStart != tt.priorStart || period.start != tt.start || period.end != tt.end {
+			t.Errorf("parseMonth(%q) = %s, %s, %s, want %s, %s, %s", tt.value,
+				period.priorStart, period.start, period.end, tt.priorStart, tt.start, tt.end)
+		}
+	}
+	if _, err := parseMonth("2024-13", now); err == nil {
+		t.Error("parseMonth(2024-13) succeeded, want an error")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+	"github.com/mlabouardy/finops-book/chapter5/focus"
+)
+
+// writeFocusFile writes FOCUS rows to path, or to stdout for "-".
+func writeFocusFile(format, path string, rows []focus.Row) error {
+	return writeFile(path, func(w io.Writer) error { return focus.Write(format, w, rows) })
+}
+
+// groupFocusRows converts Cost Explorer results grouped by the -group-by
+// keys, one of them SERVICE, into FOCUS rows. Tags and cost categories
+// become FOCUS tags, linked accounts sub accounts, regions regions and
+// usage types charge descriptions. BilledCost is the unblended cost and
+// EffectiveCost the amortized cost. Without negotiated or public rates,
+// ContractedCost and ListCost equal BilledCost.
+func groupFocusRows(results []types.ResultByTime, billing string, groups []types.GroupDefinition) ([]focus.Row, error) {
+	var rows []focus.Row
+	for _, result := range results {
+		start, err := time.Parse(dateLayout, aws.ToString(result.TimePeriod.Start))
+		if err != nil {
+			return nil, err
+		}
+		end, err := time.Parse(dateLayout, aws.ToString(result.TimePeriod.End))
+		if err != nil {
+			return nil, err
+		}
+		billingStart := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+		for _, group := range result.Groups {
+			if len(group.Keys) != len(groups) {
+				continue
+			}
+			billed, err := strconv.ParseFloat(aws.ToString(group.Metrics["UnblendedCost"].Amount), 64)
+			if err != nil {
+				return nil, err
+			}
+			effective, err := strconv.ParseFloat(aws.ToString(group.Metrics["AmortizedCost"].Amount), 64)
+			if err != nil {
+				return nil, err
+			}
+
+			row := focus.Row{
+				BilledCost:         billed,
+				BillingAccountID:   billing,
+				BillingCurrency:    "USD",
+				BillingPeriodStart: billingStart,
+				BillingPeriodEnd:   billingStart.AddDate(0, 1, 0),
+				ChargeCategory:     "Usage",
+				ChargePeriodStart:  start,
+				ChargePeriodEnd:    end,
+				ContractedCost:     billed,
+				EffectiveCost:      effective,
+				InvoiceIssuerName:  focus.Provider,
+				ListCost:           billed,
+				ProviderName:       focus.Provider,
+				PublisherName:      focus.Provider,
+			}
+			for i, definition := range groups {
+				value := groupValue(definition, group.Keys[i])
+				switch {
+				case definition.Type != types.GroupDefinitionTypeDimension:
+					if value != "" {
+						if row.Tags == nil {
+							row.Tags = make(map[string]string)
+						}
+						row.Tags[groupLabel(definition)] = value
+					}
+				case value == "":
+				case aws.ToString(definition.Key) == string(types.DimensionService):
+					row.ServiceName = value
+					row.ServiceCategory = focus.ServiceCategory(value)
+				case aws.ToString(definition.Key) == string(types.DimensionLinkedAccount):
+					row.SubAccountID = value
+				case aws.ToString(definition.Key) == string(types.DimensionRegion):
+					row.RegionID = value
+					row.RegionName = value
+				case aws.ToString(definition.Key) == string(types.DimensionUsageType):
+					row.ChargeDescription = value
+				}
+			}
+			if row.ServiceName == "Tax" {
+				row.ChargeCategory = "Tax"
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
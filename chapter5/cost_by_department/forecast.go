@@ -0,0 +1,401 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+	"github.com/mlabouardy/finops-book/chapter5/curdata"
+)
+
+// modelCostExplorer forecasts with GetCostForecast.
+const modelCostExplorer = "cost-explorer"
+
+var forecastModels = []string{modelCostExplorer, modelLinear, modelHoltWinters}
+
+// forecastWindow holds the dates a forecast covers: history runs up to
+// asOf (exclusive), the current month up to monthEnd and the next calendar
+// quarter from quarterStart to quarterEnd (exclusive).
+type forecastWindow struct {
+	historyStart time.Time
+	asOf         time.Time
+	monthStart   time.Time
+	monthEnd     time.Time
+	quarterStart time.Time
+	quarterEnd   time.Time
+}
+
+func newForecastWindow(asOf time.Time, historyDays int) forecastWindow {
+	w := forecastWindow{asOf: asOf}
+	w.monthStart = time.Date(asOf.Year(), asOf.Month(), 1, 0, 0, 0, 0, time.UTC)
+	w.monthEnd = w.monthStart.AddDate(0, 1, 0)
+	quarter := (int(asOf.Month()) - 1) / 3
+	w.quarterStart = time.Date(asOf.Year(), time.Month(quarter*3+1), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 3, 0)
+	w.quarterEnd = w.quarterStart.AddDate(0, 3, 0)
+	// The month to date is actual spend, even beyond -history
+	w.historyStart = asOf.AddDate(0, 0, -historyDays)
+	if w.monthStart.Before(w.historyStart) {
+		w.historyStart = w.monthStart
+	}
+	return w
+}
+
+// days counts the days from start to end.
+func days(start, end time.Time) int {
+	return int(end.Sub(start).Hours() / 24)
+}
+
+// forecastRow is the forecast of one department by one model. Backtest
+// fields compare a forecast of the last -holdout days of history, made
+// without them, to their actual cost.
+type forecastRow struct {
+	Department           string
+	Model                string
+	MonthToDate          float64
+	MonthEnd             *float64 `json:",omitempty"`
+	NextQuarter          *float64 `json:",omitempty"`
+	BacktestActual       *float64 `json:",omitempty"`
+	BacktestForecast     *float64 `json:",omitempty"`
+	BacktestErrorPercent *float64 `json:",omitempty"` // nil without actual cost
+	Error                string   `json:",omitempty"`
+}
+
+// runForecast implements the forecast subcommand: month-end and next-quarter
+// spend per department, from Cost Explorer and from local models over the
+// daily cost history, which also work offline with cached or CUR data.
+func runForecast(args []string) error {
+	fs := flag.NewFlagSet("forecast", flag.ExitOnError)
+	sourceOpts := addSourceFlags(fs)
+	fs.BoolVar(&sourceOpts.offline, "offline", false, "only use cached Cost Explorer responses, failing on a miss")
+	fs.StringVar(&sourceOpts.account, "account", "", "account whose cached responses to use (default the caller's), e.g. with -offline")
+	department := fs.String("department", "tag:Project", "grouping that names departments: tag:<key>, category:<cost category> or a dimension")
+	modelsFlag := fs.String("models", "", "comma-separated models: "+strings.Join(forecastModels, ", ")+" (default all the source supports; Cost Explorer bills each forecast request)")
+	asOfFlag := fs.String("as-of", "", "forecast from this date YYYY-MM-DD, using the history before it (default today; cost-explorer only forecasts from today)")
+	historyDays := fs.Int("history", 90, "days of daily cost history the local models learn from")
+	holdout := fs.Int("holdout", 14, "last days of history the local models are backtested on, 0 to skip")
+	format := fs.String("format", "text", "output format: text, csv, json or ndjson")
+	out := fs.String("out", "-", "output file, or - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cost_by_department forecast [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	groups, err := parseGroupBy(*department)
+	if err != nil {
+		return err
+	}
+	if len(groups) != 1 {
+		return fmt.Errorf("-department takes one grouping")
+	}
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	asOf := today
+	if *asOfFlag != "" {
+		if asOf, err = time.Parse(dateLayout, *asOfFlag); err != nil {
+			return fmt.Errorf("invalid -as-of %q, expected YYYY-MM-DD", *asOfFlag)
+		}
+	}
+	if *historyDays < 2*weekSeason {
+		return fmt.Errorf("-history needs at least %d days", 2*weekSeason)
+	}
+	if *holdout < 0 || *holdout > *historyDays-2*weekSeason {
+		return fmt.Errorf("-holdout must be between 0 and %d days for %d days of history", *historyDays-2*weekSeason, *historyDays)
+	}
+	switch *format {
+	case "text", "csv", "json", "ndjson":
+	default:
+		return fmt.Errorf("unsupported format %q, expected text, csv, json or ndjson", *format)
+	}
+
+	window := newForecastWindow(asOf, *historyDays)
+	client, _, closeSource, err := sourceOpts.open(context.TODO(), false, curdata.Period{Start: window.historyStart, End: window.asOf})
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+	forecaster, hasForecasts := client.(costForecastAPI)
+
+	var models []string
+	if *modelsFlag == "" {
+		models = []string{modelLinear, modelHoltWinters}
+		if hasForecasts && asOf.Equal(today) {
+			models = append([]string{modelCostExplorer}, models...)
+		}
+	}
+	for _, model := range strings.Split(*modelsFlag, ",") {
+		if model = strings.TrimSpace(model); model == "" {
+			continue
+		}
+		if !slices.Contains(forecastModels, model) {
+			return fmt.Errorf("unsupported model %q, expected %s", model, strings.Join(forecastModels, ", "))
+		}
+		if model == modelCostExplorer && !hasForecasts {
+			return fmt.Errorf("the %s model needs -source ce", modelCostExplorer)
+		}
+		if model == modelCostExplorer && !asOf.Equal(today) {
+			return fmt.Errorf("the %s model only forecasts from today, not -as-of %s", modelCostExplorer, *asOfFlag)
+		}
+		models = append(models, model)
+	}
+
+	history, err := dailyCosts(context.TODO(), client, groups, window.historyStart, window.asOf)
+	if err != nil {
+		return err
+	}
+
+	var rows []forecastRow
+	for _, department := range sortedDepartments(history, days(window.historyStart, window.monthStart)) {
+		series := history[department]
+		monthToDate := sum(series[days(window.historyStart, window.monthStart):])
+		for _, model := range models {
+			row := forecastRow{Department: department, Model: model, MonthToDate: monthToDate}
+			if model == modelCostExplorer {
+				err = costExplorerForecast(context.TODO(), forecaster, groups[0], department, window, &row)
+			} else {
+				err = localForecast(model, series[len(series)-*historyDays:], *holdout, window, &row)
+			}
+			if err != nil {
+				row.Error = err.Error()
+				fmt.Fprintf(os.Stderr, "Unable to forecast %s with %s: %v\n", department, model, err)
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	return writeFile(*out, func(w io.Writer) error {
+		if *format == "text" {
+			writeForecastText(w, groupLabel(groups[0]), window, *historyDays, *holdout, rows)
+			return nil
+		}
+		return writeForecastFlat(*format, w, rows)
+	})
+}
+
+// dailyCosts reads the daily cost series of every department from start to
+// end, with a zero for days without cost. It queries one calendar month at a
+// time, so closed months are served from the cache.
+func dailyCosts(ctx context.Context, client costExplorerAPI, groups []types.GroupDefinition, start, end time.Time) (map[string][]float64, error) {
+	series := make(map[string][]float64)
+	length := days(start, end)
+	for from := start; from.Before(end); {
+		to := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+		if to.After(end) {
+			to = end
+		}
+		input := &costexplorer.GetCostAndUsageInput{
+			TimePeriod: &types.DateInterval{
+				Start: aws.String(from.Format(dateLayout)),
+				End:   aws.String(to.Format(dateLayout)),
+			},
+			Granularity: types.GranularityDaily,
+			Metrics:     []string{"UnblendedCost"},
+			GroupBy:     groups,
+		}
+		results, err := getCostAndUsage(ctx, client, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cost and usage for %s to %s: %v", from.Format(dateLayout), to.Format(dateLayout), err)
+		}
+		costs, err := groupedCosts(results, groups, "UnblendedCost")
+		if err != nil {
+			return nil, fmt.Errorf("unable to read costs, %v", err)
+		}
+		for _, cost := range costs {
+			day, err := time.Parse(dateLayout, cost.Start)
+			if err != nil {
+				return nil, fmt.Errorf("invalid period start %q", cost.Start)
+			}
+			department := displayValue(groups[0], cost.Values[0])
+			if series[department] == nil {
+				series[department] = make([]float64, length)
+			}
+			if i := days(start, day); i >= 0 && i < length {
+				series[department][i] += cost.Cost
+			}
+		}
+		from = to
+	}
+	return series, nil
+}
+
+// sortedDepartments orders departments by their spend from the day at
+// index from, largest first.
+func sortedDepartments(history map[string][]float64, from int) []string {
+	totals := make(map[string]float64, len(history))
+	for department, series := range history {
+		totals[department] = sum(series[from:])
+	}
+	return largestFirst(totals)
+}
+
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
+
+// localForecast forecasts from the daily series with a local model and
+// backtests the model on the last holdout days.
+func localForecast(name string, series []float64, holdout int, window forecastWindow, row *forecastRow) error {
+	model, err := newForecastModel(name)
+	if err != nil {
+		return err
+	}
+	predicted, err := model.forecast(series, days(window.asOf, window.quarterEnd))
+	if err != nil {
+		return err
+	}
+	monthEnd := row.MonthToDate + sum(predicted[:days(window.asOf, window.monthEnd)])
+	nextQuarter := sum(predicted[days(window.asOf, window.quarterStart):])
+	row.MonthEnd, row.NextQuarter = &monthEnd, &nextQuarter
+
+	if holdout == 0 {
+		return nil
+	}
+	backtest, err := model.forecast(series[:len(series)-holdout], holdout)
+	if err != nil {
+		return err
+	}
+	actual, forecast := sum(series[len(series)-holdout:]), sum(backtest)
+	row.BacktestActual, row.BacktestForecast = &actual, &forecast
+	if actual != 0 {
+		errorPercent := (forecast - actual) / actual * 100
+		row.BacktestErrorPercent = &errorPercent
+	}
+	return nil
+}
+
+// costExplorerForecast asks Cost Explorer for the rest of the month and
+// the next quarter of one department.
+func costExplorerForecast(ctx context.Context, client costForecastAPI, group types.GroupDefinition, department string, window forecastWindow, row *forecastRow) error {
+	filter := departmentFilter(group, department)
+	forecast := func(start, end time.Time) (float64, error) {
+		resp, err := client.GetCostForecast(ctx, &costexplorer.GetCostForecastInput{
+			TimePeriod: &types.DateInterval{
+				Start: aws.String(start.Format(dateLayout)),
+				End:   aws.String(end.Format(dateLayout)),
+			},
+			Granularity: types.GranularityMonthly,
+			Metric:      types.MetricUnblendedCost,
+			Filter:      filter,
+		})
+		if err != nil {
+			return 0, err
+		}
+		if resp.Total == nil {
+			return 0, fmt.Errorf("no forecast total")
+		}
+		return strconv.ParseFloat(aws.ToString(resp.Total.Amount), 64)
+	}
+
+	rest, err := forecast(window.asOf, window.monthEnd)
+	if err != nil {
+		return err
+	}
+	monthEnd := row.MonthToDate + rest
+	row.MonthEnd = &monthEnd
+	nextQuarter, err := forecast(window.quarterStart, window.quarterEnd)
+	if err != nil {
+		return err
+	}
+	row.NextQuarter = &nextQuarter
+	return nil
+}
+
+// departmentFilter selects the costs of one department, as displayed.
+func departmentFilter(group types.GroupDefinition, department string) *types.Expression {
+	values := []string{department}
+	var options []types.MatchOption
+	if department == displayValue(group, "") {
+		values, options = nil, []types.MatchOption{types.MatchOptionAbsent}
+	}
+	switch group.Type {
+	case types.GroupDefinitionTypeTag:
+		return &types.Expression{Tags: &types.TagValues{Key: group.Key, Values: values, MatchOptions: options}}
+	case types.GroupDefinitionTypeCostCategory:
+		return &types.Expression{CostCategories: &types.CostCategoryValues{Key: group.Key, Values: values, MatchOptions: options}}
+	}
+	return &types.Expression{Dimensions: &types.DimensionValues{Key: types.Dimension(aws.ToString(group.Key)), Values: []string{department}}}
+}
+
+// writeForecastText prints the forecasts of each department, one model per
+// line.
+func writeForecastText(w io.Writer, label string, window forecastWindow, historyDays, holdout int, rows []forecastRow) {
+	fmt.Fprintf(w, "Forecast as of %s from %d days of history: month end %s, next quarter %s to %s\n",
+		window.asOf.Format(dateLayout), historyDays, window.monthEnd.AddDate(0, 0, -1).Format(dateLayout),
+		window.quarterStart.Format(dateLayout), window.quarterEnd.AddDate(0, 0, -1).Format(dateLayout))
+	for i, row := range rows {
+		if i == 0 || row.Department != rows[i-1].Department {
+			fmt.Fprintf(w, "%s: %s, Month to date: %s\n", label, row.Department, money(row.MonthToDate))
+		}
+		if row.Error != "" {
+			fmt.Fprintf(w, "  %-14s unavailable: %s\n", row.Model+":", row.Error)
+			continue
+		}
+		fmt.Fprintf(w, "  %-14s Month end: %s, Next quarter: %s", row.Model+":", money(*row.MonthEnd), money(*row.NextQuarter))
+		switch {
+		case row.BacktestErrorPercent != nil:
+			fmt.Fprintf(w, ", Backtest error: %+.1f%% over %d days", *row.BacktestErrorPercent, holdout)
+		case row.BacktestActual != nil:
+			fmt.Fprintf(w, ", Backtest: %s forecast for no actual cost over %d days", money(*row.BacktestForecast), holdout)
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// writeForecastFlat writes one row per department and model as CSV, a JSON
+// array or NDJSON.
+func writeForecastFlat(format string, w io.Writer, rows []forecastRow) error {
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		header := []string{"Department", "Model", "MonthToDate", "MonthEnd", "NextQuarter", "BacktestActual", "BacktestForecast", "BacktestErrorPercent", "Error"}
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+		cell := func(v *float64) string {
+			if v == nil {
+				return ""
+			}
+			return fmt.Sprintf("%.2f", *v)
+		}
+		for _, r := range rows {
+			record := []string{r.Department, r.Model, fmt.Sprintf("%.2f", r.MonthToDate), cell(r.MonthEnd), cell(r.NextQuarter),
+				cell(r.BacktestActual), cell(r.BacktestForecast), cell(r.BacktestErrorPercent), r.Error}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if rows == nil {
+			rows = []forecastRow{}
+		}
+		return encoder.Encode(rows)
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		for _, r := range rows {
+			if err := encoder.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported format %q, expected text, csv, json or ndjson", format)
+}
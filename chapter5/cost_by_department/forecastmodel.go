@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Local forecasting models
+const (
+	modelLinear      = "linear"       // least-squares trend line
+	modelHoltWinters = "holt-winters" // additive trend and weekly season
+)
+
+// weekSeason is the season of daily costs: weekdays and weekends differ.
+const weekSeason = 7
+
+// forecastModel predicts the next days of a daily cost series.
+type forecastModel interface {
+	// forecast returns the next days of series, never below zero.
+	forecast(series []float64, days int) ([]float64, error)
+}
+
+func newForecastModel(name string) (forecastModel, error) {
+	switch name {
+	case modelLinear:
+		return linearTrend{}, nil
+	case modelHoltWinters:
+		return holtWinters{season: weekSeason}, nil
+	}
+	return nil, fmt.Errorf("unsupported model %q, expected %s or %s", name, modelLinear, modelHoltWinters)
+}
+
+// linearTrend extends the least-squares line through the series.
+type linearTrend struct{}
+
+func (linearTrend) forecast(series []float64, days int) ([]float64, error) {
+	n := float64(len(series))
+	if len(series) < 2 {
+		return nil, fmt.Errorf("linear trend needs 2 days of history, got %d", len(series))
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i, y := range series {
+		x := float64(i)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	slope := (n*sumXY - sumX*sumY) / (n*sumXX - sumX*sumX)
+	intercept := (sumY - slope*sumX) / n
+
+	predicted := make([]float64, days)
+	for h := range predicted {
+		predicted[h] = math.Max(0, intercept+slope*float64(len(series)+h))
+	}
+	return predicted, nil
+}
+
+// holtWinters is additive triple exponential smoothing. Its smoothing
+// factors are the ones with the smallest one-step-ahead squared error over
+// the series.
+type holtWinters struct {
+	season int
+}
+
+// Smoothing factors tried when fitting: level and season, then trend
+var (
+	holtWintersFactors      = []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}
+	holtWintersTrendFactors = []float64{0.01, 0.05, 0.1, 0.2, 0.3}
+)
+
+func (m holtWinters) forecast(series []float64, days int) ([]float64, error) {
+	if len(series) < 2*m.season {
+		return nil, fmt.Errorf("holt-winters needs %d days of history, got %d", 2*m.season, len(series))
+	}
+
+	best := math.Inf(1)
+	var predicted []float64
+	for _, alpha := range holtWintersFactors {
+		for _, beta := range holtWintersTrendFactors {
+			for _, gamma := range holtWintersFactors {
+				sse, p := m.smooth(series, days, alpha, beta, gamma)
+				if sse < best {
+					best, predicted = sse, p
+				}
+			}
+		}
+	}
+	for h := range predicted {
+		predicted[h] = math.Max(0, predicted[h])
+	}
+	return predicted, nil
+}
+
+// smooth runs the model with the given factors and returns its one-step
+// squared error over the series and its forecast of the next days.
+func (m holtWinters) smooth(series []float64, days int, alpha, beta, gamma float64) (float64, []float64) {
+	// The first two seasons set the initial level, trend and season
+	var first, second float64
+	for i := 0; i < m.season; i++ {
+		first += series[i]
+		second += series[m.season+i]
+	}
+	first /= float64(m.season)
+	second /= float64(m.season)
+	level := first
+	trend := (second - first) / float64(m.season)
+	seasonal := make([]float64, m.season)
+	for i := range seasonal {
+		seasonal[i] = series[i] - first
+	}
+
+	var sse float64
+	for t := m.season; t < len(series); t++ {
+		s := seasonal[t%m.season]
+		predicted := level + trend + s
+		sse += (series[t] - predicted) * (series[t] - predicted)
+
+		previous := level
+		level = alpha*(series[t]-s) + (1-alpha)*(level+trend)
+		trend = beta*(level-previous) + (1-beta)*trend
+		seasonal[t%m.season] = gamma*(series[t]-level) + (1-gamma)*s
+	}
+
+	forecast := make([]float64, days)
+	for h := range forecast {
+		t := len(series) + h
+		forecast[h] = level + float64(h+1)*trend + seasonal[t%m.season]
+	}
+	return sse, forecast
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// series builds n days of cost from the day index.
+func series(n int, cost func(day int) float64) []float64 {
+	values := make([]float64, n)
+	for day := range values {
+		values[day] = cost(day)
+	}
+	return values
+}
+
+// weekly is a week of weekday spend with quiet weekends.
+func weekly(day int) float64 {
+	if day%weekSeason >= 5 {
+		return 20
+	}
+	return 100
+}
+
+func TestForecastModels(t *testing.T) {
+	tests := []struct {
+		name      string
+		model     string
+		series    []float64
+		days      int
+		want      func(day int) float64 // expected cost of future days, from the day after the series
+		tolerance float64
+	}{
+		{"linear constant", modelLinear, series(28, func(int) float64 { return 10 }), 7,
+			func(int) float64 { return 10 }, 1e-9},
+		{"linear trend", modelLinear, series(28, func(day int) float64 { return 5 + 2*float64(day) }), 7,
+			func(day int) float64 { return 5 + 2*float64(28+day) }, 1e-9},
+		{"linear decline stops at zero", modelLinear, series(10, func(day int) float64 { return 9 - float64(day) }), 5,
+			func(int) float64 { return 0 }, 1e-9},
+		{"holt-winters constant", modelHoltWinters, series(28, func(int) float64 { return 10 }), 14,
+			func(int) float64 { return 10 }, 1e-6},
+		{"holt-winters trend", modelHoltWinters, series(56, func(day int) float64 { return 50 + float64(day) }), 7,
+			func(day int) float64 { return 50 + float64(56+day) }, 1},
+		{"holt-winters weekly season", modelHoltWinters, series(56, weekly), 14,
+			func(day int) float64 { return weekly(56 + day) }, 1e-6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := newForecastModel(tt.model)
+			if err != nil {
+				t.Fatal(err)
+			}
+			predicted, err := model.forecast(tt.series, tt.days)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(predicted) != tt.days {
+				t.Fatalf("forecast %d days, want %d", len(predicted), tt.days)
+			}
+			for day, got := range predicted {
+				if want := tt.want(day); math.Abs(got-want) > tt.tolerance {
+					t.Errorf("day %d = %.6f, want %.6f", day, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestForecastModelsShortSeries(t *testing.T) {
+	tests := []struct {
+		model  string
+		series []float64
+	}{
+		{modelLinear, nil},
+		{modelLinear, []float64{4}},
+		{modelHoltWinters, series(weekSeason-1, weekly)}, // less than one season
+		{modelHoltWinters, series(2*weekSeason-1, weekly)},
+	}
+	for _, tt := range tests {
+		model, err := newForecastModel(tt.model)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := model.forecast(tt.series, 7); err == nil {
+			t.Errorf("%s forecast from %d days, want an error", tt.model, len(tt.series))
+		}
+	}
+	if _, err := newForecastModel("arima"); err == nil {
+		t.Error("unsupported model accepted")
+	}
+}
+
+func TestHoltWintersGridSearch(t *testing.T) {
+	// A season growing week over week, with noise the factors have to
+	// smooth out
+	noise := []float64{3, -2, 0, 4, -3, 1, -1}
+	history := series(42, func(day int) float64 {
+		return weekly(day) + float64(day/weekSeason)*5 + noise[(day*3)%len(noise)]
+	})
+	m := holtWinters{season: weekSeason}
+	predicted, err := m.forecast(history, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The forecast is the one of the factors with the smallest error
+	best := math.Inf(1)
+	var want []float64
+	for _, alpha := range holtWintersFactors {
+		for _, beta := range holtWintersTrendFactors {
+			for _, gamma := range holtWintersFactors {
+				sse, p := m.smooth(history, 7, alpha, beta, gamma)
+				if sse < best {
+					best, want = sse, p
+				}
+			}
+		}
+	}
+	for day := range predicted {
+		if predicted[day] != math.Max(0, want[day]) {
+			t.Errorf("day %d = %v, want %v of the best factors", day, predicted[day], want[day])
+		}
+	}
+
+	// and beats factors that barely learn
+	if sse, _ := m.smooth(history, 7, 0.1, 0.01, 0.1); sse <= best {
+		t.Errorf("slow factors have an error of %v, not above the best %v", sse, best)
+	}
+}
+
+func TestLocalForecastBacktest(t *testing.T) {
+	asOf := time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)
+	window := newForecastWindow(asOf, 28)
+	trend := series(28, func(day int) float64 { return 10 + float64(day) })
+
+	tests := []struct {
+		name      string
+		series    []float64
+		holdout   int
+		actual    float64
+		forecast  float64
+		errorRate *float64
+	}{
+		{"no holdout", trend, 0, 0, 0, nil},
+		{"perfect trend", trend, 7, 7 * (10 + 24), 7 * (10 + 24), ptr(0)},
+		{"flat history then a jump", append(series(21, func(int) float64 { return 10 }), series(7, func(int) float64 { return 20 })...),
+			7, 140, 70, ptr(-50)},
+		{"no actual cost", append(series(21, func(int) float64 { return 10 }), make([]float64, 7)...), 7, 0, 70, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := forecastRow{MonthToDate: 100}
+			if err := localForecast(modelLinear, tt.series, tt.holdout, window, &row); err != nil {
+				t.Fatal(err)
+			}
+			if row.MonthEnd == nil || row.NextQuarter == nil {
+				t.Fatalf("row = %+v, want month-end and next-quarter forecasts", row)
+			}
+			if *row.MonthEnd < row.MonthToDate {
+				t.Errorf("month end %v is below the month to date %v", *row.MonthEnd, row.MonthToDate)
+			}
+			if tt.holdout == 0 {
+				if row.BacktestActual != nil || row.BacktestForecast != nil || row.BacktestErrorPercent != nil {
+					t.Errorf("row = %+v, want no backtest without holdout", row)
+				}
+				return
+			}
+			if math.Abs(*row.BacktestActual-tt.actual) > 1e-9 || math.Abs(*row.BacktestForecast-tt.forecast) > 1e-9 {
+				t.Errorf("backtest = %v forecast against %v actual, want %v against %v",
+					*row.BacktestForecast, *row.BacktestActual, tt.forecast, tt.actual)
+			}
+			switch {
+			case tt.errorRate == nil && row.BacktestErrorPercent != nil:
+				t.Errorf("error = %v%%, want none without actual cost", *row.BacktestErrorPercent)
+			case tt.errorRate != nil && (row.BacktestErrorPercent == nil || math.Abs(*row.BacktestErrorPercent-*tt.errorRate) > 1e-9):
+				t.Errorf("error = %v, want %v%%", row.BacktestErrorPercent, *tt.errorRate)
+			}
+		})
+	}
+}
+
+func ptr(v float64) *float64 {
+	return &v
+}
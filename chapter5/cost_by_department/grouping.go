@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+// groupDimensions are the dimensions -group-by accepts.
+var groupDimensions = []types.Dimension{
+	types.DimensionService,
+	types.DimensionLinkedAccount,
+	types.DimensionRegion,
+	types.DimensionUsageType,
+}
+
+// maxGroupBy is the most groupings one Cost Explorer request accepts.
+const maxGroupBy = 2
+
+// parseGroupBy parses -group-by: up to two comma-separated keys, each
+// "tag:<key>", "category:<cost category>" or a dimension name such as
+// SERVICE.
+func parseGroupBy(value string) ([]types.GroupDefinition, error) {
+	var groups []types.GroupDefinition
+	seen := make(map[string]bool)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		var group types.GroupDefinition
+		kind, key, found := strings.Cut(item, ":")
+		switch {
+		case found && strings.EqualFold(kind, "tag") && key != "":
+			group = types.GroupDefinition{Type: types.GroupDefinitionTypeTag, Key: aws.String(key)}
+		case found && strings.EqualFold(kind, "category") && key != "":
+			group = types.GroupDefinition{Type: types.GroupDefinitionTypeCostCategory, Key: aws.String(key)}
+		case !found || strings.EqualFold(kind, "dimension"):
+			if found {
+				item = key
+			}
+			dimension := types.Dimension(strings.ToUpper(item))
+			valid := false
+			for _, d := range groupDimensions {
+				valid = valid || d == dimension
+			}
+			if !valid {
+				return nil, fmt.Errorf("unsupported dimension %q in -group-by, expected tag:<key>, category:<name> or one of %s", item, joinDimensions(groupDimensions))
+			}
+			group = types.GroupDefinition{Type: types.GroupDefinitionTypeDimension, Key: aws.String(string(dimension))}
+		default:
+			return nil, fmt.Errorf("invalid -group-by key %q, expected tag:<key>, category:<name> or a dimension", item)
+		}
+
+		id := string(group.Type) + ":" + aws.ToString(group.Key)
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate -group-by key %q", item)
+		}
+		seen[id] = true
+		groups = append(groups, group)
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("at least one -group-by key is required")
+	}
+	if len(groups) > maxGroupBy {
+		return nil, fmt.Errorf("at most %d -group-by keys are supported", maxGroupBy)
+	}
+	return groups, nil
+}
+
+func joinDimensions(dimensions []types.Dimension) string {
+	names := make([]string, len(dimensions))
+	for i, d := range dimensions {
+		names[i] = string(d)
+	}
+	return strings.Join(names, ", ")
+}
+
+// groupLabel names a grouping: the tag key, cost category or dimension.
+func groupLabel(group types.GroupDefinition) string {
+	return aws.ToString(group.Key)
+}
+
+// groupLabels names every grouping, e.g. "Project, SERVICE".
+func groupLabels(groups []types.GroupDefinition) string {
+	labels := make([]string, len(groups))
+	for i, group := range groups {
+		labels[i] = groupLabel(group)
+	}
+	return strings.Join(labels, ", ")
+}
+
+// groupValue extracts the value of a group key. Cost Explorer returns tag
+// and cost category keys as "Key$value", with an empty value when unset.
+func groupValue(group types.GroupDefinition, key string) string {
+	if group.Type == types.GroupDefinitionTypeDimension {
+		return key
+	}
+	return strings.TrimPrefix(key, aws.ToString(group.Key)+"$")
+}
+
+// getCostAndUsage pages through a request and merges the groups of every
+// page into one result per period.
+func getCostAndUsage(ctx context.Context, client costExplorerAPI, input *costexplorer.GetCostAndUsageInput) ([]types.ResultByTime, error) {
+	params := *input
+	var results []types.ResultByTime
+	index := make(map[string]int)
+	for {
+		resp, err := client.GetCostAndUsage(ctx, &params)
+		if err != nil {
+			return nil, err
+		}
+		for _, result := range resp.ResultsByTime {
+			start := aws.ToString(result.TimePeriod.Start)
+			if i, ok := index[start]; ok {
+				results[i].Groups = append(results[i].Groups, result.Groups...)
+				continue
+			}
+			index[start] = len(results)
+			results = append(results, result)
+		}
+		if resp.NextPageToken == nil {
+			return results, nil
+		}
+		params.NextPageToken = resp.NextPageToken
+	}
+}
+
+// groupedCost is the cost of one group in one period.
+type groupedCost struct {
+	Start         string
+	End           string
+	Values        []string // one per -group-by key
+	Cost          float64
+	AllocatedFrom string // allocation rule the cost was received through
+}
+
+// groupedCosts reads the groups of every period.
+func groupedCosts(results []types.ResultByTime, groups []types.GroupDefinition, metric string) ([]groupedCost, error) {
+	var costs []groupedCost
+	for _, result := range results {
+		for _, group := range result.Groups {
+			if len(group.Keys) != len(groups) {
+				continue
+			}
+			cost, err := strconv.ParseFloat(aws.ToString(group.Metrics[metric].Amount), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s for %s: %v", metric, strings.Join(group.Keys, ", "), err)
+			}
+			values := make([]string, len(groups))
+			for i, key := range group.Keys {
+				values[i] = groupValue(groups[i], key)
+			}
+			costs = append(costs, groupedCost{
+				Start:  aws.ToString(result.TimePeriod.Start),
+				End:    aws.ToString(result.TimePeriod.End),
+				Values: values,
+				Cost:   cost,
+			})
+		}
+	}
+	return costs, nil
+}
+
+// Labels of costs without a tag or cost category value
+const (
+	untaggedLabel      = "(untagged)"
+	uncategorizedLabel = "(uncategorized)"
+)
+
+// displayValue labels the empty value of a tag or cost category, which
+// Cost Explorer returns as a bare "Key$".
+func displayValue(group types.GroupDefinition, value string) string {
+	if value != "" {
+		return value
+	}
+	switch group.Type {
+	case types.GroupDefinitionTypeTag:
+		return untaggedLabel
+	case types.GroupDefinitionTypeCostCategory:
+		return uncategorizedLabel
+	}
+	return value
+}
+
+// writeNestedTable prints the groups of each period as a tree: the totals
+// of the first key, each above the costs of the second key within it,
+// largest first. Allocated costs are listed apart under the department
+// that received them.
+func writeNestedTable(w io.Writer, results []types.ResultByTime, costs []groupedCost, groups []types.GroupDefinition) {
+	for _, result := range results {
+		start := aws.ToString(result.TimePeriod.Start)
+		fmt.Fprintf(w, "Time period: %s to %s\n", start, aws.ToString(result.TimePeriod.End))
+
+		// Children are keyed by second value and allocation rule
+		totals := make(map[string]float64)
+		children := make(map[string]map[[2]string]float64)
+		for _, cost := range costs {
+			if cost.Start != start {
+				continue
+			}
+			parent := displayValue(groups[0], cost.Values[0])
+			totals[parent] += cost.Cost
+			if children[parent] == nil {
+				children[parent] = make(map[[2]string]float64)
+			}
+			var child string
+			if len(groups) > 1 {
+				child = displayValue(groups[1], cost.Values[1])
+			}
+			children[parent][[2]string{child, cost.AllocatedFrom}] += cost.Cost
+		}
+
+		for _, parent := range largestFirst(totals) {
+			fmt.Fprintf(w, "%s: %s, Cost: $%.2f\n", groupLabel(groups[0]), parent, totals[parent])
+			nested := children[parent]
+			keys := make([][2]string, 0, len(nested))
+			for key := range nested {
+				keys = append(keys, key)
+			}
+			sort.Slice(keys, func(i, j int) bool {
+				if nested[keys[i]] != nested[keys[j]] {
+					return nested[keys[i]] > nested[keys[j]]
+				}
+				if keys[i][0] != keys[j][0] {
+					return keys[i][0] < keys[j][0]
+				}
+				return keys[i][1] < keys[j][1]
+			})
+			for _, key := range keys {
+				child, rule := key[0], key[1]
+				switch {
+				case len(groups) > 1 && rule != "":
+					fmt.Fprintf(w, "  %s: %s, Cost: $%.2f (allocated by %s)\n", groupLabel(groups[1]), child, nested[key], rule)
+				case len(groups) > 1:
+					fmt.Fprintf(w, "  %s: %s, Cost: $%.2f\n", groupLabel(groups[1]), child, nested[key])
+				case rule != "":
+					fmt.Fprintf(w, "  Allocated by %s: $%.2f\n", rule, nested[key])
+				}
+			}
+		}
+	}
+}
+
+// largestFirst sorts the keys of totals by descending amount, then by key.
+func largestFirst(totals map[string]float64) []string {
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if totals[keys[i]] != totals[keys[j]] {
+			return totals[keys[i]] > totals[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// writeFlat writes one row per period and group, with a column per
+// -group-by key, as CSV, a JSON array or NDJSON, ready for a pivot table.
+// With allocation rules, AllocatedFrom names the rule behind each
+// allocated cost.
+func writeFlat(format string, w io.Writer, costs []groupedCost, groups []types.GroupDefinition, metric string, allocated bool) error {
+	header := []string{"PeriodStart", "PeriodEnd"}
+	for _, group := range groups {
+		header = append(header, groupLabel(group))
+	}
+	header = append(header, metric)
+	if allocated {
+		header = append(header, "AllocatedFrom")
+	}
+
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+		for _, cost := range costs {
+			record := []string{cost.Start, cost.End}
+			for g, value := range cost.Values {
+				record = append(record, displayValue(groups[g], value))
+			}
+			record = append(record, strconv.FormatFloat(cost.Cost, 'f', -1, 64))
+			if allocated {
+				record = append(record, cost.AllocatedFrom)
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case "json", "ndjson":
+		objects := make([]map[string]any, len(costs))
+		for i, cost := range costs {
+			object := map[string]any{"PeriodStart": cost.Start, "PeriodEnd": cost.End, metric: cost.Cost}
+			for g, value := range cost.Values {
+				object[header[2+g]] = displayValue(groups[g], value)
+			}
+			if allocated {
+				object["AllocatedFrom"] = cost.AllocatedFrom
+			}
+			objects[i] = object
+		}
+		encoder := json.NewEncoder(w)
+		if format == "json" {
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(objects)
+		}
+		for _, object := range objects {
+			if err := encoder.Encode(object); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported format %q, expected csv, json or ndjson", format)
+	}
+}
+
+// writeFile writes to path, or to stdout for "-".
+func writeFile(path string, write func(w io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+func TestParseGroupBy(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string // type:key of each grouping
+		wantErr string
+	}{
+		{"tag:Project", []string{"TAG:Project"}, ""},
+		{"Tag:cost-center", []string{"TAG:cost-center"}, ""},
+		{"category:Team", []string{"COST_CATEGORY:Team"}, ""},
+		{"SERVICE", []string{"DIMENSION:SERVICE"}, ""},
+		{"linked_account", []string{"DIMENSION:LINKED_ACCOUNT"}, ""},
+		{"dimension:region", []string{"DIMENSION:REGION"}, ""},
+		{" tag:Project , SERVICE ", []string{"TAG:Project", "DIMENSION:SERVICE"}, ""},
+		{"tag:Project,,USAGE_TYPE", []string{"TAG:Project", "DIMENSION:USAGE_TYPE"}, ""},
+		{"tag:Project,SERVICE,REGION", nil, "at most 2 -group-by keys"},
+		{"INSTANCE_TYPE", nil, `unsupported dimension "INSTANCE_TYPE"`},
+		{"tag:", nil, `invalid -group-by key "tag:"`},
+		{"label:Project", nil, `invalid -group-by key "label:Project"`},
+		{"SERVICE,service", nil, `duplicate -group-by key "service"`},
+		{" , ", nil, "at least one -group-by key is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			groups, err := parseGroupBy(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseGroupBy(%q) error = %v, want %q", tt.value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, len(groups))
+			for i, group := range groups {
+				got[i] = string(group.Type) + ":" + aws.ToString(group.Key)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("parseGroupBy(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupValue(t *testing.T) {
+	project := types.GroupDefinition{Type: types.GroupDefinitionTypeTag, Key: aws.String("Project")}
+	team := types.GroupDefinition{Type: types.GroupDefinitionTypeCostCategory, Key: aws.String("Team")}
+	service := types.GroupDefinition{Type: types.GroupDefinitionTypeDimension, Key: aws.String("SERVICE")}
+
+	tests := []struct {
+		name        string
+		group       types.GroupDefinition
+		key         string
+		want        string
+		wantDisplay string
+	}{
+		{"tag value", project, "Project$checkout", "checkout", "checkout"},
+		{"empty tag value", project, "Project$", "", untaggedLabel},
+		{"tag value with separator", project, "Project$a$b", "a$b", "a$b"},
+		{"cost category value", team, "Team$platform", "platform", "platform"},
+		{"empty cost category value", team, "Team$", "", uncategorizedLabel},
+		{"dimension", service, "Amazon Simple Storage Service", "Amazon Simple Storage Service", "Amazon Simple Storage Service"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := groupValue(tt.group, tt.key)
+			if got != tt.want {
+				t.Errorf("groupValue(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+			if display := displayValue(tt.group, got); display != tt.wantDisplay {
+				t.Errorf("displayValue(%q) = %q, want %q", got, display, tt.wantDisplay)
+			}
+		})
+	}
+}
+
+func TestWriteFlat(t *testing.T) {
+	groups := []types.GroupDefinition{
+		{Type: types.GroupDefinitionTypeTag, Key: aws.String("Project")},
+		{Type: types.GroupDefinitionTypeDimension, Key: aws.String("SERVICE")},
+	}
+	costs := []groupedCost{
+		{Start: "2024-05-01", End: "2024-06-01", Values: []string{"checkout", "Amazon EC2"}, Cost: 12.5},
+		{Start: "2024-05-01", End: "2024-06-01", Values: []string{"", "Amazon S3, Glacier"}, Cost: 3, AllocatedFrom: "shared"},
+	}
+
+	tests := []struct {
+		format    string
+		allocated bool
+		want      string
+	}{
+		{"csv", false, "PeriodStart,PeriodEnd,Project,SERVICE,UnblendedCost\n" +
+			"2024-05-01,2024-06-01,checkout,Amazon EC2,12.5\n" +
+			"2024-05-01,2024-06-01,(untagged),\"Amazon S3, Glacier\",3\n"},
+		{"csv", true, "PeriodStart,PeriodEnd,Project,SERVICE,UnblendedCost,AllocatedFrom\n" +
+			"2024-05-01,2024-06-01,checkout,Amazon EC2,12.5,\n" +
+			"2024-05-01,2024-06-01,(untagged),\"Amazon S3, Glacier\",3,shared\n"},
+		{"ndjson", false, `{"PeriodEnd":"2024-06-01","PeriodStart":"2024-05-01","Project":"checkout","SERVICE":"Amazon EC2","UnblendedCost":12.5}` + "\n" +
+			`{"PeriodEnd":"2024-06-01","PeriodStart":"2024-05-01","Project":"(untagged)","SERVICE":"Amazon S3, Glacier","UnblendedCost":3}` + "\n"},
+		{"ndjson", true, `{"AllocatedFrom":"","PeriodEnd":"2024-06-01","PeriodStart":"2024-05-01","Project":"checkout","SERVICE":"Amazon EC2","UnblendedCost":12.5}` + "\n" +
+			`{"AllocatedFrom":"shared","PeriodEnd":"2024-06-01","PeriodStart":"2024-05-01","Project":"(untagged)","SERVICE":"Amazon S3, Glacier","UnblendedCost":3}` + "\n"},
+		{"json", false, "[\n" +
+			"  {\n    \"PeriodEnd\": \"2024-06-01\",\n    \"PeriodStart\": \"2024-05-01\",\n    \"Project\": \"checkout\",\n    \"SERVICE\": \"Amazon EC2\",\n    \"UnblendedCost\": 12.5\n  },\n" +
+			"  {\n    \"PeriodEnd\": \"2024-06-01\",\n    \"PeriodStart\": \"2024-05-01\",\n    \"Project\": \"(untagged)\",\n    \"SERVICE\": \"Amazon S3, Glacier\",\n    \"UnblendedCost\": 3\n  }\n" +
+			"]\n"},
+	}
+	for _, tt := range tests {
+		var b strings.Builder
+		if err := writeFlat(tt.format, &b, costs, groups, "UnblendedCost", tt.allocated); err != nil {
+			t.Fatalf("writeFlat(%s): %v", tt.format, err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("writeFlat(%s, allocated %v) =\n%s\nwant\n%s", tt.format, tt.allocated, b.String(), tt.want)
+		}
+	}
+
+	if err := writeFlat("xml", &strings.Builder{}, costs, groups, "UnblendedCost", false); err == nil {
+		t.Error("writeFlat(xml) succeeded, want an error")
+	}
+}
+
+func TestWriteNestedTable(t *testing.T) {
+	results := []types.ResultByTime{{TimePeriod: &types.DateInterval{Start: aws.String("2024-05-01"), End: aws.String("2024-06-01")}}}
+	project := types.GroupDefinition{Type: types.GroupDefinitionTypeTag, Key: aws.String("Project")}
+	service := types.GroupDefinition{Type: types.GroupDefinitionTypeDimension, Key: aws.String("SERVICE")}
+	costs := []groupedCost{
+		{Start: "2024-05-01", Values: []string{"checkout", "EC2"}, Cost: 10},
+		{Start: "2024-05-01", Values: []string{"checkout", "S3"}, Cost: 2},
+		{Start: "2024-05-01", Values: []string{"checkout", "EC2"}, Cost: 5, AllocatedFrom: "shared"},
+		{Start: "2024-05-01", Values: []string{"search", "EC2"}, Cost: 20},
+		{Start: "2024-05-01", Values: []string{"", "S3"}, Cost: 1},
+		{Start: "2024-04-01", Values: []string{"search", "EC2"}, Cost: 100},
+	}
+
+	tests := []struct {
+		name   string
+		groups []types.GroupDefinition
+		costs  []groupedCost
+		want   string
+	}{
+		{"two keys", []types.GroupDefinition{project, service}, costs, "Time period: 2024-05-01 to 2024-06-01\n" +
+			"Project: search, Cost: $20.00\n" +
+			"  SERVICE: EC2, Cost: $20.00\n" +
+			"Project: checkout, Cost: $17.00\n" +
+			"  SERVICE: EC2, Cost: $10.00\n" +
+			"  SERVICE: EC2, Cost: $5.00 (allocated by shared)\n" +
+			"  SERVICE: S3, Cost: $2.00\n" +
+			"Project: (untagged), Cost: $1.00\n" +
+			"  SERVICE: S3, Cost: $1.00\n"},
+		{"one key", []types.GroupDefinition{project}, []groupedCost{
+			{Start: "2024-05-01", Values: []string{"checkout"}, Cost: 10},
+			{Start: "2024-05-01", Values: []string{"checkout"}, Cost: 5, AllocatedFrom: "shared"},
+			{Start: "2024-05-01", Values: []string{"search"}, Cost: 15},
+		}, "Time period: 2024-05-01 to 2024-06-01\n" +
+			"Project: checkout, Cost: $15.00\n" +
+			"  Allocated by shared: $5.00\n" +
+			"Project: search, Cost: $15.00\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			writeNestedTable(&b, results, tt.costs, tt.groups)
+			if b.String() != tt.want {
+				t.Errorf("writeNestedTable =\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
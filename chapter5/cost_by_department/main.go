@@ -2,33 +2,110 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"slices"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
 	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/mlabouardy/finops-book/chapter5/curdata"
+	"github.com/mlabouardy/finops-book/chapter5/focus"
 )
 
 func main() {
-	// Load the AWS configuration (from environment, shared config, etc.)
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
+	// Subcommands come before any flag
+	if len(os.Args) > 1 {
+		subcommands := map[string]func([]string) error{
+			"chargeback": runChargeback,
+			"variance":   runVariance,
+			"forecast":   runForecast,
+		}
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatalf("%v", err)
+			}
+			return
+		}
 	}
 
-	// Create a Cost Explorer client
-	client := costexplorer.NewFromConfig(cfg)
+	sourceOpts := addSourceFlags(flag.CommandLine)
+	groupBy := flag.String("group-by", "tag:Project", "up to two comma-separated groupings: tag:<key>, category:<cost category> or a dimension ("+joinDimensions(groupDimensions)+"), e.g. tag:Project,SERVICE")
+	layout := flag.String("layout", "nested", "report layout: nested (table on stdout) or flat (one row per period and group, in -format to -out)")
+	writeFocus := flag.Bool("focus", false, "write FOCUS 1.0 rows, one per group, service and month, instead of the report")
+	format := flag.String("format", "csv", "flat and FOCUS output format: csv, json or ndjson")
+	out := flag.String("out", "-", "flat or FOCUS output file, or - for stdout")
+	allocation := flag.String("allocation", "", "JSON rules file allocating untagged and shared costs to the values of the first -group-by key")
+	validate := flag.String("validate-focus", "", "check a FOCUS file (.csv, .json or .ndjson) for required columns and value types, then exit")
+	flag.Parse()
+
+	if *validate != "" {
+		rows, problems, err := focus.Validate(*validate)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Printf("%s is a valid FOCUS file with %d rows\n", *validate, rows)
+		return
+	}
+
+	groups, err := parseGroupBy(*groupBy)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *layout != "nested" && *layout != "flat" {
+		log.Fatalf("unsupported layout %q", *layout)
+	}
+	var rules []allocationRule
+	if *allocation != "" {
+		if *writeFocus {
+			log.Fatalf("-allocation and -focus cannot be combined: FOCUS rows are billed costs")
+		}
+		rules, err = loadAllocationRules(*allocation, groups)
+		if err != nil {
+			log.Fatalf("unable to load allocation rules, %v", err)
+		}
+	}
+	// FOCUS charges need a service
+	if *writeFocus && !slices.ContainsFunc(groups, isServiceGroup) {
+		if len(groups) == maxGroupBy {
+			log.Fatalf("-focus needs SERVICE as one of the %d -group-by keys", maxGroupBy)
+		}
+		groups = append(groups, types.GroupDefinition{
+			Type: types.GroupDefinitionTypeDimension,
+			Key:  aws.String(string(types.DimensionService)),
+		})
+	}
 
 	// Define the start and end dates for the last 30 days
 	end := time.Now().UTC()
 	start := end.AddDate(0, 0, -30)
 
 	// Format the dates as required by AWS Cost Explorer (YYYY-MM-DD)
-	startDate := start.Format("2006-01-02")
-	endDate := end.Format("2006-01-02")
+	startDate := start.Format(dateLayout)
+	endDate := end.Format(dateLayout)
+
+	// The calling account pays for the FOCUS charges
+	period, err := datePeriod(startDate, endDate)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	client, billingAccount, closeSource, err := sourceOpts.open(context.TODO(), *writeFocus, period)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer closeSource()
 
 	// Define the time period and grouping options
 	input := &costexplorer.GetCostAndUsageInput{
@@ -38,28 +115,151 @@ func main() {
 		},
 		Granularity: types.GranularityMonthly,
 		Metrics:     []string{"UnblendedCost"},
-		GroupBy: []types.GroupDefinition{
-			{
-				Type: types.GroupDefinitionTypeTag,
-				Key:  aws.String("Project"),
-			},
-		},
+		GroupBy:     groups,
+	}
+
+	// FOCUS rows need the amortized cost of every charge
+	if *writeFocus {
+		input.Metrics = append(input.Metrics, "AmortizedCost")
 	}
 
 	// Call the Cost Explorer API to get cost data
-	result, err := client.GetCostAndUsage(context.TODO(), input)
+	results, err := getCostAndUsage(context.TODO(), client, input)
 	if err != nil {
 		log.Fatalf("failed to get cost and usage: %v", err)
 	}
 
+	if *writeFocus {
+		rows, err := groupFocusRows(results, billingAccount, groups)
+		if err != nil {
+			log.Fatalf("unable to convert costs to FOCUS, %v", err)
+		}
+		if err := writeFocusFile(*format, *out, rows); err != nil {
+			log.Fatalf("unable to write FOCUS rows, %v", err)
+		}
+		return
+	}
+
+	costs, err := groupedCosts(results, groups, "UnblendedCost")
+	if err != nil {
+		log.Fatalf("unable to read costs, %v", err)
+	}
+	if len(rules) > 0 {
+		var warnings []string
+		costs, warnings = allocate(costs, groups, rules)
+		for _, warning := range warnings {
+			fmt.Fprintln(os.Stderr, warning)
+		}
+	}
+
+	if *layout == "flat" {
+		err := writeFile(*out, func(w io.Writer) error {
+			return writeFlat(*format, w, costs, groups, "UnblendedCost", len(rules) > 0)
+		})
+		if err != nil {
+			log.Fatalf("unable to write costs, %v", err)
+		}
+		return
+	}
+
 	// Process and display the results
-	fmt.Printf("Cost data for the last 30 days (grouped by Project):\n")
-	for _, group := range result.ResultsByTime {
-		fmt.Printf("Time period: %s to %s\n", *group.TimePeriod.Start, *group.TimePeriod.End)
-		for _, groupRow := range group.Groups {
-			projectTag := (groupRow.Keys)[0]
-			cost := groupRow.Metrics["UnblendedCost"].Amount
-			fmt.Printf("Project: %s, Cost: $%s\n", projectTag, *cost)
+	fmt.Printf("Cost data for the last 30 days (grouped by %s):\n", groupLabels(groups))
+	writeNestedTable(os.Stdout, results, costs, groups)
+}
+
+// sourceOptions select where cost data comes from.
+type sourceOptions struct {
+	source   string
+	curDir   string
+	cache    string
+	noCache  bool
+	refresh  bool
+	cacheTTL time.Duration
+	offline  bool   // only answer from the cache
+	account  string // scope of cached responses, instead of the caller's
+}
+
+// addSourceFlags registers the cost data source flags.
+func addSourceFlags(fs *flag.FlagSet) *sourceOptions {
+	var o sourceOptions
+	fs.StringVar(&o.cache, "cache", "ce_cache.db", "file caching Cost Explorer responses")
+	fs.BoolVar(&o.noCache, "no-cache", false, "neither read nor write the Cost Explorer cache")
+	fs.BoolVar(&o.refresh, "refresh", false, "ignore cached Cost Explorer responses and store fresh ones")
+	fs.DurationVar(&o.cacheTTL, "cache-ttl", 6*time.Hour, "how long responses covering the current month, or a month that ended less than 5 days ago, stay cached")
+	fs.StringVar(&o.source, "source", "ce", "cost data source: ce (Cost Explorer) or cur (Cost and Usage Report files in -cur-dir)")
+	fs.StringVar(&o.curDir, "cur-dir", "", "directory of CUR 2.0 or legacy CUR Parquet or gzipped CSV files, with -source cur")
+	return &o
+}
+
+// open returns the Cost Explorer client of the source and the account that
+// pays for its costs. The returned function closes the cache. With -source
+// cur only the line items of the periods the queries cover are loaded.
+func (o *sourceOptions) open(ctx context.Context, needAccount bool, periods ...curdata.Period) (costExplorerAPI, string, func() error, error) {
+	noop := func() error { return nil }
+	switch o.source {
+	case "ce":
+		if o.offline && (o.noCache || o.refresh) {
+			return nil, "", nil, fmt.Errorf("-offline needs the cache, not -no-cache or -refresh")
+		}
+
+		// Load the AWS configuration (from environment, shared config, etc.)
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("unable to load SDK config, %v", err)
+		}
+
+		// Create a Cost Explorer client
+		var client costExplorerAPI = costexplorer.NewFromConfig(cfg)
+		if o.offline {
+			client = offlineCostExplorer{}
 		}
+
+		// The calling account scopes cached responses
+		billingAccount := o.account
+		if billingAccount == "" && (!o.noCache || needAccount) {
+			identity, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("unable to get caller identity, %v", err)
+			}
+			billingAccount = aws.ToString(identity.Account)
+		}
+
+		// Serve closed months from the local cache
+		if o.noCache {
+			return client, billingAccount, noop, nil
+		}
+		cache, err := openResponseCache(o.cache, o.refresh, o.cacheTTL)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("unable to open cache, %v", err)
+		}
+		return &cachedCostExplorer{api: client, cache: cache, scope: billingAccount}, billingAccount, cache.Close, nil
+	case "cur":
+		// Answer the same queries from CUR files, without AWS credentials
+		if o.curDir == "" {
+			return nil, "", nil, fmt.Errorf("-source cur requires -cur-dir")
+		}
+		data, err := curdata.Load(o.curDir, curdata.Filter{Periods: periods})
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("unable to load CUR, %v", err)
+		}
+		return &curCostExplorer{data: data}, data.Payer(), noop, nil
 	}
+	return nil, "", nil, fmt.Errorf("unsupported source %q", o.source)
+}
+
+// datePeriod is the period from start to end, both YYYY-MM-DD.
+func datePeriod(start, end string) (curdata.Period, error) {
+	from, err := time.Parse(dateLayout, start)
+	if err != nil {
+		return curdata.Period{}, fmt.Errorf("invalid date %q", start)
+	}
+	to, err := time.Parse(dateLayout, end)
+	if err != nil {
+		return curdata.Period{}, fmt.Errorf("invalid date %q", end)
+	}
+	return curdata.Period{Start: from, End: to}, nil
+}
+
+func isServiceGroup(group types.GroupDefinition) bool {
+	return group.Type == types.GroupDefinitionTypeDimension && aws.ToString(group.Key) == string(types.DimensionService)
 }
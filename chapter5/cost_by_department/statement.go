@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"math"
+	"strings"
+	"text/template"
+)
+
+// money formats an amount in USD, e.g. -$12.50.
+func money(v float64) string {
+	if math.Round(v*100) < 0 {
+		return fmt.Sprintf("-$%.2f", -v)
+	}
+	return fmt.Sprintf("$%.2f", math.Abs(v))
+}
+
+// signedMoney formats a change in USD, e.g. +$3.00.
+func signedMoney(v float64) string {
+	if math.Round(v*100) < 0 {
+		return money(v)
+	}
+	return "+" + money(v)
+}
+
+// markdownCell escapes the characters that would break a table cell.
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(s)
+}
+
+// summaryRow names a total for the summary table.
+func summaryRow(name string, amount statementAmount) statementLine {
+	return statementLine{Name: name, statementAmount: amount}
+}
+
+var statementFuncs = map[string]any{
+	"money":       money,
+	"signedMoney": signedMoney,
+	"cell":        markdownCell,
+	"row":         summaryRow,
+}
+
+var markdownStatement = template.Must(template.New("statement.md").Funcs(statementFuncs).Parse(`# Chargeback statement: {{cell .Department}}
+
+| | |
+|---|---|
+| {{cell .Label}} | {{cell .Department}} |
+| Billing month | {{.Month}} ({{.Start}} to {{.End}}, exclusive) |
+| Compared to | {{.PriorMonth}} |
+| Source | {{.Source}}, unblended cost |
+
+## Summary
+
+| | {{.Month}} | {{.PriorMonth}} | Change | |
+|---|--:|--:|--:|--:|
+| Direct costs | {{money .DirectTotal.Cost}} | {{money .DirectTotal.Prior}} | {{signedMoney .DirectTotal.Change}} | {{.DirectTotal.ChangePercent}} |
+| Allocated shared costs | {{money .AllocatedTotal.Cost}} | {{money .AllocatedTotal.Prior}} | {{signedMoney .AllocatedTotal.Change}} | {{.AllocatedTotal.ChangePercent}} |
+| Credits and refunds | {{money .CreditsTotal.Cost}} | {{money .CreditsTotal.Prior}} | {{signedMoney .CreditsTotal.Change}} | {{.CreditsTotal.ChangePercent}} |
+| **Total due** | **{{money .Total.Cost}}** | **{{money .Total.Prior}}** | **{{signedMoney .Total.Change}}** | **{{.Total.ChangePercent}}** |
+
+## Direct costs by service
+{{if .Direct}}
+| Service | {{.Month}} | {{.PriorMonth}} | Change | |
+|---|--:|--:|--:|--:|
+{{- range .Direct}}
+| {{cell .Name}} | {{money .Cost}} | {{money .Prior}} | {{signedMoney .Change}} | {{.ChangePercent}} |
+{{- end}}
+{{else}}
+No direct costs.
+{{end}}
+## Allocated shared costs
+{{if .Allocated}}
+| Allocation | Service | {{.Month}} | {{.PriorMonth}} | Change | |
+|---|---|--:|--:|--:|--:|
+{{- range .Allocated}}
+| {{cell .Name}} | {{cell .Service}} | {{money .Cost}} | {{money .Prior}} | {{signedMoney .Change}} | {{.ChangePercent}} |
+{{- end}}
+{{else}}
+No allocated shared costs.
+{{end}}
+## Credits and refunds
+{{if .Credits}}
+| Type | {{.Month}} | {{.PriorMonth}} | Change | |
+|---|--:|--:|--:|--:|
+{{- range .Credits}}
+| {{cell .Name}} | {{money .Cost}} | {{money .Prior}} | {{signedMoney .Change}} | {{.ChangePercent}} |
+{{- end}}
+{{else}}
+No credits or refunds.
+{{end}}`))
+
+var htmlStatement = htmltemplate.Must(htmltemplate.New("statement.html").Funcs(statementFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Chargeback statement: {{.Department}} {{.Month}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border-bottom: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+td.amount, th.amount { text-align: right; font-variant-numeric: tabular-nums; }
+tr.total td { font-weight: bold; border-top: 2px solid #222; }
+</style>
+</head>
+<body>
+<h1>Chargeback statement: {{.Department}}</h1>
+<table>
+<tr><th>{{.Label}}</th><td>{{.Department}}</td></tr>
+<tr><th>Billing month</th><td>{{.Month}} ({{.Start}} to {{.End}}, exclusive)</td></tr>
+<tr><th>Compared to</th><td>{{.PriorMonth}}</td></tr>
+<tr><th>Source</th><td>{{.Source}}, unblended cost</td></tr>
+</table>
+
+<h2>Summary</h2>
+<table>
+<tr><th></th><th class="amount">{{.Month}}</th><th class="amount">{{.PriorMonth}}</th><th class="amount">Change</th><th class="amount"></th></tr>
+{{template "amount" (row "Direct costs" .DirectTotal)}}
+{{template "amount" (row "Allocated shared costs" .AllocatedTotal)}}
+{{template "amount" (row "Credits and refunds" .CreditsTotal)}}
+<tr class="total"><td>Total due</td><td class="amount">{{money .Total.Cost}}</td><td class="amount">{{money .Total.Prior}}</td><td class="amount">{{signedMoney .Total.Change}}</td><td class="amount">{{.Total.ChangePercent}}</td></tr>
+</table>
+
+<h2>Direct costs by service</h2>
+{{if .Direct}}<table>
+<tr><th>Service</th><th class="amount">{{.Month}}</th><th class="amount">{{.PriorMonth}}</th><th class="amount">Change</th><th class="amount"></th></tr>
+{{range .Direct}}{{template "amount" .}}
+{{end}}</table>
+{{else}}<p>No direct costs.</p>
+{{end}}
+<h2>Allocated shared costs</h2>
+{{if .Allocated}}<table>
+<tr><th>Allocation</th><th>Service</th><th class="amount">{{.Month}}</th><th class="amount">{{.PriorMonth}}</th><th class="amount">Change</th><th class="amount"></th></tr>
+{{range .Allocated}}<tr><td>{{.Name}}</td><td>{{.Service}}</td><td class="amount">{{money .Cost}}</td><td class="amount">{{money .Prior}}</td><td class="amount">{{signedMoney .Change}}</td><td class="amount">{{.ChangePercent}}</td></tr>
+{{end}}</table>
+{{else}}<p>No allocated shared costs.</p>
+{{end}}
+<h2>Credits and refunds</h2>
+{{if .Credits}}<table>
+<tr><th>Type</th><th class="amount">{{.Month}}</th><th class="amount">{{.PriorMonth}}</th><th class="amount">Change</th><th class="amount"></th></tr>
+{{range .Credits}}{{template "amount" .}}
+{{end}}</table>
+{{else}}<p>No credits or refunds.</p>
+{{end}}</body>
+</html>
+{{define "amount"}}<tr><td>{{.Name}}</td><td class="amount">{{money .Cost}}</td><td class="amount">{{money .Prior}}</td><td class="amount">{{signedMoney .Change}}</td><td class="amount">{{.ChangePercent}}</td></tr>{{end}}
+`))
+
+func (s *statement) writeMarkdown(w io.Writer) error {
+	return markdownStatement.Execute(w, s)
+}
+
+func (s *statement) writeHTML(w io.Writer) error {
+	return htmlStatement.Execute(w, s)
+}
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+	"github.com/mlabouardy/finops-book/chapter5/curdata"
+)
+
+// comparison is a period and the period it is compared to.
+type comparison struct {
+	Name          string // mom, wow or yoy
+	Title         string
+	Start, End    string
+	PrevStart     string
+	PrevEnd       string
+	CurrentLabel  string
+	PreviousLabel string
+}
+
+// comparisons returns the periods of each of mom (the billing month against
+// the month before), yoy (the billing month against the same month a year
+// earlier) and wow (the 7 days before end against the 7 days before them).
+func comparisons(names []string, month time.Time, end time.Time) ([]comparison, error) {
+	monthLabel := month.Format("2006-01")
+	monthEnd := month.AddDate(0, 1, 0)
+	var periods []comparison
+	for _, name := range names {
+		switch name {
+		case "mom":
+			previous := month.AddDate(0, -1, 0)
+			periods = append(periods, comparison{
+				Name: name, Title: "Month over month",
+				Start: month.Format(dateLayout), End: monthEnd.Format(dateLayout),
+				PrevStart: previous.Format(dateLayout), PrevEnd: month.Format(dateLayout),
+				CurrentLabel: monthLabel, PreviousLabel: previous.Format("2006-01"),
+			})
+		case "yoy":
+			previous := month.AddDate(-1, 0, 0)
+			periods = append(periods, comparison{
+				Name: name, Title: "Year over year",
+				Start: month.Format(dateLayout), End: monthEnd.Format(dateLayout),
+				PrevStart: previous.Format(dateLayout), PrevEnd: previous.AddDate(0, 1, 0).Format(dateLayout),
+				CurrentLabel: monthLabel, PreviousLabel: previous.Format("2006-01"),
+			})
+		case "wow":
+			start := end.AddDate(0, 0, -7)
+			previous := start.AddDate(0, 0, -7)
+			periods = append(periods, comparison{
+				Name: name, Title: "Week over week",
+				Start: start.Format(dateLayout), End: end.Format(dateLayout),
+				PrevStart: previous.Format(dateLayout), PrevEnd: start.Format(dateLayout),
+				CurrentLabel:  start.Format(dateLayout) + " to " + end.AddDate(0, 0, -1).Format(dateLayout),
+				PreviousLabel: previous.Format(dateLayout) + " to " + start.AddDate(0, 0, -1).Format(dateLayout),
+			})
+		default:
+			return nil, fmt.Errorf("unsupported comparison %q, expected mom, wow or yoy", name)
+		}
+	}
+	return periods, nil
+}
+
+// varianceThresholds decide which departments are flagged. A department is
+// flagged when its change reaches both thresholds; a zero threshold is
+// always reached.
+type varianceThresholds struct {
+	Amount  float64 // USD
+	Percent float64
+}
+
+func (t varianceThresholds) exceeded(amount statementAmount) bool {
+	change := math.Abs(amount.Change())
+	if change == 0 || change < t.Amount {
+		return false
+	}
+	// A department without previous spend has an unbounded change
+	return amount.Prior == 0 || change/math.Abs(amount.Prior)*100 >= t.Percent
+}
+
+// varianceLine is the change of one service of a department.
+type varianceLine struct {
+	Name string
+	statementAmount
+}
+
+// departmentVariance is the change of one department in one comparison.
+type departmentVariance struct {
+	Department string
+	statementAmount
+	Flagged bool
+	Movers  []varianceLine // services with the largest changes
+}
+
+// varianceReport is the result of one comparison.
+type varianceReport struct {
+	comparison
+	Label       string // the department key, e.g. Project
+	Departments []departmentVariance
+}
+
+// runVariance implements the variance subcommand: the change of every
+// department between two periods, largest first, with the services that
+// moved the most.
+func runVariance(args []string) error {
+	fs := flag.NewFlagSet("variance", flag.ExitOnError)
+	sourceOpts := addSourceFlags(fs)
+	compare := fs.String("compare", "mom,wow,yoy", "comma-separated comparisons: mom (month over month), wow (week over week), yoy (same month last year)")
+	monthFlag := fs.String("month", "", "billing month YYYY-MM of mom and yoy (default the last closed month)")
+	endFlag := fs.String("end", "", "exclusive end date YYYY-MM-DD of wow (default today)")
+	department := fs.String("department", "tag:Project", "grouping that names departments: tag:<key>, category:<cost category> or a dimension")
+	allocation := fs.String("allocation", "", "JSON rules file allocating untagged and shared costs; match keys are the -department label and SERVICE")
+	thresholdAmount := fs.Float64("threshold-amount", 100, "flag departments whose cost changed by at least this many USD")
+	thresholdPercent := fs.Float64("threshold-percent", 20, "flag departments whose cost changed by at least this percentage")
+	top := fs.Int("top", 3, "services listed as top movers per department")
+	onlyFlagged := fs.Bool("only-flagged", false, "list flagged departments only")
+	format := fs.String("format", "text", "output format: text, csv, json or ndjson")
+	out := fs.String("out", "-", "output file, or - for stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cost_by_department variance [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	groups, err := parseGroupBy(*department)
+	if err != nil {
+		return err
+	}
+	if len(groups) != 1 || isServiceGroup(groups[0]) {
+		return fmt.Errorf("-department takes one grouping other than SERVICE")
+	}
+	groups = append(groups, types.GroupDefinition{
+		Type: types.GroupDefinitionTypeDimension,
+		Key:  aws.String(string(types.DimensionService)),
+	})
+
+	now := time.Now().UTC()
+	period, err := parseMonth(*monthFlag, now)
+	if err != nil {
+		return err
+	}
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if *endFlag != "" {
+		if end, err = time.Parse(dateLayout, *endFlag); err != nil {
+			return fmt.Errorf("invalid -end %q, expected YYYY-MM-DD", *endFlag)
+		}
+	}
+	var names []string
+	for _, name := range strings.Split(*compare, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, strings.ToLower(name))
+		}
+	}
+	periods, err := comparisons(names, period.month, end)
+	if err != nil {
+		return err
+	}
+	if *top < 0 {
+		return fmt.Errorf("-top cannot be negative")
+	}
+	switch *format {
+	case "text", "csv", "json", "ndjson":
+	default:
+		return fmt.Errorf("unsupported format %q, expected text, csv, json or ndjson", *format)
+	}
+
+	var rules []allocationRule
+	if *allocation != "" {
+		if rules, err = loadAllocationRules(*allocation, groups); err != nil {
+			return fmt.Errorf("unable to load allocation rules, %v", err)
+		}
+	}
+
+	var queried []curdata.Period
+	for _, c := range periods {
+		for _, bounds := range [][2]string{{c.Start, c.End}, {c.PrevStart, c.PrevEnd}} {
+			p, err := datePeriod(bounds[0], bounds[1])
+			if err != nil {
+				return err
+			}
+			queried = append(queried, p)
+		}
+	}
+	client, _, closeSource, err := sourceOpts.open(context.TODO(), false, queried...)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+
+	thresholds := varianceThresholds{Amount: *thresholdAmount, Percent: *thresholdPercent}
+	reports := make([]varianceReport, 0, len(periods))
+	for _, c := range periods {
+		current, err := serviceCosts(context.TODO(), client, groups, rules, c.Start, c.End)
+		if err != nil {
+			return err
+		}
+		previous, err := serviceCosts(context.TODO(), client, groups, rules, c.PrevStart, c.PrevEnd)
+		if err != nil {
+			return err
+		}
+		report := compareCosts(c, current, previous, thresholds, *top)
+		report.Label = groupLabel(groups[0])
+		if *onlyFlagged {
+			flagged := report.Departments[:0]
+			for _, d := range report.Departments {
+				if d.Flagged {
+					flagged = append(flagged, d)
+				}
+			}
+			report.Departments = flagged
+		}
+		reports = append(reports, report)
+	}
+
+	return writeFile(*out, func(w io.Writer) error {
+		if *format == "text" {
+			writeVarianceText(w, reports)
+			return nil
+		}
+		return writeVarianceFlat(*format, w, reports)
+	})
+}
+
+// serviceCosts sums the cost of each department and service between start
+// and end, after allocating shared costs.
+func serviceCosts(ctx context.Context, client costExplorerAPI, groups []types.GroupDefinition, rules []allocationRule, start, end string) (map[string]map[string]float64, error) {
+	// Whole months are queried by month, so they are cached once closed
+	granularity := types.GranularityDaily
+	if strings.HasSuffix(start, "-01") && strings.HasSuffix(end, "-01") {
+		granularity = types.GranularityMonthly
+	}
+	input := &costexplorer.GetCostAndUsageInput{
+		TimePeriod: &types.DateInterval{
+			Start: aws.String(start),
+			End:   aws.String(end),
+		},
+		Granularity: granularity,
+		Metrics:     []string{"UnblendedCost"},
+		GroupBy:     groups,
+	}
+	results, err := getCostAndUsage(ctx, client, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cost and usage for %s to %s: %v", start, end, err)
+	}
+	costs, err := groupedCosts(results, groups, "UnblendedCost")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read costs, %v", err)
+	}
+	if len(rules) > 0 {
+		var warnings []string
+		costs, warnings = allocate(costs, groups, rules)
+		for _, warning := range warnings {
+			fmt.Fprintln(os.Stderr, warning)
+		}
+	}
+
+	totals := make(map[string]map[string]float64)
+	for _, cost := range costs {
+		department := displayValue(groups[0], cost.Values[0])
+		if totals[department] == nil {
+			totals[department] = make(map[string]float64)
+		}
+		totals[department][cost.Values[1]] += cost.Cost
+	}
+	return totals, nil
+}
+
+// compareCosts builds the variance of every department found in either
+// period, largest absolute change first.
+func compareCosts(c comparison, current, previous map[string]map[string]float64, thresholds varianceThresholds, top int) varianceReport {
+	services := make(map[string]map[string]*statementAmount)
+	add := func(costs map[string]map[string]float64, prior bool) {
+		for department, byService := range costs {
+			if services[department] == nil {
+				services[department] = make(map[string]*statementAmount)
+			}
+			for service, cost := range byService {
+				amount := services[department][service]
+				if amount == nil {
+					amount = &statementAmount{}
+					services[department][service] = amount
+				}
+				if prior {
+					amount.Prior += cost
+				} else {
+					amount.Cost += cost
+				}
+			}
+		}
+	}
+	add(current, false)
+	add(previous, true)
+
+	report := varianceReport{comparison: c}
+	for department, byService := range services {
+		variance := departmentVariance{Department: department}
+		lines := make([]varianceLine, 0, len(byService))
+		for service, amount := range byService {
+			variance.Cost += amount.Cost
+			variance.Prior += amount.Prior
+			if amount.Change() != 0 {
+				lines = append(lines, varianceLine{Name: service, statementAmount: *amount})
+			}
+		}
+		sort.Slice(lines, func(i, j int) bool {
+			if math.Abs(lines[i].Change()) != math.Abs(lines[j].Change()) {
+				return math.Abs(lines[i].Change()) > math.Abs(lines[j].Change())
+			}
+			return lines[i].Name < lines[j].Name
+		})
+		variance.Movers = lines[:min(top, len(lines))]
+		variance.Flagged = thresholds.exceeded(variance.statementAmount)
+		report.Departments = append(report.Departments, variance)
+	}
+	sort.Slice(report.Departments, func(i, j int) bool {
+		a, b := report.Departments[i], report.Departments[j]
+		if math.Abs(a.Change()) != math.Abs(b.Change()) {
+			return math.Abs(a.Change()) > math.Abs(b.Change())
+		}
+		return a.Department < b.Department
+	})
+	return report
+}
+
+// writeVarianceText prints each comparison as a table of departments, each
+// above its top movers.
+func writeVarianceText(w io.Writer, reports []varianceReport) {
+	for i, report := range reports {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s: %s vs %s\n", report.Title, report.CurrentLabel, report.PreviousLabel)
+		if len(report.Departments) == 0 {
+			fmt.Fprintln(w, "No departments to report.")
+		}
+		for _, d := range report.Departments {
+			flag := ""
+			if d.Flagged {
+				flag = " [FLAGGED]"
+			}
+			fmt.Fprintf(w, "%s: %s, Cost: %s, Previous: %s, Change: %s (%s)%s\n",
+				report.Label, d.Department, money(d.Cost), money(d.Prior), signedMoney(d.Change()), d.ChangePercent(), flag)
+			for _, mover := range d.Movers {
+				fmt.Fprintf(w, "  SERVICE: %s, Cost: %s, Previous: %s, Change: %s (%s)\n",
+					mover.Name, money(mover.Cost), money(mover.Prior), signedMoney(mover.Change()), mover.ChangePercent())
+			}
+		}
+	}
+}
+
+// varianceRow is a department, or one of its top movers when Service is
+// set, in the flat output.
+type varianceRow struct {
+	Comparison    string
+	PeriodStart   string
+	PeriodEnd     string
+	PreviousStart string
+	PreviousEnd   string
+	Department    string
+	Service       string
+	Cost          float64
+	PreviousCost  float64
+	Change        float64
+	ChangePercent *float64 // nil without previous cost
+	Flagged       bool
+}
+
+func varianceRows(reports []varianceReport) []varianceRow {
+	var rows []varianceRow
+	for _, report := range reports {
+		row := func(department, service string, amount statementAmount, flagged bool) varianceRow {
+			r := varianceRow{
+				Comparison:    report.Name,
+				PeriodStart:   report.Start,
+				PeriodEnd:     report.End,
+				PreviousStart: report.PrevStart,
+				PreviousEnd:   report.PrevEnd,
+				Department:    department,
+				Service:       service,
+				Cost:          amount.Cost,
+				PreviousCost:  amount.Prior,
+				Change:        amount.Change(),
+				Flagged:       flagged,
+			}
+			if amount.Prior != 0 {
+				percent := amount.Change() / math.Abs(amount.Prior) * 100
+				r.ChangePercent = &percent
+			}
+			return r
+		}
+		for _, d := range report.Departments {
+			rows = append(rows, row(d.Department, "", d.statementAmount, d.Flagged))
+			for _, mover := range d.Movers {
+				rows = append(rows, row(d.Department, mover.Name, mover.statementAmount, false))
+			}
+		}
+	}
+	return rows
+}
+
+// writeVarianceFlat writes one row per department and top mover as CSV, a
+// JSON array or NDJSON.
+func writeVarianceFlat(format string, w io.Writer, reports []varianceReport) error {
+	rows := varianceRows(reports)
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		header := []string{"Comparison", "PeriodStart", "PeriodEnd", "PreviousStart", "PreviousEnd", "Department", "Service", "Cost", "PreviousCost", "Change", "ChangePercent", "Flagged"}
+		if err := writer.Write(header); err != nil {
+			return err
+		}
+		for _, r := range rows {
+			percent := ""
+			if r.ChangePercent != nil {
+				percent = fmt.Sprintf("%.2f", *r.ChangePercent)
+			}
+			record := []string{r.Comparison, r.PeriodStart, r.PeriodEnd, r.PreviousStart, r.PreviousEnd, r.Department, r.Service,
+				fmt.Sprintf("%.2f", r.Cost), fmt.Sprintf("%.2f", r.PreviousCost), fmt.Sprintf("%.2f", r.Change), percent, fmt.Sprint(r.Flagged)}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if rows == nil {
+			rows = []varianceRow{}
+		}
+		return encoder.Encode(rows)
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		for _, r := range rows {
+			if err := encoder.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported format %q, expected text, csv, json or ndjson", format)
+}
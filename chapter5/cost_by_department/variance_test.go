@@ -0,0 +1,173 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComparisons(t *testing.T) {
+	month := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2024, time.April, 10, 0, 0, 0, 0, time.UTC)
+	periods, err := comparisons([]string{"mom", "yoy", "wow"}, month, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []comparison{
+		{Name: "mom", Start: "2024-03-01", End: "2024-04-01", PrevStart: "2024-02-01", PrevEnd: "2024-03-01", CurrentLabel: "2024-03", PreviousLabel: "2024-02"},
+		{Name: "yoy", Start: "2024-03-01", End: "2024-04-01", PrevStart: "2023-03-01", PrevEnd: "2023-04-01", CurrentLabel: "2024-03", PreviousLabel: "2023-03"},
+		{Name: "wow", Start: "2024-04-03", End: "2024-04-10", PrevStart: "2024-03-27", PrevEnd: "2024-04-03",
+			CurrentLabel: "2024-04-03 to 2024-04-09", PreviousLabel: "2024-03-27 to 2024-04-02"},
+	}
+	if len(periods) != len(want) {
+		t.Fatalf("got %d comparisons, want %d", len(periods), len(want))
+	}
+	for i, got := range periods {
+		got.Title = ""
+		if got != want[i] {
+			t.Errorf("comparison %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	if _, err := comparisons([]string{"qoq"}, month, end); err == nil {
+		t.Error("comparisons of qoq succeeded, want an error")
+	}
+}
+
+func TestVarianceThresholds(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds varianceThresholds
+		amount     statementAmount
+		want       bool
+	}{
+		{"no thresholds", varianceThresholds{}, statementAmount{Cost: 101, Prior: 100}, true},
+		{"no change", varianceThresholds{}, statementAmount{Cost: 100, Prior: 100}, false},
+		{"amount reached", varianceThresholds{Amount: 50}, statementAmount{Cost: 150, Prior: 100}, true},
+		{"amount not reached", varianceThresholds{Amount: 50}, statementAmount{Cost: 140, Prior: 100}, false},
+		{"decrease reaches amount", varianceThresholds{Amount: 50}, statementAmount{Cost: 40, Prior: 100}, true},
+		{"percent reached", varianceThresholds{Percent: 10}, statementAmount{Cost: 11, Prior: 10}, true},
+		{"percent not reached", varianceThresholds{Percent: 10}, statementAmount{Cost: 1050, Prior: 1000}, false},
+		{"both reached", varianceThresholds{Amount: 100, Percent: 10}, statementAmount{Cost: 1200, Prior: 1000}, true},
+		{"only amount reached", varianceThresholds{Amount: 100, Percent: 10}, statementAmount{Cost: 1050, Prior: 1000}, false},
+		{"only percent reached", varianceThresholds{Amount: 100, Percent: 10}, statementAmount{Cost: 20, Prior: 10}, false},
+		{"new spend", varianceThresholds{Amount: 5, Percent: 1000}, statementAmount{Cost: 10}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.thresholds.exceeded(tt.amount); got != tt.want {
+				t.Errorf("exceeded(%+v) = %v, want %v", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareCosts(t *testing.T) {
+	current := map[string]map[string]float64{
+		"web":  {"EC2": 150, "S3": 12, "RDS": 30},
+		"data": {"Athena": 40},
+		"ml":   {"SageMaker": 80},
+	}
+	previous := map[string]map[string]float64{
+		"web":    {"EC2": 100, "S3": 10, "RDS": 30},
+		"data":   {"Athena": 50},
+		"legacy": {"EC2": 60},
+	}
+
+	tests := []struct {
+		name        string
+		top         int
+		wantOrder   []string
+		wantMovers  map[string][]string
+		wantFlagged map[string]bool
+	}{
+		{
+			name:        "all movers",
+			top:         5,
+			wantOrder:   []string{"ml", "legacy", "web", "data"},
+			wantMovers:  map[string][]string{"web": {"EC2", "S3"}, "data": {"Athena"}, "ml": {"SageMaker"}, "legacy": {"EC2"}},
+			wantFlagged: map[string]bool{"web": true, "data": false, "ml": true, "legacy": true},
+		},
+		{
+			name:        "top truncation",
+			top:         1,
+			wantOrder:   []string{"ml", "legacy", "web", "data"},
+			wantMovers:  map[string][]string{"web": {"EC2"}, "data": {"Athena"}, "ml": {"SageMaker"}, "legacy": {"EC2"}},
+			wantFlagged: map[string]bool{"web": true, "data": false, "ml": true, "legacy": true},
+		},
+		{
+			name:        "no movers",
+			top:         0,
+			wantOrder:   []string{"ml", "legacy", "web", "data"},
+			wantMovers:  map[string][]string{},
+			wantFlagged: map[string]bool{"web": true, "data": false, "ml": true, "legacy": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := compareCosts(comparison{Name: "mom"}, current, previous, varianceThresholds{Amount: 20, Percent: 25}, tt.top)
+			var order []string
+			for _, d := range report.Departments {
+				order = append(order, d.Department)
+				var movers []string
+				for _, m := range d.Movers {
+					movers = append(movers, m.Name)
+				}
+				if strings.Join(movers, ",") != strings.Join(tt.wantMovers[d.Department], ",") {
+					t.Errorf("%s movers = %v, want %v", d.Department, movers, tt.wantMovers[d.Department])
+				}
+				if d.Flagged != tt.wantFlagged[d.Department] {
+					t.Errorf("%s flagged = %v, want %v", d.Department, d.Flagged, tt.wantFlagged[d.Department])
+				}
+			}
+			if strings.Join(order, ",") != strings.Join(tt.wantOrder, ",") {
+				t.Errorf("departments = %v, want %v", order, tt.wantOrder)
+			}
+		})
+	}
+
+	report := compareCosts(comparison{}, current, previous, varianceThresholds{}, 5)
+	for _, d := range report.Departments {
+		switch d.Department {
+		case "ml":
+			if d.Cost != 80 || d.Prior != 0 || d.ChangePercent() != "n/a" {
+				t.Errorf("new department ml = %+v, want 80 without previous cost", d.statementAmount)
+			}
+		case "legacy":
+			if d.Cost != 0 || d.Prior != 60 || d.ChangePercent() != "-100.0%" {
+				t.Errorf("removed department legacy = %+v, want 60 previous cost", d.statementAmount)
+			}
+		}
+	}
+}
+
+func TestVarianceRows(t *testing.T) {
+	reports := []varianceReport{{
+		comparison: comparison{Name: "mom", Start: "2024-03-01", End: "2024-04-01", PrevStart: "2024-02-01", PrevEnd: "2024-03-01"},
+		Departments: []departmentVariance{
+			{
+				Department:      "web",
+				statementAmount: statementAmount{Cost: 162, Prior: 140},
+				Flagged:         true,
+				Movers:          []varianceLine{{Name: "EC2", statementAmount: statementAmount{Cost: 150, Prior: 100}}},
+			},
+			{Department: "ml", statementAmount: statementAmount{Cost: 80}},
+		},
+	}}
+
+	rows := varianceRows(reports)
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(rows))
+	}
+	web, ec2, ml := rows[0], rows[1], rows[2]
+	if web.Department != "web" || web.Service != "" || !web.Flagged || web.Change != 22 || web.PeriodStart != "2024-03-01" || web.PreviousEnd != "2024-03-01" {
+		t.Errorf("department row = %+v", web)
+	}
+	if ec2.Department != "web" || ec2.Service != "EC2" || ec2.Flagged || ec2.ChangePercent == nil || *ec2.ChangePercent != 50 {
+		t.Errorf("mover row = %+v, want EC2 up 50%% and not flagged", ec2)
+	}
+	if ml.ChangePercent != nil || ml.Change != 80 {
+		t.Errorf("new department row = %+v, want a change of 80 without a percentage", ml)
+	}
+}
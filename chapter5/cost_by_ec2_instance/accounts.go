@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	orgtypes "github.com/aws/aws-sdk-go-v2/service/organizations/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// accountInfo identifies the AWS account a collection runs in.
+type accountInfo struct {
+	ID   string
+	Name string
+}
+
+// callerAccount returns the account of the default credential chain.
+func callerAccount(ctx context.Context, cfg aws.Config) (accountInfo, error) {
+	resp, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return accountInfo{}, fmt.Errorf("unable to get caller identity, %w", err)
+	}
+	return accountInfo{ID: aws.ToString(resp.Account)}, nil
+}
+
+// listOrganizationAccounts returns the active member accounts of the
+// organization. It must run with credentials of the management account or
+// a delegated administrator.
+func listOrganizationAccounts(ctx context.Context, cfg aws.Config) ([]accountInfo, error) {
+	paginator := organizations.NewListAccountsPaginator(organizations.NewFromConfig(cfg), &organizations.ListAccountsInput{})
+
+	var accounts []accountInfo
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list organization accounts, %w", err)
+		}
+		for _, account := range resp.Accounts {
+			if account.Status != orgtypes.AccountStatusActive {
+				continue
+			}
+			accounts = append(accounts, accountInfo{
+				ID:   aws.ToString(account.Id),
+				Name: aws.ToString(account.Name),
+			})
+		}
+	}
+	return accounts, nil
+}
+
+// assumeRoleConfig returns a copy of cfg whose credentials come from
+// assuming roleName in the given account. Credentials are cached and
+// refreshed by the SDK for the duration of the collection.
+func assumeRoleConfig(cfg aws.Config, accountID, roleName string) aws.Config {
+	roleARN := fmt.Sprintf("arn:aws:iam::%s:role/%s", accountID, roleName)
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = "cost-by-ec2-instance"
+	})
+
+	assumed := cfg.Copy()
+	assumed.Credentials = aws.NewCredentialsCache(provider)
+	return assumed
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/mlabouardy/finops-book/chapter5/curdata"
+)
+
+// vpcService bills public IPv4 addresses, including Elastic IPs
+const vpcService = curdata.VPCService
+
+// attachElasticIPs records the Elastic IPs associated with each instance of a
+// region, either directly or through one of its network interfaces.
+func attachElasticIPs(ctx context.Context, ec2Client *ec2.Client, instances []InstanceData) error {
+	resp, err := ec2Client.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{})
+	if err != nil {
+		return fmt.Errorf("unable to describe addresses, %w", err)
+	}
+
+	owners := make(map[string]int)
+	for i, instance := range instances {
+		owners[instance.InstanceID] = i
+		for _, eni := range instance.networkInterfaces {
+			owners[eni] = i
+		}
+	}
+
+	for _, address := range resp.Addresses {
+		i, ok := owners[aws.ToString(address.InstanceId)]
+		if !ok {
+			i, ok = owners[aws.ToString(address.NetworkInterfaceId)]
+		}
+		if !ok {
+			continue
+		}
+		instances[i].ElasticIPs = append(instances[i].ElasticIPs, aws.ToString(address.AllocationId))
+		instances[i].publicIPs = append(instances[i].publicIPs, aws.ToString(address.PublicIp))
+	}
+	return nil
+}
+
+// attachedResourceIDs lists the IDs Cost Explorer may report the attached
+// resources of an instance under. Elastic IP charges are looked up by both
+// allocation ID and public address.
+func attachedResourceIDs(instance InstanceData) []string {
+	ids := make([]string, 0, len(instance.Volumes)+2*len(instance.ElasticIPs))
+	ids = append(ids, instance.Volumes...)
+	ids = append(ids, instance.ElasticIPs...)
+	ids = append(ids, instance.publicIPs...)
+	return ids
+}
+
+// addAttachedCosts adds the cost of the volumes and Elastic IPs attached to
+// an instance, giving its total cost of ownership. The breakdown, if any,
+// is extended with the usage categories of the attached resources.
+func addAttachedCosts(instance *InstanceData, costs map[string]*resourceCost, primary string) {
+	for _, id := range attachedResourceIDs(*instance) {
+		cost, ok := costs[id]
+		if !ok {
+			continue
+		}
+
+		instance.AttachedCost += totalCosts(cost.Series)[primary]
+		if instance.Breakdown != nil {
+			for category, amount := range cost.Breakdown {
+				instance.Breakdown[category] += amount
+			}
+		}
+	}
+	instance.TotalCost = instance.Cost + instance.AttachedCost
+}
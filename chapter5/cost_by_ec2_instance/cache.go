@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"go.etcd.io/bbolt"
+)
+
+var cacheBucket = []byte("costexplorer")
+
+// responseCache persists Cost Explorer responses on disk. Responses for
+// months closed for a few days are final and never expire; anything more
+// recent is kept for a short TTL only.
+type responseCache struct {
+	db      *bbolt.DB
+	refresh bool // ignore cached entries, but store fresh responses
+	ttl     time.Duration
+}
+
+type cacheEntry struct {
+	Expires time.Time       `json:"expires"` // zero for closed periods
+	Value   json.RawMessage `json:"value"`
+}
+
+func openResponseCache(path string, refresh bool, ttl time.Duration) (*responseCache, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open cache %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(cacheBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to initialize cache %s: %v", path, err)
+	}
+	return &responseCache{db: db, refresh: refresh, ttl: ttl}, nil
+}
+
+func (c *responseCache) Close() error {
+	return c.db.Close()
+}
+
+// get decodes the cached response for key into out and reports whether a
+// live entry was found.
+func (c *responseCache) get(key string, out any) bool {
+	if c.refresh {
+		return false
+	}
+
+	var entry cacheEntry
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(cacheBucket).Get([]byte(key))
+		if data == nil {
+			return fmt.Errorf("not cached")
+		}
+		return json.Unmarshal(data, &entry)
+	})
+	if err != nil {
+		return false
+	}
+	if !entry.Expires.IsZero() && time.Now().After(entry.Expires) {
+		return false
+	}
+	return json.Unmarshal(entry.Value, out) == nil
+}
+
+// put stores a response. Responses for closed periods are stored without
+// expiry.
+func (c *responseCache) put(key string, periodEnd string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	entry := cacheEntry{Value: data}
+	if !isClosedPeriod(periodEnd, time.Now().UTC()) {
+		entry.Expires = time.Now().Add(c.ttl)
+	}
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return c.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(cacheBucket).Put([]byte(key), encoded)
+	})
+}
+
+// billingCloseDays is how long after the end of a month its costs keep
+// changing: AWS finalizes the month's usage, credits, refunds and
+// reservation fees in the first days of the next month.
+const billingCloseDays = 5
+
+// isClosedPeriod reports whether the billing month of the last day before
+// an exclusive period end date closed at least billingCloseDays ago.
+func isClosedPeriod(end string, now time.Time) bool {
+	date, err := time.Parse(dateLayout, end)
+	if err != nil {
+		return false
+	}
+	last := date.AddDate(0, 0, -1)
+	closed := time.Date(last.Year(), last.Month()+1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, billingCloseDays)
+	return !now.Before(closed)
+}
+
+// cacheKey hashes an operation, the scope it ran in (the account) and its
+// JSON-encoded input.
+func cacheKey(operation, scope string, input any) (string, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(append([]byte(operation+"\x00"+scope+"\x00"), data...))
+	return operation + ":" + hex.EncodeToString(sum[:]), nil
+}
+
+// cachedCostExplorer serves Cost Explorer requests from the response cache
+// and only calls the API on a miss.
+type cachedCostExplorer struct {
+	api   costExplorerAPI
+	cache *responseCache
+	scope string
+}
+
+// cachedPages follows the pages of a lookup through the cache, as far as
+// they are cached.
+func (c *cachedCostExplorer) cachedPages(params *costexplorer.GetCostAndUsageWithResourcesInput) cachedLookup {
+	var lookup cachedLookup
+	input := *params
+	for {
+		key, err := cacheKey("GetCostAndUsageWithResources", c.scope, normalizeResourcesInput(&input))
+		if err != nil {
+			return lookup
+		}
+		var page costexplorer.GetCostAndUsageWithResourcesOutput
+		if !c.cache.get(key, &page) {
+			return lookup
+		}
+
+		groups := 0
+		for _, result := range page.ResultsByTime {
+			groups += len(result.Groups)
+		}
+		lookup.pages++
+		lookup.groups += groups
+		if page.NextPageToken == nil {
+			lookup.complete = true
+			return lookup
+		}
+		// Only the last page of a lookup is not full
+		lookup.pageSize = groups
+		input.NextPageToken = page.NextPageToken
+	}
+}
+
+func (c *cachedCostExplorer) GetCostAndUsageWithResources(ctx context.Context, params *costexplorer.GetCostAndUsageWithResourcesInput, optFns ...func(*costexplorer.Options)) (*costexplorer.GetCostAndUsageWithResourcesOutput, error) {
+	key, err := cacheKey("GetCostAndUsageWithResources", c.scope, normalizeResourcesInput(params))
+	if err != nil {
+		return nil, err
+	}
+
+	var cached costexplorer.GetCostAndUsageWithResourcesOutput
+	if c.cache.get(key, &cached) {
+		return &cached, nil
+	}
+
+	resp, err := c.api.GetCostAndUsageWithResources(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.cache.put(key, aws.ToString(params.TimePeriod.End), resp); err != nil {
+		// The response is still good; a later run fetches it again
+		fmt.Fprintf(os.Stderr, "Unable to cache response: %v\n", err)
+	}
+	return resp, nil
+}
+
+// normalizeResourcesInput returns a copy of the input with its metric and
+// filter values sorted, so equivalent requests share a cache entry.
+func normalizeResourcesInput(params *costexplorer.GetCostAndUsageWithResourcesInput) *costexplorer.GetCostAndUsageWithResourcesInput {
+	normalized := *params
+	normalized.Metrics = append([]string(nil), params.Metrics...)
+	sort.Strings(normalized.Metrics)
+
+	if params.Filter != nil && params.Filter.Dimensions != nil {
+		filter := *params.Filter
+		dimensions := *params.Filter.Dimensions
+		dimensions.Values = append([]string(nil), dimensions.Values...)
+		sort.Strings(dimensions.Values)
+		filter.Dimensions = &dimensions
+		normalized.Filter = &filter
+	}
+	return &normalized
+}
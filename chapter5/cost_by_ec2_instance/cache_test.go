@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+func TestIsClosedPeriod(t *testing.T) {
+	day := func(value string) time.Time {
+		parsed, err := time.Parse(dateLayout, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	tests := []struct {
+		end  string
+		now  string
+		want bool
+	}{
+		{"2024-06-01", "2024-06-02", false}, // May is still being finalized
+		{"2024-06-01", "2024-06-05", false},
+		{"2024-06-01", "2024-06-06", true},
+		{"2024-05-15", "2024-06-06", true},  // ends inside a closed month
+		{"2024-06-02", "2024-06-20", false}, // reaches into the current month
+		{"2024-06-02", "2024-07-06", true},
+		{"2024-01-01", "2024-01-05", false}, // across a year end
+		{"2024-01-01", "2024-01-06", true},
+		{"not a date", "2024-06-20", false},
+	}
+	for _, tt := range tests {
+		if got := isClosedPeriod(tt.end, day(tt.now)); got != tt.want {
+			t.Errorf("isClosedPeriod(%s) on %s = %v, want %v", tt.end, tt.now, got, tt.want)
+		}
+	}
+}
+
+// fixedCostExplorer answers every request with the same response.
+type fixedCostExplorer struct {
+	resp *costexplorer.GetCostAndUsageWithResourcesOutput
+}
+
+func (f fixedCostExplorer) GetCostAndUsageWithResources(ctx context.Context, params *costexplorer.GetCostAndUsageWithResourcesInput, optFns ...func(*costexplorer.Options)) (*costexplorer.GetCostAndUsageWithResourcesOutput, error) {
+	return f.resp, nil
+}
+
+func TestCachedCostExplorerFailedPut(t *testing.T) {
+	cache, err := openResponseCache(filepath.Join(t.TempDir(), "cache.db"), false, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A closed database fails every write
+	cache.Close()
+
+	want := &costexplorer.GetCostAndUsageWithResourcesOutput{NextPageToken: aws.String("next")}
+	client := &cachedCostExplorer{api: fixedCostExplorer{resp: want}, cache: cache, scope: "111111111111"}
+	got, err := client.GetCostAndUsageWithResources(context.Background(), &costexplorer.GetCostAndUsageWithResourcesInput{
+		TimePeriod: &types.DateInterval{Start: aws.String("2024-05-01"), End: aws.String("2024-06-01")},
+	})
+	if err != nil {
+		t.Fatalf("a failed cache write failed the request: %v", err)
+	}
+	if got != want {
+		t.Errorf("got %+v, want the live response", got)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	"golang.org/x/time/rate"
+)
+
+// apiRateLimiter keeps one token bucket per service and region, so every
+// regional EC2 endpoint and the global Cost Explorer endpoint are throttled
+// independently of each other.
+type apiRateLimiter struct {
+	mu       sync.Mutex
+	rates    map[string]float64
+	limiters map[string]*rate.Limiter
+}
+
+// newAPIRateLimiter takes the allowed requests per second keyed by SDK
+// service ID (e.g. "EC2", "Cost Explorer"). Services without a rate are not
+// limited.
+func newAPIRateLimiter(rates map[string]float64) *apiRateLimiter {
+	return &apiRateLimiter{
+		rates:    rates,
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+func (l *apiRateLimiter) limiter(service, region string) *rate.Limiter {
+	rps, ok := l.rates[service]
+	if !ok || rps <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := service + "/" + region
+	limiter, ok := l.limiters[key]
+	if !ok {
+		burst := int(rps)
+		if burst < 1 {
+			burst = 1
+		}
+		limiter = rate.NewLimiter(rate.Limit(rps), burst)
+		l.limiters[key] = limiter
+	}
+	return limiter
+}
+
+// addMiddleware is an SDK APIOptions hook. It is registered after the retry
+// middleware so every attempt, including retries, waits for a token.
+func (l *apiRateLimiter) addMiddleware(stack *middleware.Stack) error {
+	return stack.Finalize.Add(middleware.FinalizeMiddlewareFunc("RateLimit",
+		func(ctx context.Context, in middleware.FinalizeInput, next middleware.FinalizeHandler) (middleware.FinalizeOutput, middleware.Metadata, error) {
+			limiter := l.limiter(awsmiddleware.GetServiceID(ctx), awsmiddleware.GetRegion(ctx))
+			if limiter != nil {
+				if err := limiter.Wait(ctx); err != nil {
+					return middleware.FinalizeOutput{}, middleware.Metadata{}, fmt.Errorf("rate limit wait: %w", err)
+				}
+			}
+			return next.HandleFinalize(ctx, in)
+		}), middleware.After)
+}
+
+// forEach calls fn for every index in [0, n) using at most workers
+// goroutines. Callers write results into index-addressed slots so the output
+// order does not depend on which worker finishes first.
+func forEach(n, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestAPIRateLimiter(t *testing.T) {
+	l := newAPIRateLimiter(map[string]float64{lambda.ServiceID: 10, s3.ServiceID: 0})
+
+	east := l.limiter(lambda.ServiceID, "us-east-1")
+	if east == nil {
+		t.Fatal("Lambda is not limited")
+	}
+	if l.limiter(lambda.ServiceID, "us-east-1") != east {
+		t.Error("the same service and region got a second limiter")
+	}
+	if l.limiter(lambda.ServiceID, "eu-west-1") == east {
+		t.Error("two regions share a limiter")
+	}
+	if l.limiter(s3.ServiceID, "us-east-1") != nil {
+		t.Error("a zero rate limits S3")
+	}
+	if l.limiter("RDS", "us-east-1") != nil {
+		t.Error("a service without a rate is limited")
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+	"github.com/mlabouardy/finops-book/chapter5/curdata"
+)
+
+const dateLayout = "2006-01-02"
+
+// costMetrics are the Cost Explorer cost metrics the report can request.
+var costMetrics = []string{
+	"UnblendedCost",
+	"AmortizedCost",
+	"NetAmortizedCost",
+	"BlendedCost",
+	"NetUnblendedCost",
+}
+
+// parseMetrics validates the -metrics flag and returns the canonical metric
+// names in the order given. The first metric is the primary cost.
+func parseMetrics(value string) ([]string, error) {
+	var metrics []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		var metric string
+		for _, m := range costMetrics {
+			if strings.EqualFold(m, name) {
+				metric = m
+			}
+		}
+		if metric == "" {
+			return nil, fmt.Errorf("unsupported metric %q, expected one of %s", name, strings.Join(costMetrics, ", "))
+		}
+		if !seen[metric] {
+			seen[metric] = true
+			metrics = append(metrics, metric)
+		}
+	}
+	if len(metrics) == 0 {
+		return nil, fmt.Errorf("at least one metric is required")
+	}
+	return metrics, nil
+}
+
+// CostPoint is the cost of a resource over one period of the time series,
+// keyed by metric.
+type CostPoint struct {
+	PeriodStart string
+	Costs       map[string]float64
+}
+
+// costWindow is the reporting period. End is exclusive, as in Cost Explorer.
+type costWindow struct {
+	Start       time.Time
+	End         time.Time
+	Granularity string // DAILY, WEEKLY or MONTHLY
+}
+
+// newCostWindow parses the -start, -end and -granularity flags. Empty dates
+// default to the resource-level lookback window ending today.
+func newCostWindow(start, end, granularity string) (costWindow, error) {
+	window := costWindow{Granularity: strings.ToUpper(granularity)}
+	switch window.Granularity {
+	case "DAILY", "WEEKLY", "MONTHLY":
+	default:
+		return window, fmt.Errorf("unsupported granularity %q", granularity)
+	}
+
+	var err error
+	window.End = time.Now().UTC().Truncate(24 * time.Hour)
+	if end != "" {
+		if window.End, err = time.Parse(dateLayout, end); err != nil {
+			return window, fmt.Errorf("invalid end date %q: %v", end, err)
+		}
+	}
+	window.Start = window.End.AddDate(0, 0, -resourceLookbackDays)
+	if start != "" {
+		if window.Start, err = time.Parse(dateLayout, start); err != nil {
+			return window, fmt.Errorf("invalid start date %q: %v", start, err)
+		}
+	}
+	if !window.Start.Before(window.End) {
+		return window, fmt.Errorf("start date %s must be before end date %s",
+			window.Start.Format(dateLayout), window.End.Format(dateLayout))
+	}
+	return window, nil
+}
+
+// checkResourceLookback rejects a window starting before the oldest day
+// Cost Explorer keeps resource-level data for, as of today: such a lookup
+// fails and would leave every cost unknown.
+func (w costWindow) checkResourceLookback(today time.Time) error {
+	oldest := today.AddDate(0, 0, -resourceLookbackDays)
+	if w.Start.Before(oldest) {
+		return fmt.Errorf("start date %s is before %s: Cost Explorer keeps resource-level data for the last %d days only, use a later -start or -source cur",
+			w.Start.Format(dateLayout), oldest.Format(dateLayout), resourceLookbackDays)
+	}
+	return nil
+}
+
+// apiGranularity is the granularity requested from Cost Explorer, which has
+// no weekly option; weeks are summed locally from daily results.
+func (w costWindow) apiGranularity() types.Granularity {
+	if w.Granularity == "MONTHLY" {
+		return types.GranularityMonthly
+	}
+	return types.GranularityDaily
+}
+
+// periodStart maps a Cost Explorer period start to the start of the
+// reporting period it belongs to.
+func (w costWindow) periodStart(date string) string {
+	if w.Granularity != "WEEKLY" {
+		return date
+	}
+	day, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return date
+	}
+	// Weeks start on Monday
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset).Format(dateLayout)
+}
+
+// periods lists every period start in the window, so pivoted reports have
+// the same columns whether or not a period has any cost.
+func (w costWindow) periods() []string {
+	var periods []string
+	seen := make(map[string]bool)
+	for day := w.Start; day.Before(w.End); day = day.AddDate(0, 0, 1) {
+		period := w.periodStart(day.Format(dateLayout))
+		// Cost Explorer starts the first monthly period at the window start
+		if w.Granularity == "MONTHLY" {
+			period = w.Start.Format(dateLayout)
+			if day.Year() != w.Start.Year() || day.Month() != w.Start.Month() {
+				period = time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC).Format(dateLayout)
+			}
+		}
+		if !seen[period] {
+			seen[period] = true
+			periods = append(periods, period)
+		}
+	}
+	return periods
+}
+
+// costExplorerAPI is the part of the Cost Explorer client the report uses.
+type costExplorerAPI interface {
+	GetCostAndUsageWithResources(ctx context.Context, params *costexplorer.GetCostAndUsageWithResourcesInput, optFns ...func(*costexplorer.Options)) (*costexplorer.GetCostAndUsageWithResourcesOutput, error)
+}
+
+// costQuery describes a resource-level cost lookup.
+type costQuery struct {
+	Window    costWindow
+	Metrics   []string // the first metric is the primary cost
+	Services  []string // SERVICE dimension values to include
+	Breakdown bool     // also group by usage type
+	Usage     bool     // also fetch instance-hours, which needs usage types
+}
+
+// usageMetric is requested alongside the cost metrics when instance-hours
+// are needed. It is summed per usage type, never across them.
+const usageMetric = curdata.UsageMetric
+
+// groupsByUsageType reports whether rows are split by usage type.
+func (q costQuery) groupsByUsageType() bool {
+	return q.Breakdown || q.Usage
+}
+
+// costRow is one resource-level record returned for a query: the cost of a
+// resource in one period, per metric, optionally for a single usage type.
+type costRow struct {
+	ResourceID string
+	Period     string
+	UsageType  string
+	Costs      map[string]float64
+	Usage      float64 // UsageQuantity, when the query asks for usage
+}
+
+// resourceCost is the aggregated cost of one resource over the window.
+type resourceCost struct {
+	Series    []CostPoint
+	Breakdown map[string]float64 // primary metric by usage category
+
+	// Instance-hours and their primary metric cost, when the query asks for
+	// usage
+	InstanceHours     float64
+	InstanceHoursCost float64
+}
+
+// resourcesInput builds the first page request for the query.
+func (q costQuery) resourcesInput() *costexplorer.GetCostAndUsageWithResourcesInput {
+	ceInput := &costexplorer.GetCostAndUsageWithResourcesInput{
+		TimePeriod: &types.DateInterval{
+			Start: aws.String(q.Window.Start.Format(dateLayout)),
+			End:   aws.String(q.Window.End.Format(dateLayout)),
+		},
+		Granularity: q.Window.apiGranularity(),
+		Metrics:     append([]string(nil), q.Metrics...),
+		Filter: &types.Expression{
+			Dimensions: &types.DimensionValues{
+				Key:    types.DimensionService,
+				Values: q.Services,
+			},
+		},
+		GroupBy: []types.GroupDefinition{
+			{
+				Type: types.GroupDefinitionTypeDimension,
+				Key:  aws.String(string(types.DimensionResourceId)),
+			},
+		},
+	}
+	if q.Usage {
+		ceInput.Metrics = append(ceInput.Metrics, usageMetric)
+	}
+	if q.groupsByUsageType() {
+		ceInput.GroupBy = append(ceInput.GroupBy, types.GroupDefinition{
+			Type: types.GroupDefinitionTypeDimension,
+			Key:  aws.String(string(types.DimensionUsageType)),
+		})
+	}
+	return ceInput
+}
+
+// fetchResourceCosts pages through resource-level Cost Explorer data and
+// hands every row to fn. Results are grouped by RESOURCE_ID so the whole
+// estate is covered by a handful of paginated requests.
+func fetchResourceCosts(ctx context.Context, ceClient costExplorerAPI, query costQuery, fn func(costRow) error) error {
+	ceInput := query.resourcesInput()
+	for {
+		ceResp, err := ceClient.GetCostAndUsageWithResources(ctx, ceInput)
+		if err != nil {
+			return fmt.Errorf("error fetching resource costs: %w", err)
+		}
+
+		for _, result := range ceResp.ResultsByTime {
+			for _, group := range result.Groups {
+				if len(group.Keys) == 0 {
+					continue
+				}
+
+				row := costRow{
+					ResourceID: group.Keys[0],
+					Period:     aws.ToString(result.TimePeriod.Start),
+					Costs:      make(map[string]float64, len(query.Metrics)),
+				}
+				if len(group.Keys) > 1 {
+					row.UsageType = group.Keys[1]
+				}
+				for _, metric := range query.Metrics {
+					amount := group.Metrics[metric].Amount
+					row.Costs[metric], err = strconv.ParseFloat(aws.ToString(amount), 64)
+					if err != nil {
+						return fmt.Errorf("error parsing %s for resource %s: %v", metric, row.ResourceID, err)
+					}
+				}
+				if query.Usage {
+					amount := group.Metrics[usageMetric].Amount
+					row.Usage, err = strconv.ParseFloat(aws.ToString(amount), 64)
+					if err != nil {
+						return fmt.Errorf("error parsing %s for resource %s: %v", usageMetric, row.ResourceID, err)
+					}
+				}
+				if err := fn(row); err != nil {
+					return err
+				}
+			}
+		}
+
+		if ceResp.NextPageToken == nil {
+			break
+		}
+		ceInput.NextPageToken = ceResp.NextPageToken
+	}
+	return nil
+}
+
+// getInstanceCosts returns the cost time series of every resource matched by
+// the query, keyed by resource ID. Every returned period is accounted for.
+func getInstanceCosts(ctx context.Context, ceClient costExplorerAPI, query costQuery) (map[string]*resourceCost, error) {
+	periods := make(map[string]map[string]map[string]float64)
+	costs := make(map[string]*resourceCost)
+
+	err := fetchResourceCosts(ctx, ceClient, query, func(row costRow) error {
+		cost, ok := costs[row.ResourceID]
+		if !ok {
+			cost = &resourceCost{}
+			costs[row.ResourceID] = cost
+			periods[row.ResourceID] = make(map[string]map[string]float64)
+		}
+
+		period := query.Window.periodStart(row.Period)
+		amounts := periods[row.ResourceID][period]
+		if amounts == nil {
+			amounts = make(map[string]float64, len(query.Metrics))
+			periods[row.ResourceID][period] = amounts
+		}
+		for metric, amount := range row.Costs {
+			amounts[metric] += amount
+		}
+
+		if query.Breakdown {
+			if cost.Breakdown == nil {
+				cost.Breakdown = make(map[string]float64)
+			}
+			cost.Breakdown[usageCategory(row.UsageType)] += row.Costs[query.Metrics[0]]
+		}
+		if query.Usage && isInstanceHours(row.UsageType) {
+			cost.InstanceHours += row.Usage
+			cost.InstanceHoursCost += row.Costs[query.Metrics[0]]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for resourceID, cost := range costs {
+		for period, amounts := range periods[resourceID] {
+			cost.Series = append(cost.Series, CostPoint{PeriodStart: period, Costs: amounts})
+		}
+		sort.Slice(cost.Series, func(i, j int) bool { return cost.Series[i].PeriodStart < cost.Series[j].PeriodStart })
+	}
+	return costs, nil
+}
+
+// Usage categories of the cost breakdown
+const (
+	categoryCompute      = "Compute"
+	categoryStorage      = "Storage"
+	categoryDataTransfer = "DataTransfer"
+	categoryOther        = "Other"
+)
+
+var usageCategories = []string{categoryCompute, categoryStorage, categoryDataTransfer, categoryOther}
+
+// usageCategory buckets a usage type such as "EUC1-BoxUsage:t3.medium" the
+// way Cost Explorer's USAGE_TYPE_GROUP does, which cannot be used together
+// with RESOURCE_ID grouping.
+func usageCategory(usageType string) string {
+	if curdata.IsComputeUsage(usageType) {
+		return categoryCompute
+	}
+	usageType = curdata.TrimUsageRegion(usageType)
+
+	switch {
+	case strings.HasPrefix(usageType, "EBS:"),
+		strings.HasPrefix(usageType, "SnapshotUsage"),
+		strings.HasPrefix(usageType, "InstanceStore"):
+		return categoryStorage
+	case strings.HasPrefix(usageType, "DataTransfer"),
+		strings.HasSuffix(usageType, "-Bytes"):
+		return categoryDataTransfer
+	default:
+		return categoryOther
+	}
+}
+
+// isInstanceHours reports whether a usage type is metered in instance-hours.
+func isInstanceHours(usageType string) bool {
+	usageType = curdata.TrimUsageRegion(usageType)
+	for _, prefix := range []string{"BoxUsage", "SpotUsage", "DedicatedUsage", "HostBoxUsage", "SchedUsage"} {
+		if strings.HasPrefix(usageType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// totalCosts sums a time series per metric.
+func totalCosts(points []CostPoint) map[string]float64 {
+	totals := make(map[string]float64)
+	for _, point := range points {
+		for metric, cost := range point.Costs {
+			totals[metric] += cost
+		}
+	}
+	return totals
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+func date(value string) time.Time {
+	day, err := time.Parse(dateLayout, value)
+	if err != nil {
+		panic(err)
+	}
+	return day
+}
+
+func TestNewCostWindow(t *testing.T) {
+	tests := []struct {
+		name        string
+		start, end  string
+		granularity string
+		want        costWindow
+		wantErr     string
+	}{
+		{"explicit dates", "2024-05-01", "2024-05-15", "daily", costWindow{date("2024-05-01"), date("2024-05-15"), "DAILY"}, ""},
+		{"default start", "", "2024-05-15", "WEEKLY", costWindow{date("2024-05-01"), date("2024-05-15"), "WEEKLY"}, ""},
+		{"unsupported granularity", "", "", "hourly", costWindow{}, "unsupported granularity"},
+		{"invalid start", "05/01/2024", "2024-05-15", "DAILY", costWindow{}, "invalid start date"},
+		{"invalid end", "", "tomorrow", "DAILY", costWindow{}, "invalid end date"},
+		{"empty window", "2024-05-15", "2024-05-15", "DAILY", costWindow{}, "must be before end date"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window, err := newCostWindow(tt.start, tt.end, tt.granularity)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if window != tt.want {
+				t.Errorf("window = %+v, want %+v", window, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckResourceLookback(t *testing.T) {
+	today := date("2024-05-20")
+	tests := []struct {
+		start   string
+		wantErr bool
+	}{
+		{"2024-05-06", false},
+		{"2024-05-19", false},
+		{"2024-05-05", true},
+		{"2024-01-01", true},
+	}
+	for _, tt := range tests {
+		window := costWindow{Start: date(tt.start), End: today, Granularity: "DAILY"}
+		err := window.checkResourceLookback(today)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkResourceLookback(%s) = %v, want error %v", tt.start, err, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "14 days") {
+			t.Errorf("checkResourceLookback(%s) = %v, want the 14-day limit named", tt.start, err)
+		}
+	}
+}
+
+func TestAPIGranularity(t *testing.T) {
+	tests := []struct {
+		granularity string
+		want        types.Granularity
+	}{
+		{"DAILY", types.GranularityDaily},
+		{"WEEKLY", types.GranularityDaily},
+		{"MONTHLY", types.GranularityMonthly},
+	}
+	for _, tt := range tests {
+		if got := (costWindow{Granularity: tt.granularity}).apiGranularity(); got != tt.want {
+			t.Errorf("apiGranularity(%s) = %s, want %s", tt.granularity, got, tt.want)
+		}
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	tests := []struct {
+		granularity string
+		date        string
+		want        string
+	}{
+		{"DAILY", "2024-05-01", "2024-05-01"},
+		{"MONTHLY", "2024-05-15", "2024-05-15"},
+		{"WEEKLY", "2024-05-06", "2024-05-06"}, // Monday
+		{"WEEKLY", "2024-05-01", "2024-04-29"}, // Wednesday
+		{"WEEKLY", "2024-05-05", "2024-04-29"}, // Sunday
+		{"WEEKLY", "2024-01-02", "2024-01-01"},
+		{"WEEKLY", "2023-01-01", "2022-12-26"}, // across the year
+		{"WEEKLY", "not a date", "not a date"},
+	}
+	for _, tt := range tests {
+		if got := (costWindow{Granularity: tt.granularity}).periodStart(tt.date); got != tt.want {
+			t.Errorf("periodStart(%s, %s) = %s, want %s", tt.granularity, tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestPeriods(t *testing.T) {
+	tests := []struct {
+		name        string
+		start, end  string
+		granularity string
+		want        []string
+	}{
+		{"daily", "2024-05-01", "2024-05-04", "DAILY", []string{"2024-05-01", "2024-05-02", "2024-05-03"}},
+		{"whole weeks", "2024-05-06", "2024-05-20", "WEEKLY", []string{"2024-05-06", "2024-05-13"}},
+		{"partial first and last weeks", "2024-05-01", "2024-05-15", "WEEKLY", []string{"2024-04-29", "2024-05-06", "2024-05-13"}},
+		{"whole months", "2024-04-01", "2024-06-01", "MONTHLY", []string{"2024-04-01", "2024-05-01"}},
+		{"partial first and last months", "2024-04-15", "2024-06-10", "MONTHLY", []string{"2024-04-15", "2024-05-01", "2024-06-01"}},
+		{"months across the year", "2023-12-20", "2024-01-05", "MONTHLY", []string{"2023-12-20", "2024-01-01"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			window := costWindow{Start: date(tt.start), End: date(tt.end), Granularity: tt.granularity}
+			got := window.periods()
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("periods = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsageCategory(t *testing.T) {
+	tests := []struct {
+		usageType string
+		want      string
+	}{
+		{"BoxUsage:t3.micro", categoryCompute},
+		{"EUC1-BoxUsage:t3.medium", categoryCompute},
+		{"EBS:VolumeUsage.gp3", categoryStorage},
+		{"EUW2-EBS:SnapshotUsage", categoryStorage},
+		{"DataTransfer-Out-Bytes", categoryDataTransfer},
+		{"DataTransfer-Regional-Bytes", categoryDataTransfer},
+		{"USE1-EUC1-AWS-Out-Bytes", categoryDataTransfer},
+		{"NatGateway-Hours", categoryOther},
+		{"ElasticIP:IdleAddress", categoryOther},
+	}
+	for _, tt := range tests {
+		if got := usageCategory(tt.usageType); got != tt.want {
+			t.Errorf("usageCategory(%q) = %s, want %s", tt.usageType, got, tt.want)
+		}
+	}
+}
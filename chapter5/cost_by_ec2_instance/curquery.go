@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"github.com/mlabouardy/finops-book/chapter5/curdata"
+)
+
+// curCostExplorer answers Cost Explorer requests from CUR line items, so
+// the report runs offline and beyond Cost Explorer's 14 days of
+// resource-level data. A non-empty account limits the line items to that
+// linked account, like the Cost Explorer view of a member account.
+type curCostExplorer struct {
+	data    *curdata.Data
+	account string
+}
+
+func (c *curCostExplorer) GetCostAndUsageWithResources(ctx context.Context, params *costexplorer.GetCostAndUsageWithResourcesInput, optFns ...func(*costexplorer.Options)) (*costexplorer.GetCostAndUsageWithResourcesOutput, error) {
+	results, err := c.data.CostAndUsage(params.TimePeriod, params.Granularity, params.Metrics, params.Filter, params.GroupBy, c.account)
+	if err != nil {
+		return nil, err
+	}
+	return &costexplorer.GetCostAndUsageWithResourcesOutput{ResultsByTime: results, GroupDefinitions: params.GroupBy}, nil
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/xuri/excelize/v2"
+)
+
+// reportInstance is an instance as read back from a report file.
+type reportInstance struct {
+	InstanceID   string
+	Name         string
+	AccountID    string
+	Region       string
+	InstanceType string // or the class of another resource
+	Tags         map[string]string
+	Cost         float64
+	costUnknown  bool // the report could not fetch the cost
+}
+
+// ReportDiff lists what changed between two reports.
+type ReportDiff struct {
+	Metric      string
+	Added       []DiffInstance
+	Removed     []DiffInstance
+	TypeChanges []TypeChange
+	TagChanges  []TagChange
+	CostChanges []CostChange
+}
+
+// DiffInstance is an instance found in only one of the reports.
+type DiffInstance struct {
+	InstanceID   string
+	Name         string
+	AccountID    string
+	Region       string
+	InstanceType string
+	Cost         float64
+}
+
+// TypeChange is an instance resized between the reports.
+type TypeChange struct {
+	InstanceID string
+	Name       string
+	From       string
+	To         string
+}
+
+// TagChange lists the tags added, removed and changed on an instance.
+type TagChange struct {
+	InstanceID string
+	Name       string
+	Added      map[string]string    `json:",omitempty"`
+	Removed    map[string]string    `json:",omitempty"`
+	Changed    map[string][2]string `json:",omitempty"` // old and new value
+}
+
+// CostChange is a cost delta that reached the threshold.
+type CostChange struct {
+	InstanceID string
+	Name       string
+	From       float64
+	To         float64
+	Delta      float64
+	Percent    *float64 // nil when the old cost is zero
+}
+
+// diffThreshold decides which cost deltas are reported. A delta is reported
+// when it reaches either threshold that is set; with neither set, every
+// change is.
+type diffThreshold struct {
+	absolute float64
+	percent  float64
+}
+
+func (t diffThreshold) exceeded(change CostChange) bool {
+	if t.absolute == 0 && t.percent == 0 {
+		return change.Delta != 0
+	}
+	if t.absolute > 0 && math.Abs(change.Delta) >= t.absolute {
+		return true
+	}
+	// A cost going up from zero exceeds any percentage
+	return t.percent > 0 && change.Delta != 0 && (change.Percent == nil || math.Abs(*change.Percent) >= t.percent)
+}
+
+// runDiff implements the diff subcommand: compare two report files.
+func runDiff(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s diff [flags] <old report> <new report>\n", filepath.Base(os.Args[0]))
+		flags.PrintDefaults()
+	}
+	var threshold diffThreshold
+	flags.Float64Var(&threshold.absolute, "min-delta", 1.00, "report cost changes of at least this many USD (0 to disable)")
+	flags.Float64Var(&threshold.percent, "min-percent", 0, "report cost changes of at least this percentage (0 to disable)")
+	metric := flags.String("metric", "", "cost column to compare (default the first cost column of the old report)")
+	format := flags.String("format", "text", "output format: text or json")
+	placeholder := flags.String("tag-placeholder", "", "the -tag-placeholder the reports were written with; tag columns holding it mean the tag is absent")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return fmt.Errorf("diff needs an old and a new report file")
+	}
+
+	oldReport, oldMetric, err := readReport(flags.Arg(0), *metric, *placeholder)
+	if err != nil {
+		return err
+	}
+	if *metric == "" {
+		*metric = oldMetric
+	}
+	newReport, _, err := readReport(flags.Arg(1), *metric, *placeholder)
+	if err != nil {
+		return err
+	}
+
+	diff := diffReports(oldReport, newReport, threshold)
+	diff.Metric = *metric
+	switch *format {
+	case "text":
+		return writeDiffText(os.Stdout, diff)
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diff)
+	default:
+		return fmt.Errorf("unsupported diff format %q", *format)
+	}
+}
+
+// diffReports compares two reports instance by instance.
+func diffReports(oldReport, newReport map[string]reportInstance, threshold diffThreshold) ReportDiff {
+	diff := ReportDiff{}
+	for _, id := range sortedKeys(newReport) {
+		current := newReport[id]
+		previous, ok := oldReport[id]
+		if !ok {
+			diff.Added = append(diff.Added, diffInstance(current))
+			continue
+		}
+
+		if previous.InstanceType != current.InstanceType {
+			diff.TypeChanges = append(diff.TypeChanges, TypeChange{
+				InstanceID: id,
+				Name:       current.Name,
+				From:       previous.InstanceType,
+				To:         current.InstanceType,
+			})
+		}
+		if change, ok := diffTags(previous.Tags, current.Tags); ok {
+			change.InstanceID = id
+			change.Name = current.Name
+			diff.TagChanges = append(diff.TagChanges, change)
+		}
+
+		// A cost the report could not fetch is not a change
+		if previous.costUnknown || current.costUnknown {
+			continue
+		}
+		change := CostChange{
+			InstanceID: id,
+			Name:       current.Name,
+			From:       previous.Cost,
+			To:         current.Cost,
+			Delta:      current.Cost - previous.Cost,
+		}
+		if previous.Cost != 0 {
+			percent := change.Delta / math.Abs(previous.Cost) * 100
+			change.Percent = &percent
+		}
+		if threshold.exceeded(change) {
+			diff.CostChanges = append(diff.CostChanges, change)
+		}
+	}
+	for _, id := range sortedKeys(oldReport) {
+		if _, ok := newReport[id]; !ok {
+			diff.Removed = append(diff.Removed, diffInstance(oldReport[id]))
+		}
+	}
+
+	// Largest movers first
+	sort.SliceStable(diff.CostChanges, func(i, j int) bool {
+		return math.Abs(diff.CostChanges[i].Delta) > math.Abs(diff.CostChanges[j].Delta)
+	})
+	return diff
+}
+
+func diffInstance(instance reportInstance) DiffInstance {
+	return DiffInstance{
+		InstanceID:   instance.InstanceID,
+		Name:         instance.Name,
+		AccountID:    instance.AccountID,
+		Region:       instance.Region,
+		InstanceType: instance.InstanceType,
+		Cost:         instance.Cost,
+	}
+}
+
+// diffTags reports the tags added, removed and changed between two sets.
+func diffTags(previous, current map[string]string) (TagChange, bool) {
+	change := TagChange{}
+	for key, value := range current {
+		old, ok := previous[key]
+		switch {
+		case !ok:
+			if change.Added == nil {
+				change.Added = make(map[string]string)
+			}
+			change.Added[key] = value
+		case old != value:
+			if change.Changed == nil {
+				change.Changed = make(map[string][2]string)
+			}
+			change.Changed[key] = [2]string{old, value}
+		}
+	}
+	for key, value := range previous {
+		if _, ok := current[key]; !ok {
+			if change.Removed == nil {
+				change.Removed = make(map[string]string)
+			}
+			change.Removed[key] = value
+		}
+	}
+	return change, change.Added != nil || change.Removed != nil || change.Changed != nil
+}
+
+func writeDiffText(w io.Writer, diff ReportDiff) error {
+	var b strings.Builder
+	label := func(id, name string) string {
+		if name == "" {
+			return id
+		}
+		return fmt.Sprintf("%s (%s)", id, name)
+	}
+
+	fmt.Fprintf(&b, "Added instances (%d):\n", len(diff.Added))
+	for _, i := range diff.Added {
+		fmt.Fprintf(&b, "  %s %s %s $%.2f\n", label(i.InstanceID, i.Name), i.InstanceType, i.Region, i.Cost)
+	}
+	fmt.Fprintf(&b, "Removed instances (%d):\n", len(diff.Removed))
+	for _, i := range diff.Removed {
+		fmt.Fprintf(&b, "  %s %s %s $%.2f\n", label(i.InstanceID, i.Name), i.InstanceType, i.Region, i.Cost)
+	}
+	fmt.Fprintf(&b, "Instance type changes (%d):\n", len(diff.TypeChanges))
+	for _, c := range diff.TypeChanges {
+		fmt.Fprintf(&b, "  %s: %s -> %s\n", label(c.InstanceID, c.Name), c.From, c.To)
+	}
+	fmt.Fprintf(&b, "Tag changes (%d):\n", len(diff.TagChanges))
+	for _, c := range diff.TagChanges {
+		var changes []string
+		for _, key := range sortedKeys(c.Added) {
+			changes = append(changes, fmt.Sprintf("+%s=%s", key, c.Added[key]))
+		}
+		for _, key := range sortedKeys(c.Removed) {
+			changes = append(changes, fmt.Sprintf("-%s=%s", key, c.Removed[key]))
+		}
+		for _, key := range sortedKeys(c.Changed) {
+			changes = append(changes, fmt.Sprintf("~%s: %s -> %s", key, c.Changed[key][0], c.Changed[key][1]))
+		}
+		fmt.Fprintf(&b, "  %s: %s\n", label(c.InstanceID, c.Name), strings.Join(changes, ", "))
+	}
+	fmt.Fprintf(&b, "%s changes above threshold (%d):\n", diff.Metric, len(diff.CostChanges))
+	for _, c := range diff.CostChanges {
+		percent := "new"
+		if c.Percent != nil {
+			percent = fmt.Sprintf("%+.1f%%", *c.Percent)
+		}
+		fmt.Fprintf(&b, "  %s: $%.2f -> $%.2f (%+.2f, %s)\n", label(c.InstanceID, c.Name), c.From, c.To, c.Delta, percent)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readReport reads a report written in any -format, by extension, keyed by
+// instance ID. Rows of the long layout repeat an instance with the same
+// totals, so the first row of each instance is kept. It returns the cost
+// column used: metric, or the first cost column of the file. Tag columns
+// holding the placeholder are read as absent tags.
+func readReport(path, metric, placeholder string) (map[string]reportInstance, string, error) {
+	var instances []reportInstance
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		instances, metric, err = readTableReport(path, metric, placeholder, readCSVRows)
+	case ".xlsx":
+		instances, metric, err = readTableReport(path, metric, placeholder, readXLSXRows)
+	case ".json", ".ndjson":
+		instances, metric, err = readJSONReport(path, metric)
+	case ".parquet":
+		instances, metric, err = readParquetReport(path, metric)
+	default:
+		err = fmt.Errorf("unsupported report file type %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to read report %s: %v", path, err)
+	}
+
+	report := make(map[string]reportInstance, len(instances))
+	for _, instance := range instances {
+		if _, ok := report[instance.InstanceID]; !ok && instance.InstanceID != "" {
+			report[instance.InstanceID] = instance
+		}
+	}
+	return report, metric, nil
+}
+
+func readCSVRows(path string) ([][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	return reader.ReadAll()
+}
+
+func readXLSXRows(path string) ([][]string, error) {
+	file, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return file.GetRows(xlsxSheet)
+}
+
+// readTableReport reads the flat layout of CSV and XLSX reports. Reports
+// written before per-metric columns have a single Cost column.
+func readTableReport(path, metric, placeholder string, readRows func(string) ([][]string, error)) ([]reportInstance, string, error) {
+	rows, err := readRows(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(rows) == 0 {
+		return nil, metric, nil
+	}
+
+	header := rows[0]
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[name] = i
+	}
+	var firstCost string
+	for _, name := range header {
+		if name == "Cost" || slices.Contains(costMetrics, name) {
+			firstCost = name
+			break
+		}
+	}
+	// Compare reports from before and after per-metric columns by their
+	// primary cost
+	_, hasMetric := index[metric]
+	_, legacy := index["Cost"]
+	if metric == "" || (!hasMetric && (legacy || metric == "Cost")) {
+		metric = firstCost
+	}
+	costColumn, ok := index[metric]
+	if !ok {
+		return nil, "", fmt.Errorf("no %q cost column", metric)
+	}
+
+	var instances []reportInstance
+	for _, row := range rows[1:] {
+		field := func(name string) string {
+			if i, ok := index[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+
+		instance := reportInstance{
+			InstanceID:   field("InstanceID"),
+			Name:         field("Name"),
+			AccountID:    field("AccountID"),
+			Region:       field("Region"),
+			InstanceType: cmp.Or(field("InstanceType"), field("ResourceClass")),
+			Tags:         parseReportTags(field("Tags")),
+		}
+		for i, name := range header {
+			if key, ok := strings.CutPrefix(name, "Tag:"); ok && i < len(row) && row[i] != "" && row[i] != placeholder {
+				instance.Tags[key] = row[i]
+			}
+		}
+		if costColumn < len(row) && row[costColumn] == unknownCost {
+			instance.costUnknown = true
+		} else if costColumn < len(row) && row[costColumn] != "" {
+			instance.Cost, err = strconv.ParseFloat(row[costColumn], 64)
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid %s %q for %s", metric, row[costColumn], instance.InstanceID)
+			}
+		}
+		instances = append(instances, instance)
+	}
+	return instances, metric, nil
+}
+
+// parseReportTags reads the Tags column: a JSON object, or "key=value; "
+// pairs in reports written before tags were JSON.
+func parseReportTags(value string) map[string]string {
+	tags := make(map[string]string)
+	if json.Unmarshal([]byte(value), &tags) == nil {
+		return tags
+	}
+	for _, pair := range strings.Split(value, ";") {
+		if key, val, ok := strings.Cut(strings.TrimSpace(pair), "="); ok {
+			tags[key] = val
+		}
+	}
+	return tags
+}
+
+// costOf returns the metric of an instance read from a nested report.
+func costOf(instance InstanceData, metric string) float64 {
+	if metric == "" || metric == "Cost" {
+		return instance.Cost
+	}
+	return instance.Costs[metric]
+}
+
+func nestedReportInstance(instance InstanceData, metric string) reportInstance {
+	tags := instance.Tags
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	return reportInstance{
+		InstanceID:   instance.InstanceID,
+		Name:         instance.Name,
+		AccountID:    instance.AccountID,
+		Region:       instance.Region,
+		InstanceType: cmp.Or(instance.InstanceType, instance.ResourceClass),
+		Tags:         tags,
+		Cost:         costOf(instance, metric),
+		costUnknown:  instance.CostUnknown,
+	}
+}
+
+// readJSONReport reads a JSON array or NDJSON report of InstanceData.
+func readJSONReport(path, metric string) ([]reportInstance, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+
+	if metric == "" {
+		metric = "Cost"
+	}
+	decoder := json.NewDecoder(file)
+	var instances []reportInstance
+	add := func(instance InstanceData) {
+		instances = append(instances, nestedReportInstance(instance, metric))
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var all []InstanceData
+		if err := decoder.Decode(&all); err != nil {
+			return nil, "", err
+		}
+		for _, instance := range all {
+			add(instance)
+		}
+		return instances, metric, nil
+	}
+	for {
+		var instance InstanceData
+		err := decoder.Decode(&instance)
+		if err == io.EOF {
+			return instances, metric, nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		add(instance)
+	}
+}
+
+func readParquetReport(path, metric string) ([]reportInstance, string, error) {
+	all, err := parquet.ReadFile[InstanceData](path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if metric == "" {
+		metric = "Cost"
+	}
+	instances := make([]reportInstance, len(all))
+	for i, instance := range all {
+		instances[i] = nestedReportInstance(instance, metric)
+	}
+	return instances, metric, nil
+}
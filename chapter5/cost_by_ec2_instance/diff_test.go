@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadReportTagPlaceholder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.csv")
+	report := "InstanceID,Name,Region,InstanceType,Tag:Owner,Tag:Project,Tags,UnblendedCost\n" +
+		"i-1,web,us-east-1,t3.micro,alice,n/a,{},1.5\n" +
+		"i-2,db,us-east-1,m5.large,n/a,n/a,{},2\n"
+	if err := os.WriteFile(path, []byte(report), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		placeholder string
+		want        map[string]map[string]string
+	}{
+		{"without placeholder", "", map[string]map[string]string{
+			"i-1": {"Owner": "alice", "Project": "n/a"},
+			"i-2": {"Owner": "n/a", "Project": "n/a"},
+		}},
+		{"with placeholder", "n/a", map[string]map[string]string{
+			"i-1": {"Owner": "alice"},
+			"i-2": {},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instances, metric, err := readReport(path, "", tt.placeholder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if metric != "UnblendedCost" {
+				t.Errorf("metric = %s, want UnblendedCost", metric)
+			}
+			for id, want := range tt.want {
+				got := instances[id].Tags
+				if len(got) != len(want) {
+					t.Errorf("%s tags = %v, want %v", id, got, want)
+					continue
+				}
+				for key, value := range want {
+					if got[key] != value {
+						t.Errorf("%s tag %s = %q, want %q", id, key, got[key], value)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDiffReports(t *testing.T) {
+	oldReport := map[string]reportInstance{
+		"i-kept":    {InstanceID: "i-kept", Name: "web", InstanceType: "t3.micro", Tags: map[string]string{"Owner": "alice", "Env": "dev"}, Cost: 10},
+		"i-removed": {InstanceID: "i-removed", Name: "old", Region: "us-east-1", InstanceType: "m5.large", Cost: 5},
+		"i-small":   {InstanceID: "i-small", InstanceType: "t3.nano", Cost: 10},
+		"i-free":    {InstanceID: "i-free", InstanceType: "t3.nano"},
+		"i-unknown": {InstanceID: "i-unknown", InstanceType: "t3.nano", costUnknown: true},
+	}
+	newReport := map[string]reportInstance{
+		"i-kept":    {InstanceID: "i-kept", Name: "web", InstanceType: "t3.large", Tags: map[string]string{"Owner": "bob", "Team": "data"}, Cost: 30},
+		"i-added":   {InstanceID: "i-added", Name: "new", Region: "eu-west-1", InstanceType: "c5.xlarge", Cost: 7},
+		"i-small":   {InstanceID: "i-small", InstanceType: "t3.nano", Cost: 10.5},
+		"i-free":    {InstanceID: "i-free", InstanceType: "t3.nano", Cost: 0.4},
+		"i-unknown": {InstanceID: "i-unknown", InstanceType: "t3.nano", Cost: 100},
+	}
+
+	diff := diffReports(oldReport, newReport, diffThreshold{})
+	wantAdded := []DiffInstance{{InstanceID: "i-added", Name: "new", Region: "eu-west-1", InstanceType: "c5.xlarge", Cost: 7}}
+	if !reflect.DeepEqual(diff.Added, wantAdded) {
+		t.Errorf("Added = %+v, want %+v", diff.Added, wantAdded)
+	}
+	wantRemoved := []DiffInstance{{InstanceID: "i-removed", Name: "old", Region: "us-east-1", InstanceType: "m5.large", Cost: 5}}
+	if !reflect.DeepEqual(diff.Removed, wantRemoved) {
+		t.Errorf("Removed = %+v, want %+v", diff.Removed, wantRemoved)
+	}
+	wantTypes := []TypeChange{{InstanceID: "i-kept", Name: "web", From: "t3.micro", To: "t3.large"}}
+	if !reflect.DeepEqual(diff.TypeChanges, wantTypes) {
+		t.Errorf("TypeChanges = %+v, want %+v", diff.TypeChanges, wantTypes)
+	}
+	wantTags := []TagChange{{
+		InstanceID: "i-kept",
+		Name:       "web",
+		Added:      map[string]string{"Team": "data"},
+		Removed:    map[string]string{"Env": "dev"},
+		Changed:    map[string][2]string{"Owner": {"alice", "bob"}},
+	}}
+	if !reflect.DeepEqual(diff.TagChanges, wantTags) {
+		t.Errorf("TagChanges = %+v, want %+v", diff.TagChanges, wantTags)
+	}
+
+	tests := []struct {
+		name      string
+		threshold diffThreshold
+		want      []string
+	}{
+		{"no threshold", diffThreshold{}, []string{"i-kept", "i-small", "i-free"}},
+		{"absolute", diffThreshold{absolute: 1}, []string{"i-kept"}},
+		{"percent", diffThreshold{percent: 5}, []string{"i-kept", "i-small", "i-free"}},
+		{"percent above small change", diffThreshold{percent: 10}, []string{"i-kept", "i-free"}},
+		{"small absolute", diffThreshold{absolute: 0.45}, []string{"i-kept", "i-small"}},
+		{"either threshold", diffThreshold{absolute: 0.45, percent: 100}, []string{"i-kept", "i-small", "i-free"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, change := range diffReports(oldReport, newReport, tt.threshold).CostChanges {
+				got = append(got, change.InstanceID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cost changes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffReportsCostPercent(t *testing.T) {
+	oldReport := map[string]reportInstance{
+		"i-1": {InstanceID: "i-1", Cost: 20},
+		"i-2": {InstanceID: "i-2"},
+	}
+	newReport := map[string]reportInstance{
+		"i-1": {InstanceID: "i-1", Cost: 15},
+		"i-2": {InstanceID: "i-2", Cost: 3},
+	}
+	changes := diffReports(oldReport, newReport, diffThreshold{}).CostChanges
+	if len(changes) != 2 {
+		t.Fatalf("cost changes = %+v, want 2", changes)
+	}
+	if changes[0].InstanceID != "i-1" || changes[0].Delta != -5 || changes[0].Percent == nil || *changes[0].Percent != -25 {
+		t.Errorf("first change = %+v, want i-1 down 5 (-25%%)", changes[0])
+	}
+	if changes[1].InstanceID != "i-2" || changes[1].Percent != nil {
+		t.Errorf("second change = %+v, want i-2 without a percentage", changes[1])
+	}
+}
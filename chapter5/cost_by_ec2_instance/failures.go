@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/smithy-go"
+)
+
+// Error classes of the end-of-run summary
+const (
+	classThrottling   = "throttling"
+	classAccessDenied = "access-denied"
+	classNetwork      = "network"
+	classAPI          = "api-error"
+	classOther        = "other"
+)
+
+// errorClass buckets an error by what the operator can do about it:
+// throttling that outlasted the retries, missing permissions or opt-in,
+// connectivity, or any other error returned by AWS.
+func errorClass(err error) string {
+	var apiErr smithy.APIError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &apiErr):
+		code := apiErr.ErrorCode()
+		if _, ok := retry.DefaultThrottleErrorCodes[code]; ok {
+			return classThrottling
+		}
+		switch {
+		case strings.HasPrefix(code, "AccessDenied"), strings.HasPrefix(code, "Unauthorized"),
+			code == "AuthFailure", code == "OptInRequired", code == "InvalidClientTokenId",
+			code == "UnrecognizedClientException", code == "ExpiredToken":
+			return classAccessDenied
+		}
+		return classAPI
+	case errors.As(err, &netErr), errors.Is(err, context.DeadlineExceeded):
+		return classNetwork
+	}
+	return classOther
+}
+
+// runFailure is one failed AWS operation of the run.
+type runFailure struct {
+	account   string
+	region    string // "global" for operations not bound to a region
+	operation string
+	class     string
+	message   string
+}
+
+// failureLog counts the AWS operations of a run and records the failed
+// ones, so the run can end with a summary and an exit status that reflect
+// partial failures. It is safe for concurrent use.
+type failureLog struct {
+	mu         sync.Mutex
+	operations int
+	failures   []runFailure
+}
+
+// attempt counts an operation towards the failure rate.
+func (l *failureLog) attempt() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.operations++
+}
+
+// record reports a failed operation on stderr and keeps it for the summary.
+func (l *failureLog) record(account accountInfo, region, operation string, err error) {
+	if region == "" {
+		region = "global"
+	}
+	fmt.Fprintf(os.Stderr, "Error %s for account %s region %s: %v\n", operation, account.ID, region, err)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failures = append(l.failures, runFailure{
+		account:   account.ID,
+		region:    region,
+		operation: operation,
+		class:     errorClass(err),
+		message:   err.Error(),
+	})
+}
+
+// failedRegions lists the regions in which an operation failed for an
+// account.
+func (l *failureLog) failedRegions(account, operation string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	regions := make(map[string]bool)
+	for _, f := range l.failures {
+		if f.account == account && f.operation == operation {
+			regions[f.region] = true
+		}
+	}
+	return sortedKeys(regions)
+}
+
+// rate is the percentage of operations that failed.
+func (l *failureLog) rate() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.operations == 0 {
+		return 0
+	}
+	return float64(len(l.failures)) / float64(l.operations) * 100
+}
+
+// writeSummary writes the failures grouped by region and error class, most
+// frequent first, each with the accounts and operations it hit and one
+// sample message.
+func (l *failureLog) writeSummary(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.failures) == 0 {
+		return
+	}
+
+	type group struct {
+		region, class string
+		count         int
+		accounts      map[string]bool
+		operations    map[string]bool
+		sample        string
+	}
+	groups := make(map[string]*group)
+	for _, f := range l.failures {
+		key := f.region + "\x00" + f.class
+		g := groups[key]
+		if g == nil {
+			g = &group{region: f.region, class: f.class, accounts: make(map[string]bool), operations: make(map[string]bool), sample: f.message}
+			groups[key] = g
+		}
+		g.count++
+		g.accounts[f.account] = true
+		g.operations[f.operation] = true
+	}
+
+	sorted := make([]*group, 0, len(groups))
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		if sorted[i].region != sorted[j].region {
+			return sorted[i].region < sorted[j].region
+		}
+		return sorted[i].class < sorted[j].class
+	})
+
+	fmt.Fprintf(w, "%d of %d AWS operations failed (%.1f%%):\n", len(l.failures), l.operations, float64(len(l.failures))/float64(l.operations)*100)
+	for _, g := range sorted {
+		fmt.Fprintf(w, "  %-16s %-14s %4d  accounts: %s; operations: %s\n", g.region, g.class, g.count,
+			strings.Join(sortedKeys(g.accounts), " "), strings.Join(sortedKeys(g.operations), ", "))
+		fmt.Fprintf(w, "      e.g. %s\n", g.sample)
+	}
+}
+
+// errTooManyFailures ends a run whose report was written but whose failure
+// rate exceeds -max-failure-rate.
+var errTooManyFailures = errors.New("too many failed AWS operations")
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func TestErrorClass(t *testing.T) {
+	accessDenied := &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized"}
+	throttled := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "rate exceeded"}
+	optIn := &smithy.GenericAPIError{Code: "OptInRequired", Message: "region not enabled"}
+	invalid := &smithy.GenericAPIError{Code: "InvalidParameterValue", Message: "bad filter"}
+	dial := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"access denied", accessDenied, classAccessDenied},
+		{"wrapped access denied", fmt.Errorf("unable to describe instances, %w", accessDenied), classAccessDenied},
+		{"twice wrapped access denied", fmt.Errorf("unable to fetch instance costs, %w", fmt.Errorf("error fetching resource costs: %w", accessDenied)), classAccessDenied},
+		{"wrapped throttling", fmt.Errorf("unable to list tags of function f, %w", throttled), classThrottling},
+		{"wrapped opt-in", fmt.Errorf("unable to describe regions: %w", optIn), classAccessDenied},
+		{"wrapped api error", fmt.Errorf("unable to describe volumes, %w", invalid), classAPI},
+		{"wrapped network error", fmt.Errorf("unable to list buckets, %w", dial), classNetwork},
+		{"wrapped deadline", fmt.Errorf("rate limit wait: %w", context.DeadlineExceeded), classNetwork},
+		{"plain error", errors.New("boom"), classOther},
+		{"flattened error", fmt.Errorf("unable to describe instances, %v", accessDenied), classOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorClass(tt.err); got != tt.want {
+				t.Errorf("errorClass(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFailureLogRate(t *testing.T) {
+	var log failureLog
+	if log.rate() != 0 {
+		t.Fatalf("rate of an empty log = %v, want 0", log.rate())
+	}
+	for i := 0; i < 4; i++ {
+		log.attempt()
+	}
+	log.record(accountInfo{ID: "111111111111"}, "us-east-1", "describing instances",
+		fmt.Errorf("unable to describe instances, %w", &smithy.GenericAPIError{Code: "AccessDenied"}))
+	if got := log.rate(); got != 25 {
+		t.Errorf("rate = %v, want 25", got)
+	}
+	if got := log.failures[0].class; got != classAccessDenied {
+		t.Errorf("recorded class = %s, want %s", got, classAccessDenied)
+	}
+}
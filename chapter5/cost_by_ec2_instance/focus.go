@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mlabouardy/finops-book/chapter5/focus"
+)
+
+// focusResourceTypes names the FOCUS ResourceType of each -resources kind.
+var focusResourceTypes = map[string]string{
+	resourceEC2:    "Instance",
+	resourceEBS:    "Volume",
+	resourceRDS:    "DB Instance",
+	resourceLambda: "Function",
+	resourceS3:     "Bucket",
+	resourceELB:    "Load Balancer",
+	resourceNAT:    "NAT Gateway",
+}
+
+// instanceFocusRows converts a resource's cost series into one FOCUS row
+// per period. BilledCost is the unblended cost and EffectiveCost the
+// amortized cost. Without negotiated rates ContractedCost equals
+// BilledCost, and ListCost is the on-demand rate applied to the running
+// hours, spread over the periods by billed cost, when the price catalog
+// knows the rate.
+func instanceFocusRows(instance InstanceData, window costWindow, billing accountInfo) []focus.Row {
+	var listCost float64
+	var listUnitPrice *float64
+	if instance.OnDemandRate > 0 {
+		rate := instance.OnDemandRate
+		listUnitPrice = &rate
+		listCost = instance.OnDemandRate * instance.RunningHours
+	}
+
+	service, resourceType := ec2ComputeService, "Instance"
+	description := fmt.Sprintf("%s instance usage", instance.InstanceType)
+	if instance.service != "" && instance.ResourceType != resourceEC2 {
+		service, resourceType = instance.service, focusResourceTypes[instance.ResourceType]
+		description = strings.TrimSpace(fmt.Sprintf("%s %s usage", instance.ResourceClass, resourceType))
+	}
+
+	var rows []focus.Row
+	for i, point := range instance.Series {
+		start, _ := time.Parse(dateLayout, point.PeriodStart)
+		end := window.End
+		if i+1 < len(instance.Series) {
+			end, _ = time.Parse(dateLayout, instance.Series[i+1].PeriodStart)
+		}
+		// Weeks start on Monday, which may be before the window
+		if start.Before(window.Start) {
+			start = window.Start
+		}
+		billingStart := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+		billed := point.Costs["UnblendedCost"]
+		row := focus.Row{
+			BilledCost:         billed,
+			BillingAccountID:   billing.ID,
+			BillingAccountName: billing.Name,
+			BillingCurrency:    "USD",
+			BillingPeriodStart: billingStart,
+			BillingPeriodEnd:   billingStart.AddDate(0, 1, 0),
+			ChargeCategory:     "Usage",
+			ChargeDescription:  description,
+			ChargePeriodStart:  start,
+			ChargePeriodEnd:    end,
+			ContractedCost:     billed,
+			EffectiveCost:      point.Costs["AmortizedCost"],
+			InvoiceIssuerName:  focus.Provider,
+			ListCost:           billed,
+			ListUnitPrice:      listUnitPrice,
+			ProviderName:       focus.Provider,
+			PublisherName:      focus.Provider,
+			RegionID:           instance.Region,
+			RegionName:         instance.Region,
+			AvailabilityZone:   instance.AvailabilityZone,
+			ResourceID:         instance.InstanceID,
+			ResourceName:       instance.Name,
+			ResourceType:       resourceType,
+			ServiceCategory:    focus.ServiceCategory(service),
+			ServiceName:        service,
+			SubAccountID:       instance.AccountID,
+			SubAccountName:     instance.AccountName,
+			Tags:               instance.Tags,
+		}
+		if listUnitPrice != nil && instance.Costs["UnblendedCost"] > 0 {
+			row.ListCost = listCost * billed / instance.Costs["UnblendedCost"]
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// instanceTypeSpec is the hardware of an instance type.
+type instanceTypeSpec struct {
+	VCPUs         int32
+	MemoryGiB     float64
+	Architectures []string
+}
+
+// instanceTypeCache holds DescribeInstanceTypes results per region, so each
+// type is described once per region however many accounts run it.
+type instanceTypeCache struct {
+	mu      sync.Mutex
+	regions map[string]map[string]instanceTypeSpec
+}
+
+func newInstanceTypeCache() *instanceTypeCache {
+	return &instanceTypeCache{regions: make(map[string]map[string]instanceTypeSpec)}
+}
+
+// describeInstanceTypesLimit is the most instance types one
+// DescribeInstanceTypes request accepts.
+const describeInstanceTypesLimit = 100
+
+// lookup returns the specs of the given instance types in a region,
+// describing the ones not cached yet. Types EC2 does not know, such as
+// retired ones, are left out.
+func (c *instanceTypeCache) lookup(ctx context.Context, client ec2.DescribeInstanceTypesAPIClient, region string, instanceTypes []string) (map[string]instanceTypeSpec, error) {
+	c.mu.Lock()
+	cached := c.regions[region]
+	if cached == nil {
+		cached = make(map[string]instanceTypeSpec)
+		c.regions[region] = cached
+	}
+	var missing []ec2types.InstanceType
+	seen := make(map[string]bool)
+	for _, instanceType := range instanceTypes {
+		if _, ok := cached[instanceType]; !ok && !seen[instanceType] {
+			seen[instanceType] = true
+			missing = append(missing, ec2types.InstanceType(instanceType))
+		}
+	}
+	c.mu.Unlock()
+
+	described := make(map[string]instanceTypeSpec)
+	for start := 0; start < len(missing); start += describeInstanceTypesLimit {
+		batch := missing[start:min(start+describeInstanceTypesLimit, len(missing))]
+		err := describeInstanceTypes(ctx, client, batch, described)
+		if !isInvalidInstanceType(err) {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		// One unknown type fails the whole request; describe the batch one
+		// type at a time to find the others
+		for _, instanceType := range batch {
+			err := describeInstanceTypes(ctx, client, []ec2types.InstanceType{instanceType}, described)
+			if err != nil && !isInvalidInstanceType(err) {
+				return nil, err
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for instanceType, spec := range described {
+		cached[instanceType] = spec
+	}
+	specs := make(map[string]instanceTypeSpec, len(instanceTypes))
+	for _, instanceType := range instanceTypes {
+		if spec, ok := cached[instanceType]; ok {
+			specs[instanceType] = spec
+		}
+	}
+	return specs, nil
+}
+
+// describeInstanceTypes adds the specs of the given instance types to
+// described.
+func describeInstanceTypes(ctx context.Context, client ec2.DescribeInstanceTypesAPIClient, instanceTypes []ec2types.InstanceType, described map[string]instanceTypeSpec) error {
+	paginator := ec2.NewDescribeInstanceTypesPaginator(client, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: instanceTypes,
+	})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to describe instance types, %w", err)
+		}
+		for _, info := range resp.InstanceTypes {
+			spec := instanceTypeSpec{}
+			if info.VCpuInfo != nil {
+				spec.VCPUs = aws.ToInt32(info.VCpuInfo.DefaultVCpus)
+			}
+			if info.MemoryInfo != nil {
+				spec.MemoryGiB = float64(aws.ToInt64(info.MemoryInfo.SizeInMiB)) / 1024
+			}
+			if info.ProcessorInfo != nil {
+				for _, arch := range info.ProcessorInfo.SupportedArchitectures {
+					spec.Architectures = append(spec.Architectures, string(arch))
+				}
+			}
+			described[string(info.InstanceType)] = spec
+		}
+	}
+	return nil
+}
+
+// isInvalidInstanceType reports whether DescribeInstanceTypes rejected an
+// instance type it does not know.
+func isInvalidInstanceType(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidInstanceType"
+}
+
+// addInstanceSpecs sets the hardware of each instance from its type. The
+// instance's own architecture wins over the ones its type supports.
+func addInstanceSpecs(instances []InstanceData, specs map[string]instanceTypeSpec) {
+	for i := range instances {
+		spec, ok := specs[instances[i].InstanceType]
+		if !ok {
+			continue
+		}
+		instances[i].VCPUs = spec.VCPUs
+		instances[i].MemoryGiB = spec.MemoryGiB
+		if instances[i].Architecture == "" {
+			instances[i].Architecture = strings.Join(spec.Architectures, " ")
+		}
+	}
+}
+
+// addUnitCosts normalizes the effective hourly rate of an instance by its
+// size, so instances of different families compare fairly.
+func addUnitCosts(instance *InstanceData) {
+	if instance.VCPUs > 0 {
+		instance.CostPerVCPUHour = instance.EffectiveRate / float64(instance.VCPUs)
+	}
+	if instance.MemoryGiB > 0 {
+		instance.CostPerGiBHour = instance.EffectiveRate / instance.MemoryGiB
+	}
+}
+
+// instanceFamily returns the family of an instance type, e.g. "m5" for
+// "m5.large".
+func instanceFamily(instanceType string) string {
+	family, _, _ := strings.Cut(instanceType, ".")
+	return family
+}
+
+// familyCost aggregates the instances of one instance family. Unit costs
+// only cover instances whose type was described: the others count toward
+// Cost and InstanceHoursCost but not toward VCPUHours, GiBHours or
+// SizedHoursCost.
+type familyCost struct {
+	Family            string
+	Instances         int
+	UnknownSpecs      int // instances whose type could not be described
+	RunningHours      float64
+	VCPUHours         float64
+	GiBHours          float64
+	Cost              float64 // primary metric, all usage
+	InstanceHoursCost float64 // primary metric, instance-hours only
+	SizedHoursCost    float64 // InstanceHoursCost of instances with known specs
+}
+
+// familyCosts aggregates instances by family, largest spend first.
+func familyCosts(runs []*accountRun) []*familyCost {
+	families := make(map[string]*familyCost)
+	for _, run := range runs {
+		for _, instance := range run.instances {
+			if instance.ResourceType != resourceEC2 || instance.CostUnknown {
+				continue
+			}
+			name := instanceFamily(instance.InstanceType)
+			family := families[name]
+			if family == nil {
+				family = &familyCost{Family: name}
+				families[name] = family
+			}
+			family.Instances++
+			family.RunningHours += instance.RunningHours
+			family.Cost += instance.Cost
+			family.InstanceHoursCost += instance.EffectiveRate * instance.RunningHours
+			if instance.VCPUs == 0 || instance.MemoryGiB == 0 {
+				family.UnknownSpecs++
+				continue
+			}
+			family.VCPUHours += instance.RunningHours * float64(instance.VCPUs)
+			family.GiBHours += instance.RunningHours * instance.MemoryGiB
+			family.SizedHoursCost += instance.EffectiveRate * instance.RunningHours
+		}
+	}
+
+	sorted := make([]*familyCost, 0, len(families))
+	for _, family := range families {
+		sorted = append(sorted, family)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Cost != sorted[j].Cost {
+			return sorted[i].Cost > sorted[j].Cost
+		}
+		return sorted[i].Family < sorted[j].Family
+	})
+	return sorted
+}
+
+// writeFamilyCosts writes the family aggregate as CSV. Unit costs divide
+// the instance-hour spend of instances with known specs by the vCPU-hours
+// and GiB-hours it bought.
+func writeFamilyCosts(w io.Writer, families []*familyCost) error {
+	writer := csv.NewWriter(w)
+	header := []string{"Family", "Instances", "UnknownSpecs", "RunningHours", "VCPUHours", "GiBHours", "Cost", "InstanceHoursCost", "CostPerVCPUHour", "CostPerGiBHour"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, family := range families {
+		var perVCPU, perGiB float64
+		if family.VCPUHours > 0 {
+			perVCPU = family.SizedHoursCost / family.VCPUHours
+		}
+		if family.GiBHours > 0 {
+			perGiB = family.SizedHoursCost / family.GiBHours
+		}
+		record := []string{
+			family.Family,
+			strconv.Itoa(family.Instances),
+			strconv.Itoa(family.UnknownSpecs),
+			formatCell(family.RunningHours),
+			formatCell(family.VCPUHours),
+			formatCell(family.GiBHours),
+			formatCell(family.Cost),
+			formatCell(family.InstanceHoursCost),
+			formatCell(hourlyRate(perVCPU)),
+			formatCell(hourlyRate(perGiB)),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
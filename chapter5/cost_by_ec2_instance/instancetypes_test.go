@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
+)
+
+// fakeInstanceTypes answers DescribeInstanceTypes from a fixed list and, like
+// EC2, fails the whole request when any type is unknown.
+type fakeInstanceTypes struct {
+	known map[string]ec2types.InstanceTypeInfo
+	calls int
+}
+
+func (f *fakeInstanceTypes) DescribeInstanceTypes(ctx context.Context, params *ec2.DescribeInstanceTypesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeInstanceTypesOutput, error) {
+	f.calls++
+	resp := &ec2.DescribeInstanceTypesOutput{}
+	for _, instanceType := range params.InstanceTypes {
+		info, ok := f.known[string(instanceType)]
+		if !ok {
+			return nil, &smithy.GenericAPIError{Code: "InvalidInstanceType", Message: "invalid instance type " + string(instanceType)}
+		}
+		resp.InstanceTypes = append(resp.InstanceTypes, info)
+	}
+	return resp, nil
+}
+
+func instanceTypeInfo(instanceType string, vcpus int32, memoryMiB int64) ec2types.InstanceTypeInfo {
+	return ec2types.InstanceTypeInfo{
+		InstanceType:  ec2types.InstanceType(instanceType),
+		VCpuInfo:      &ec2types.VCpuInfo{DefaultVCpus: aws.Int32(vcpus)},
+		MemoryInfo:    &ec2types.MemoryInfo{SizeInMiB: aws.Int64(memoryMiB)},
+		ProcessorInfo: &ec2types.ProcessorInfo{SupportedArchitectures: []ec2types.ArchitectureType{ec2types.ArchitectureTypeX8664}},
+	}
+}
+
+func TestInstanceTypeCacheLookup(t *testing.T) {
+	client := &fakeInstanceTypes{known: map[string]ec2types.InstanceTypeInfo{
+		"m5.large":  instanceTypeInfo("m5.large", 2, 8192),
+		"c5.xlarge": instanceTypeInfo("c5.xlarge", 4, 8192),
+	}}
+	cache := newInstanceTypeCache()
+
+	specs, err := cache.lookup(context.Background(), client, "us-east-1", []string{"m5.large", "c5.xlarge", "m5.large"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec := specs["m5.large"]; spec.VCPUs != 2 || spec.MemoryGiB != 8 || strings.Join(spec.Architectures, " ") != "x86_64" {
+		t.Errorf("m5.large spec = %+v", spec)
+	}
+	if client.calls != 1 {
+		t.Errorf("first lookup made %d calls, want 1", client.calls)
+	}
+
+	// Cached types are not described again
+	if _, err := cache.lookup(context.Background(), client, "us-east-1", []string{"c5.xlarge"}); err != nil {
+		t.Fatal(err)
+	}
+	if client.calls != 1 {
+		t.Errorf("cached lookup made %d more calls, want none", client.calls-1)
+	}
+}
+
+func TestInstanceTypeCacheLookupInvalidType(t *testing.T) {
+	client := &fakeInstanceTypes{known: map[string]ec2types.InstanceTypeInfo{
+		"m5.large":  instanceTypeInfo("m5.large", 2, 8192),
+		"c5.xlarge": instanceTypeInfo("c5.xlarge", 4, 8192),
+	}}
+	specs, err := newInstanceTypeCache().lookup(context.Background(), client, "us-east-1", []string{"m5.large", "x9.retired", "c5.xlarge"})
+	if err != nil {
+		t.Fatalf("lookup with an invalid type = %v, want the valid types described", err)
+	}
+	if len(specs) != 2 || specs["m5.large"].VCPUs != 2 || specs["c5.xlarge"].VCPUs != 4 {
+		t.Errorf("specs = %+v, want m5.large and c5.xlarge", specs)
+	}
+	// The batch, then each of its three types
+	if client.calls != 4 {
+		t.Errorf("lookup made %d calls, want 4", client.calls)
+	}
+}
+
+func TestFamilyCosts(t *testing.T) {
+	runs := []*accountRun{{instances: []InstanceData{
+		{InstanceType: "m5.large", ResourceType: resourceEC2, VCPUs: 2, MemoryGiB: 8, RunningHours: 10, EffectiveRate: 0.1, Cost: 1.5},
+		{InstanceType: "m5.xlarge", ResourceType: resourceEC2, VCPUs: 4, MemoryGiB: 16, RunningHours: 10, EffectiveRate: 0.2, Cost: 2},
+		// Spec lookup failed
+		{InstanceType: "m5.metal", ResourceType: resourceEC2, RunningHours: 10, EffectiveRate: 5, Cost: 50},
+		{InstanceType: "t3.micro", ResourceType: resourceEC2, VCPUs: 2, MemoryGiB: 1, RunningHours: 5, EffectiveRate: 0.01, Cost: 0.05},
+		{InstanceType: "t3.nano", ResourceType: resourceEC2, CostUnknown: true},
+		{InstanceType: "vol-1", ResourceType: resourceEBS, Cost: 100},
+	}}}
+
+	families := familyCosts(runs)
+	if len(families) != 2 || families[0].Family != "m5" || families[1].Family != "t3" {
+		t.Fatalf("families = %+v, want m5 then t3", families)
+	}
+	m5 := families[0]
+	if m5.Instances != 3 || m5.UnknownSpecs != 1 || m5.RunningHours != 30 || m5.VCPUHours != 60 || m5.GiBHours != 240 {
+		t.Errorf("m5 = %+v", m5)
+	}
+	if m5.Cost != 53.5 || m5.InstanceHoursCost != 53 || m5.SizedHoursCost != 3 {
+		t.Errorf("m5 costs = %+v", m5)
+	}
+
+	var b strings.Builder
+	if err := writeFamilyCosts(&b, families); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	// Unit costs leave out the instance without specs: $3 over 60 vCPU-hours
+	// and 240 GiB-hours
+	if want := "m5,3,1,30.00,60.00,240.00,53.50,53.00,0.0500,0.0125"; lines[1] != want {
+		t.Errorf("m5 row = %s, want %s", lines[1], want)
+	}
+}
+
+func TestAddUnitCosts(t *testing.T) {
+	instance := InstanceData{VCPUs: 4, MemoryGiB: 16, EffectiveRate: 0.2}
+	addUnitCosts(&instance)
+	if instance.CostPerVCPUHour != 0.05 || instance.CostPerGiBHour != 0.0125 {
+		t.Errorf("unit costs = %v per vCPU-hour, %v per GiB-hour", instance.CostPerVCPUHour, instance.CostPerGiBHour)
+	}
+
+	unknown := InstanceData{EffectiveRate: 0.2}
+	addUnitCosts(&unknown)
+	if unknown.CostPerVCPUHour != 0 || unknown.CostPerGiBHour != 0 {
+		t.Errorf("unit costs without specs = %v, %v, want none", unknown.CostPerVCPUHour, unknown.CostPerGiBHour)
+	}
+}
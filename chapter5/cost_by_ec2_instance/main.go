@@ -2,106 +2,773 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-	"strconv"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
-	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/mlabouardy/finops-book/chapter5/curdata"
+	"github.com/mlabouardy/finops-book/chapter5/focus"
 )
 
+const (
+	// Cost Explorer only keeps resource-level data for the last 14 days
+	resourceLookbackDays = 14
+
+	ec2ComputeService = curdata.EC2ComputeService
+	// EBS, data transfer and Elastic IP charges are billed under EC2 - Other
+	ec2OtherService = curdata.EC2OtherService
+)
+
+// InstanceData is one row of the report: an EC2 instance, or another
+// resource collected with -resources, whose ID is then the one Cost Explorer
+// reports its costs under.
 type InstanceData struct {
-	InstanceID   string
-	Name         string
-	Region       string
-	InstanceType string
-	Tags         map[string]string
-	Cost         float64
+	AccountID        string
+	AccountName      string // set in -org mode
+	ResourceType     string // -resources kind, e.g. ec2 or rds
+	InstanceID       string
+	Name             string
+	Region           string
+	InstanceType     string // EC2 instance type
+	ResourceClass    string // type of another resource, e.g. volume type, DB instance class, runtime or load balancer type
+	State            string
+	Lifecycle        string // on-demand, spot, scheduled or capacity-block
+	LaunchTime       time.Time
+	AgeDays          float64 // time since LaunchTime, which resets on stop/start
+	AvailabilityZone string
+	Platform         string // EC2 platform details
+	Engine           string // database engine of an RDS instance
+	Tenancy          string
+	Architecture     string
+	VpcID            string
+	SubnetID         string
+	Tags             map[string]string
+	Cost             float64            // total of the primary (first) metric
+	CostUnknown      bool               // costs could not be fetched; cost fields are zero
+	Costs            map[string]float64 // total per requested metric
+	Series           []CostPoint
+	Breakdown        map[string]float64 // primary metric by usage category, with -breakdown
+	Volumes          []string           // attached EBS volume IDs
+	ElasticIPs       []string           // associated Elastic IP allocation IDs, with -tco
+	AttachedCost     float64            // primary metric of the attached volumes and Elastic IPs, with -tco
+	TotalCost        float64            // Cost plus AttachedCost, with -tco
+	RunningHours     float64            // billed instance-hours in the window, with -price-catalog or -unit-costs
+	EffectiveRate    float64            // instance-hour cost per running hour, with -price-catalog or -unit-costs
+	OnDemandRate     float64            // public on-demand hourly rate, with -price-catalog
+	DiscountCoverage float64            // percent saved against OnDemandRate, with -price-catalog
+	VCPUs            int32              // default vCPUs of the instance type, with -unit-costs
+	MemoryGiB        float64            // memory of the instance type, with -unit-costs
+	CostPerVCPUHour  float64            // EffectiveRate per vCPU, with -unit-costs
+	CostPerGiBHour   float64            // EffectiveRate per GiB of memory, with -unit-costs
+
+	networkInterfaces []string
+	publicIPs         []string
+	costIDs           []string // other IDs Cost Explorer may report the resource under
+	service           string   // Cost Explorer service billing the resource
+}
+
+// Report layouts: one row per instance, one row per instance and period, or
+// one row per instance with a column per period
+const (
+	layoutSummary = "summary"
+	layoutLong    = "long"
+	layoutPivot   = "pivot"
+)
+
+type options struct {
+	workers     int
+	ec2Rate     float64
+	ceRate      float64
+	lambdaRate  float64
+	rdsRate     float64
+	s3Rate      float64
+	elbRate     float64
+	maxAttempts int
+	maxBackoff  time.Duration
+	format      string
+	out         string
+	start       string
+	end         string
+	granularity string
+	layout      string
+	metrics     string
+	breakdown   bool
+	tco         bool
+	prices      string
+	priceFiles  string
+	org         bool
+	roleName    string
+	cachePath   string
+	noCache     bool
+	refresh     bool
+	cacheTTL    time.Duration
+	ceBudget    float64
+	yes         bool
+	tagColumns  string
+	missingTag  string
+	source      string
+	curDir      string
+	focus       bool
+	reconcile   bool
+	unitCosts   bool
+	familyOut   string
+	resources   string
+	maxFailures float64
 }
 
 func main() {
-	err := fetchInstancesAndCosts()
+	// Subcommands come before any flag
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		if err := runDiff(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var opts options
+	flag.IntVar(&opts.workers, "workers", 8, "number of regions processed concurrently")
+	flag.Float64Var(&opts.ec2Rate, "ec2-rps", 10, "maximum EC2 requests per second in each region")
+	flag.Float64Var(&opts.ceRate, "ce-rps", 2, "maximum Cost Explorer requests per second")
+	flag.Float64Var(&opts.lambdaRate, "lambda-rps", 10, "maximum Lambda requests per second in each region, with -resources lambda")
+	flag.Float64Var(&opts.rdsRate, "rds-rps", 10, "maximum RDS requests per second in each region, with -resources rds")
+	flag.Float64Var(&opts.s3Rate, "s3-rps", 20, "maximum S3 requests per second in each region, with -resources s3")
+	flag.Float64Var(&opts.elbRate, "elb-rps", 10, "maximum Elastic Load Balancing requests per second in each region, with -resources elb")
+	flag.IntVar(&opts.maxAttempts, "max-attempts", 10, "maximum attempts for a throttled AWS request")
+	flag.DurationVar(&opts.maxBackoff, "max-backoff", 20*time.Second, "maximum delay between attempts of a throttled AWS request")
+	flag.Float64Var(&opts.maxFailures, "max-failure-rate", 0, "exit with status 2 when more than this percentage of AWS operations failed")
+	flag.StringVar(&opts.format, "format", "csv", "output format: csv, json, ndjson, parquet or xlsx")
+	flag.StringVar(&opts.out, "out", "", "output file, or - for stdout (default ec2_instances_costs.<format>)")
+	flag.StringVar(&opts.start, "start", "", "start date YYYY-MM-DD (default 14 days before -end; with -source ce, no earlier than 14 days ago)")
+	flag.StringVar(&opts.end, "end", "", "exclusive end date YYYY-MM-DD (default today)")
+	flag.StringVar(&opts.granularity, "granularity", "DAILY", "time series granularity: DAILY, WEEKLY or MONTHLY")
+	flag.StringVar(&opts.layout, "layout", layoutSummary, "report layout: summary, long (row per period) or pivot (column per period)")
+	flag.StringVar(&opts.metrics, "metrics", "UnblendedCost", "comma-separated cost metrics: "+strings.Join(costMetrics, ", "))
+	flag.BoolVar(&opts.breakdown, "breakdown", false, "split each instance's cost into compute, storage, data transfer and other (including attached resources with -tco)")
+	flag.BoolVar(&opts.tco, "tco", false, "add the cost of attached EBS volumes and Elastic IPs as a total cost of ownership; with -resources ebs only unattached volumes get rows of their own")
+	flag.StringVar(&opts.prices, "price-catalog", "", "compare effective rates to the on-demand rates in this cached price catalog")
+	flag.StringVar(&opts.priceFiles, "price-files", "", "comma-separated Pricing API bulk offer files or directories to (re)build -price-catalog from")
+	flag.BoolVar(&opts.org, "org", false, "collect every active account of the AWS Organization")
+	flag.StringVar(&opts.roleName, "role-name", "OrganizationAccountAccessRole", "role assumed in each member account with -org")
+	flag.StringVar(&opts.cachePath, "cache", "ce_cache.db", "file caching Cost Explorer responses")
+	flag.BoolVar(&opts.noCache, "no-cache", false, "neither read nor write the Cost Explorer cache")
+	flag.BoolVar(&opts.refresh, "refresh", false, "ignore cached Cost Explorer responses and store fresh ones")
+	flag.DurationVar(&opts.cacheTTL, "cache-ttl", 6*time.Hour, "how long responses covering the current month, or a month that ended less than 5 days ago, stay cached")
+	flag.Float64Var(&opts.ceBudget, "ce-budget", 1.00, "maximum estimated Cost Explorer API charge in USD before asking for -yes")
+	flag.BoolVar(&opts.yes, "yes", false, "proceed even if the estimated Cost Explorer charge exceeds -ce-budget")
+	flag.StringVar(&opts.tagColumns, "tag-columns", "", "comma-separated tag keys written as their own columns, with -format csv or xlsx only, e.g. Owner,Project,Environment")
+	flag.StringVar(&opts.missingTag, "tag-placeholder", "", "value written in a tag column when the instance lacks the tag")
+	flag.StringVar(&opts.source, "source", "ce", "cost data source: ce (Cost Explorer) or cur (Cost and Usage Report files in -cur-dir)")
+	flag.StringVar(&opts.curDir, "cur-dir", "", "directory of CUR 2.0 or legacy CUR Parquet or gzipped CSV files, with -source cur")
+	flag.BoolVar(&opts.focus, "focus", false, "write FOCUS 1.0 rows, one per instance and period, instead of the report (-format csv, json or ndjson)")
+	flag.BoolVar(&opts.reconcile, "reconcile", false, "list instances with EC2 spend but no live resource, and live instances without spend, instead of the report (-format csv, json or ndjson)")
+	flag.BoolVar(&opts.unitCosts, "unit-costs", false, "add vCPU and memory sizes and cost per vCPU-hour and per GiB-hour, and write a summary by instance family")
+	flag.StringVar(&opts.familyOut, "family-out", "ec2_instance_families.csv", "CSV file of the instance family summary with -unit-costs, or - for stdout")
+	flag.StringVar(&opts.resources, "resources", resourceEC2, "comma-separated resource kinds to report: "+strings.Join(resourceKinds, ", "))
+	validate := flag.String("validate-focus", "", "check a FOCUS file (.csv, .json or .ndjson) for required columns and value types, then exit")
+	flag.Parse()
+
+	if *validate != "" {
+		rows, problems, err := focus.Validate(*validate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, problem)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Printf("%s is a valid FOCUS file with %d rows\n", *validate, rows)
+		return
+	}
+
+	err := fetchInstancesAndCosts(opts)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if errors.Is(err, errTooManyFailures) {
+			os.Exit(2)
+		}
+		os.Exit(1)
 	}
 }
 
-func fetchInstancesAndCosts() error {
+func fetchInstancesAndCosts(opts options) error {
 	ctx := context.TODO()
 
+	window, err := newCostWindow(opts.start, opts.end, opts.granularity)
+	if err != nil {
+		return err
+	}
+	metrics, err := parseMetrics(opts.metrics)
+	if err != nil {
+		return err
+	}
+	if opts.focus && opts.reconcile {
+		return fmt.Errorf("-focus and -reconcile cannot be combined")
+	}
+	if opts.focus {
+		// FOCUS billed and effective costs are the unblended and amortized
+		// costs
+		for _, metric := range []string{"UnblendedCost", "AmortizedCost"} {
+			if !slices.Contains(metrics, metric) {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	// FOCUS rows and reconciliations are not instance rows, so the XLSX
+	// and Parquet sinks do not apply
+	if (opts.focus || opts.reconcile) && !slices.Contains(focus.Formats, opts.format) {
+		return fmt.Errorf("unsupported format %q, expected one of %s", opts.format, strings.Join(focus.Formats, ", "))
+	}
+	if err := checkTagColumns(opts); err != nil {
+		return err
+	}
+	switch opts.layout {
+	case layoutSummary, layoutLong, layoutPivot:
+	default:
+		return fmt.Errorf("unsupported layout %q", opts.layout)
+	}
+
+	switch opts.source {
+	case "ce":
+		if err := window.checkResourceLookback(time.Now().UTC().Truncate(24 * time.Hour)); err != nil {
+			return err
+		}
+	case "cur":
+		if opts.curDir == "" {
+			return fmt.Errorf("-source cur requires -cur-dir")
+		}
+	default:
+		return fmt.Errorf("unsupported source %q", opts.source)
+	}
+
+	var catalog *priceCatalog
+	if opts.prices != "" {
+		catalog, err = openPriceCatalog(opts.prices, splitList(opts.priceFiles))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Throttle each API with its own token bucket and back off adaptively,
+	// with exponential delays, when AWS still reports throttling. Retries
+	// are not capped by the SDK's retry quota, which a long throttled run
+	// would exhaust.
+	limiter := newAPIRateLimiter(map[string]float64{
+		ec2.ServiceID:                    opts.ec2Rate,
+		costexplorer.ServiceID:           opts.ceRate,
+		lambda.ServiceID:                 opts.lambdaRate,
+		rds.ServiceID:                    opts.rdsRate,
+		s3.ServiceID:                     opts.s3Rate,
+		elasticloadbalancing.ServiceID:   opts.elbRate,
+		elasticloadbalancingv2.ServiceID: opts.elbRate,
+	})
+
 	// Load the default AWS config
-	cfg, err := config.LoadDefaultConfig(ctx)
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRetryer(func() aws.Retryer {
+			return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+				o.StandardOptions = append(o.StandardOptions, func(so *retry.StandardOptions) {
+					so.MaxAttempts = opts.maxAttempts
+					so.MaxBackoff = opts.maxBackoff
+					so.RateLimiter = ratelimit.None
+				})
+			})
+		}),
+		config.WithAPIOptions([]func(*middleware.Stack) error{limiter.addMiddleware}),
+	)
+	if err != nil {
+		return fmt.Errorf("unable to load SDK config, %w", err)
+	}
+
+	// Decide which accounts to collect
+	caller, err := callerAccount(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	accounts := []accountInfo{caller}
+	if opts.org {
+		accounts, err = listOrganizationAccounts(ctx, cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	c := &collection{
+		opts:     opts,
+		window:   window,
+		metrics:  metrics,
+		periods:  window.periods(),
+		catalog:  catalog,
+		failures: &failureLog{},
+	}
+	if opts.unitCosts {
+		c.types = newInstanceTypeCache()
+	}
+	c.collectors, err = newCollectors(opts.resources, c.types, opts.tco, c.failures)
+	if err != nil {
+		return err
+	}
+
+	// Closed months never change, so their Cost Explorer responses are
+	// served from disk on later runs
+	if !opts.noCache && opts.source != "cur" {
+		c.cache, err = openResponseCache(opts.cachePath, opts.refresh, opts.cacheTTL)
+		if err != nil {
+			return err
+		}
+		defer c.cache.Close()
+	}
+
+	// Take the inventory of every account first. EC2 describe calls are
+	// free and tell how much Cost Explorer data the run will page through.
+	// A failing account is recorded and skipped rather than aborting the
+	// run, unless it is the only account.
+	var runs []*accountRun
+	for _, account := range accounts {
+		run := &accountRun{account: account, cfg: cfg}
+		if account.ID != caller.ID {
+			run.cfg = assumeRoleConfig(cfg, account.ID, opts.roleName)
+		}
+
+		c.failures.attempt()
+		run.instances, err = c.describeAccount(ctx, run.cfg, account)
+		if err != nil {
+			if !opts.org {
+				return err
+			}
+			c.failures.record(account, "", "listing regions", err)
+			continue
+		}
+		run.inventoryGaps = c.failures.failedRegions(account.ID, collectOperation(resourceEC2))
+		runs = append(runs, run)
+	}
+
+	// CUR files are read once and answer the cost lookups of every account
+	// without calling Cost Explorer. Only the line items of the window, the
+	// collected services and the inventoried accounts are kept.
+	if opts.source == "cur" {
+		c.cur, err = curdata.Load(opts.curDir, c.curFilter(runs))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Cost Explorer bills every request, so refuse to start a run whose
+	// estimated charge is above the budget
+	if c.cur == nil {
+		estimate := c.estimateCalls(runs)
+		fmt.Fprintf(os.Stderr, "Estimated Cost Explorer requests: %s\n", estimate)
+		if estimate.charge() > opts.ceBudget && !opts.yes {
+			return fmt.Errorf("estimated Cost Explorer charge of up to $%.2f exceeds the -ce-budget of $%.2f; pass -yes to proceed",
+				estimate.charge(), opts.ceBudget)
+		}
+	}
+
+	// Join every account's inventory with its resource-level costs. An
+	// account whose costs cannot be fetched is still reported, with
+	// unknown costs.
+	for _, run := range runs {
+		c.failures.attempt()
+		if err := c.addAccountCosts(ctx, run); err != nil {
+			c.failures.record(run.account, "", "fetching costs", err)
+			c.addUnknownCosts(run)
+		}
+	}
+
+	// Prepare the output
+	name := "ec2_instances_costs"
+	if opts.reconcile {
+		name = "ec2_instances_reconcile"
+	}
+	out, outName, err := openOutput(opts.format, opts.out, name)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var summary string
+	switch {
+	case opts.focus:
+		err = c.writeFocusRows(out, runs, billingAccount(caller, accounts))
+	case opts.reconcile:
+		summary, err = c.writeReconciliation(out, runs)
+	default:
+		err = c.writeReport(out, runs)
+	}
+	if err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("unable to write report: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Instance data and costs have been written to %s\n", outName)
+	if opts.unitCosts {
+		familyName, err := c.writeFamilySummary(runs)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Instance family summary has been written to %s\n", familyName)
+	}
+	if summary != "" {
+		fmt.Fprintln(os.Stderr, summary)
+	}
+	if c.cur == nil {
+		calls := c.calls.Load()
+		fmt.Fprintf(os.Stderr, "Cost Explorer requests made: %d ($%.2f)\n", calls, float64(calls)*ceRequestPrice)
+	}
+	if len(runs) < len(accounts) {
+		fmt.Fprintf(os.Stderr, "Collected %d of %d accounts\n", len(runs), len(accounts))
+	}
+	c.failures.writeSummary(os.Stderr)
+	if rate := c.failures.rate(); rate > opts.maxFailures {
+		return fmt.Errorf("%w: %.1f%% failed, above the -max-failure-rate of %.1f%%", errTooManyFailures, rate, opts.maxFailures)
+	}
+	return nil
+}
+
+// writeReport writes the rows of the report layout in the -format.
+func (c *collection) writeReport(out io.Writer, runs []*accountRun) error {
+	report, err := newSink(c.opts.format, out, reportColumns(c.opts, c.periods, c.metrics))
+	if err != nil {
+		return err
+	}
+
+	for _, run := range runs {
+		for _, instance := range run.instances {
+			for _, row := range reportRows(instance, c.opts.layout) {
+				if err := report.Write(row); err != nil {
+					return fmt.Errorf("unable to write report row: %v", err)
+				}
+			}
+		}
+	}
+	if err := report.Close(); err != nil {
+		return fmt.Errorf("unable to write report: %v", err)
+	}
+	return nil
+}
+
+// writeFocusRows writes one FOCUS row per instance and period. Accounts
+// with unknown costs have no charges to report.
+func (c *collection) writeFocusRows(out io.Writer, runs []*accountRun, billing accountInfo) error {
+	var rows []focus.Row
+	for _, run := range runs {
+		if run.costsUnknown {
+			continue
+		}
+		for _, instance := range run.instances {
+			rows = append(rows, instanceFocusRows(instance, c.window, billing)...)
+		}
+	}
+	if err := focus.Write(c.opts.format, out, rows); err != nil {
+		return fmt.Errorf("unable to write FOCUS rows: %v", err)
+	}
+	return nil
+}
+
+// writeReconciliation writes the instances with spend but no live resource
+// and the live instances without spend, and returns a summary of both.
+func (c *collection) writeReconciliation(out io.Writer, runs []*accountRun) (string, error) {
+	var entries []ReconcileEntry
+	for _, run := range runs {
+		entries = append(entries, reconcileAccount(run, c.metrics[0])...)
+	}
+	if err := writeReconcile(c.opts.format, out, entries); err != nil {
+		return "", fmt.Errorf("unable to write reconciliation: %v", err)
+	}
+	return reconcileSummary(entries), nil
+}
+
+// writeFamilySummary writes the unit costs of every instance family as CSV
+// to -family-out and returns where it went.
+func (c *collection) writeFamilySummary(runs []*accountRun) (string, error) {
+	out, name, err := openOutput("csv", c.opts.familyOut, "ec2_instance_families")
 	if err != nil {
-		return fmt.Errorf("unable to load SDK config, %v", err)
+		return "", err
+	}
+	defer out.Close()
+
+	if err := writeFamilyCosts(out, familyCosts(runs)); err != nil {
+		return "", fmt.Errorf("unable to write instance family summary: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("unable to write instance family summary: %v", err)
+	}
+	return name, nil
+}
+
+// billingAccount is the account that pays for the collected ones: the
+// caller, which is the management account in -org mode.
+func billingAccount(caller accountInfo, accounts []accountInfo) accountInfo {
+	for _, account := range accounts {
+		if account.ID == caller.ID {
+			return account
+		}
 	}
+	return caller
+}
+
+// collection holds the settings shared by the collection of every account.
+type collection struct {
+	opts       options
+	window     costWindow
+	metrics    []string
+	periods    []string
+	catalog    *priceCatalog
+	cache      *responseCache
+	cur        *curdata.Data      // with -source cur
+	types      *instanceTypeCache // with -unit-costs
+	collectors []resourceCollector
+	failures   *failureLog
+	calls      atomic.Int64 // Cost Explorer requests made
+}
+
+// accountRun is the state of one account through the collection.
+type accountRun struct {
+	account   accountInfo
+	cfg       aws.Config
+	instances []InstanceData
+	costs     map[string]*resourceCost // resource-level costs, by resource ID
+
+	costsUnknown  bool     // the costs could not be fetched
+	inventoryGaps []string // regions whose instances could not be listed
+}
 
+// describeAccount lists the resources of one account: those of regional
+// collectors in every region, then those of the others.
+func (c *collection) describeAccount(ctx context.Context, cfg aws.Config, account accountInfo) ([]InstanceData, error) {
 	// Get all regions
 	ec2Client := ec2.NewFromConfig(cfg)
 	regions, err := getAllRegions(ctx, ec2Client)
 	if err != nil {
-		return fmt.Errorf("unable to fetch regions, %v", err)
+		return nil, fmt.Errorf("unable to fetch regions, %w", err)
+	}
+
+	var regional, global []resourceCollector
+	for _, collector := range c.collectors {
+		if collector.Regional() {
+			regional = append(regional, collector)
+		} else {
+			global = append(global, collector)
+		}
+	}
+
+	// Describe all regions concurrently; each worker fills its own slot so
+	// rows are written in region order
+	results := make([][]InstanceData, len(regions)+1)
+	forEach(len(regions), c.opts.workers, func(i int) {
+		regionalCfg := cfg.Copy()
+		regionalCfg.Region = regions[i]
+		for _, collector := range regional {
+			results[i] = append(results[i], c.collect(ctx, regionalCfg, account, collector)...)
+		}
+	})
+	for _, collector := range global {
+		results[len(regions)] = append(results[len(regions)], c.collect(ctx, cfg, account, collector)...)
 	}
 
-	// Prepare CSV file
-	file, err := os.Create("ec2_instances_costs.csv")
+	var instances []InstanceData
+	for _, regional := range results {
+		instances = append(instances, regional...)
+	}
+	return instances, nil
+}
+
+// collectOperation names the failed operation of a collector.
+func collectOperation(kind string) string {
+	return "fetching " + kind + " resources"
+}
+
+// collect runs one collector, recording and skipping it on failure.
+func (c *collection) collect(ctx context.Context, cfg aws.Config, account accountInfo, collector resourceCollector) []InstanceData {
+	c.failures.attempt()
+	resources, err := collector.Collect(ctx, cfg, account)
 	if err != nil {
-		return fmt.Errorf("unable to create CSV file: %v", err)
+		region := cfg.Region
+		if !collector.Regional() {
+			region = ""
+		}
+		c.failures.record(account, region, collectOperation(collector.Kind()), err)
+		return nil
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+	now := time.Now().UTC()
+	for i := range resources {
+		resources[i].AccountID = account.ID
+		resources[i].AccountName = account.Name
+		resources[i].ResourceType = collector.Kind()
+		resources[i].service = collector.Service()
+		if resources[i].AgeDays == 0 && !resources[i].LaunchTime.IsZero() {
+			resources[i].AgeDays = now.Sub(resources[i].LaunchTime).Hours() / 24
+		}
+	}
+	return resources
+}
 
-	// Write CSV header
-	writer.Write([]string{"InstanceID", "Name", "Region", "InstanceType", "Tags", "Cost"})
+// costQuery builds the resource-level lookup the options ask for.
+func (c *collection) costQuery() costQuery {
+	query := costQuery{
+		Window:    c.window,
+		Metrics:   c.metrics,
+		Breakdown: c.opts.breakdown,
+		Usage:     c.catalog != nil || c.opts.unitCosts,
+	}
+	services := make([]string, 0, len(c.collectors)+2)
+	for _, collector := range c.collectors {
+		services = append(services, collector.Service())
+	}
+	if c.opts.breakdown || c.opts.tco {
+		services = append(services, ec2OtherService)
+	}
+	if c.opts.tco {
+		services = append(services, vpcService)
+	}
+	for _, service := range services {
+		if !slices.Contains(query.Services, service) {
+			query.Services = append(query.Services, service)
+		}
+	}
+	return query
+}
 
-	// Process instances for each region
-	for _, region := range regions {
-		cfg.Region = region
-		ec2Client = ec2.NewFromConfig(cfg)
-		ceClient := costexplorer.NewFromConfig(cfg)
+// curFilter selects the CUR line items the cost query of the runs can use.
+func (c *collection) curFilter(runs []*accountRun) curdata.Filter {
+	filter := curdata.Filter{
+		Periods:  []curdata.Period{{Start: c.window.Start, End: c.window.End}},
+		Services: c.costQuery().Services,
+	}
+	for _, run := range runs {
+		filter.Accounts = append(filter.Accounts, run.account.ID)
+	}
+	return filter
+}
 
-		instances, err := describeInstancesInRegion(ctx, ec2Client, region)
-		if err != nil {
-			fmt.Printf("Error fetching instances for region %s: %v\n", region, err)
-			continue
+// costExplorer returns the Cost Explorer client of an account: requests are
+// counted, then served from the response cache when possible. With -source
+// cur the account's CUR line items answer instead.
+func (c *collection) costExplorer(cfg aws.Config, account accountInfo) costExplorerAPI {
+	if c.cur != nil {
+		return &curCostExplorer{data: c.cur, account: account.ID}
+	}
+	var ceClient costExplorerAPI = &countingCostExplorer{api: costexplorer.NewFromConfig(cfg), calls: &c.calls}
+	if c.cache != nil {
+		ceClient = &cachedCostExplorer{api: ceClient, cache: c.cache, scope: account.ID}
+	}
+	return ceClient
+}
+
+// estimateCalls estimates the billed Cost Explorer requests of the runs
+// from their inventory. Cached pages are free, and a full cached page of
+// any account tells the page size.
+func (c *collection) estimateCalls(runs []*accountRun) callEstimate {
+	query := c.costQuery()
+	lookups := make([]cachedLookup, len(runs))
+	pageSize := 0
+	for i, run := range runs {
+		if cached, ok := c.costExplorer(run.cfg, run.account).(*cachedCostExplorer); ok {
+			lookups[i] = cached.cachedPages(query.resourcesInput())
 		}
+		pageSize = max(pageSize, lookups[i].pageSize)
+	}
 
-		for _, instance := range instances {
-			cost, err := getInstanceCost(ctx, ceClient, instance.InstanceID)
-			if err != nil {
-				fmt.Printf("Error fetching cost for instance %s in region %s: %v\n", instance.InstanceID, region, err)
-				cost = 0.0
+	var estimate callEstimate
+	for i, run := range runs {
+		resources := len(run.instances)
+		if c.opts.tco {
+			for _, instance := range run.instances {
+				resources += len(instance.Volumes) + len(instance.ElasticIPs)
 			}
-
-			// Write to CSV
-			writer.Write([]string{
-				instance.InstanceID,
-				instance.Name,
-				instance.Region,
-				instance.InstanceType,
-				formatTags(instance.Tags),
-				fmt.Sprintf("%.2f", cost),
-			})
 		}
+		estimate = estimate.add(estimateResourceCalls(query, resources, lookups[i], pageSize))
+	}
+	return estimate
+}
+
+// addAccountCosts fetches the cost of every instance of an account in a
+// single paginated lookup and joins it with the inventory.
+func (c *collection) addAccountCosts(ctx context.Context, run *accountRun) error {
+	costs, err := getInstanceCosts(ctx, c.costExplorer(run.cfg, run.account), c.costQuery())
+	if err != nil {
+		return fmt.Errorf("unable to fetch instance costs, %w", err)
 	}
 
-	fmt.Println("Instance data and costs have been written to ec2_instances_costs.csv")
+	for i := range run.instances {
+		c.addCosts(&run.instances[i], costs)
+	}
+	run.costs = costs
 	return nil
 }
 
+// addCosts joins a resource with its resource-level costs. Rates, attached
+// resources and unit costs only apply to EC2 instances.
+func (c *collection) addCosts(instance *InstanceData, costs map[string]*resourceCost) {
+	cost := resourceCostOf(costs, *instance)
+
+	instance.Series = fillSeries(cost.Series, c.periods, c.metrics)
+	if c.opts.breakdown {
+		instance.Breakdown = fillBreakdown(cost.Breakdown)
+	}
+	instance.Costs = totalCosts(instance.Series)
+	instance.Cost = instance.Costs[c.metrics[0]]
+	if instance.ResourceType != resourceEC2 {
+		return
+	}
+	if c.opts.tco {
+		addAttachedCosts(instance, costs, c.metrics[0])
+	}
+	if c.catalog != nil || c.opts.unitCosts {
+		addEffectiveRate(instance, cost)
+	}
+	if c.catalog != nil {
+		addRateComparison(instance, c.catalog)
+	}
+	if c.opts.unitCosts {
+		addUnitCosts(instance)
+	}
+}
+
+// addUnknownCosts lays out the resources of an account whose costs could
+// not be fetched: every period is present with zero cost, and CostUnknown
+// tells them apart from resources without spend.
+func (c *collection) addUnknownCosts(run *accountRun) {
+	run.costsUnknown = true
+	for i := range run.instances {
+		c.addCosts(&run.instances[i], nil)
+		run.instances[i].CostUnknown = true
+	}
+}
+
 func getAllRegions(ctx context.Context, client *ec2.Client) ([]string, error) {
 	// Use STS GetCallerIdentity to list regions
 	input := &ec2.DescribeRegionsInput{}
 
 	output, err := client.DescribeRegions(ctx, input)
 	if err != nil {
-		return nil, fmt.Errorf("unable to describe regions: %v", err)
+		return nil, fmt.Errorf("unable to describe regions: %w", err)
 	}
 
 	// Collect all region names
@@ -115,13 +782,24 @@ func getAllRegions(ctx context.Context, client *ec2.Client) ([]string, error) {
 
 func describeInstancesInRegion(ctx context.Context, ec2Client *ec2.Client, region string) ([]InstanceData, error) {
 	input := &ec2.DescribeInstancesInput{}
-	resp, err := ec2Client.DescribeInstances(ctx, input)
-	if err != nil {
-		return nil, fmt.Errorf("unable to describe instances, %v", err)
+	paginator := ec2.NewDescribeInstancesPaginator(ec2Client, input)
+
+	var instances []InstanceData
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe instances, %w", err)
+		}
+
+		instances = append(instances, parseReservations(resp.Reservations, region)...)
 	}
+	return instances, nil
+}
 
+func parseReservations(reservations []ec2types.Reservation, region string) []InstanceData {
+	now := time.Now().UTC()
 	var instances []InstanceData
-	for _, reservation := range resp.Reservations {
+	for _, reservation := range reservations {
 		for _, instance := range reservation.Instances {
 			tags := make(map[string]string)
 			var name string
@@ -132,58 +810,122 @@ func describeInstancesInRegion(ctx context.Context, ec2Client *ec2.Client, regio
 				}
 			}
 
-			instances = append(instances, InstanceData{
-				InstanceID:   aws.ToString(instance.InstanceId),
-				Name:         name,
-				Region:       region,
-				InstanceType: string(instance.InstanceType),
-				Tags:         tags,
-			})
+			var volumes []string
+			for _, mapping := range instance.BlockDeviceMappings {
+				if mapping.Ebs != nil && mapping.Ebs.VolumeId != nil {
+					volumes = append(volumes, aws.ToString(mapping.Ebs.VolumeId))
+				}
+			}
+			var networkInterfaces []string
+			for _, eni := range instance.NetworkInterfaces {
+				networkInterfaces = append(networkInterfaces, aws.ToString(eni.NetworkInterfaceId))
+			}
+
+			lifecycle := string(instance.InstanceLifecycle)
+			if lifecycle == "" {
+				lifecycle = "on-demand"
+			}
+
+			data := InstanceData{
+				InstanceID:        aws.ToString(instance.InstanceId),
+				Name:              name,
+				Region:            region,
+				InstanceType:      string(instance.InstanceType),
+				Lifecycle:         lifecycle,
+				LaunchTime:        aws.ToTime(instance.LaunchTime),
+				Platform:          aws.ToString(instance.PlatformDetails),
+				Architecture:      string(instance.Architecture),
+				VpcID:             aws.ToString(instance.VpcId),
+				SubnetID:          aws.ToString(instance.SubnetId),
+				Tags:              tags,
+				Volumes:           volumes,
+				networkInterfaces: networkInterfaces,
+			}
+			if instance.State != nil {
+				data.State = string(instance.State.Name)
+			}
+			if instance.Placement != nil {
+				data.AvailabilityZone = aws.ToString(instance.Placement.AvailabilityZone)
+				data.Tenancy = string(instance.Placement.Tenancy)
+			}
+			if !data.LaunchTime.IsZero() {
+				data.AgeDays = now.Sub(data.LaunchTime).Hours() / 24
+			}
+			instances = append(instances, data)
 		}
 	}
-	return instances, nil
+	return instances
 }
 
-func getInstanceCost(ctx context.Context, ceClient *costexplorer.Client, instanceID string) (float64, error) {
-	endDate := time.Now()
-	startDate := endDate.AddDate(0, 0, -30)
+// fillSeries lays a sparse time series out over every period of the window,
+// with zero cost for periods Cost Explorer returned nothing for.
+func fillSeries(points []CostPoint, periods []string, metrics []string) []CostPoint {
+	costs := make(map[string]map[string]float64, len(points))
+	for _, point := range points {
+		costs[point.PeriodStart] = point.Costs
+	}
 
-	ceInput := &costexplorer.GetCostAndUsageInput{
-		TimePeriod: &types.DateInterval{
-			Start: aws.String(startDate.Format("2006-01-02")),
-			End:   aws.String(endDate.Format("2006-01-02")),
-		},
-		Granularity: types.GranularityMonthly,
-		Metrics:     []string{"UnblendedCost"},
-		Filter: &types.Expression{
-			Tags: &types.TagValues{
-				Key:    aws.String("RESOURCE_ID"),
-				Values: []string{instanceID},
-			},
-		},
+	series := make([]CostPoint, len(periods))
+	for i, period := range periods {
+		amounts := make(map[string]float64, len(metrics))
+		for _, metric := range metrics {
+			amounts[metric] = costs[period][metric]
+		}
+		series[i] = CostPoint{PeriodStart: period, Costs: amounts}
 	}
+	return series
+}
 
-	ceResp, err := ceClient.GetCostAndUsage(ctx, ceInput)
-	if err != nil {
-		return 0.0, fmt.Errorf("error fetching cost for instance %s: %v", instanceID, err)
+// fillBreakdown reports every usage category, with zero for categories the
+// instance had no cost in.
+func fillBreakdown(breakdown map[string]float64) map[string]float64 {
+	filled := make(map[string]float64, len(usageCategories))
+	for _, category := range usageCategories {
+		filled[category] = breakdown[category]
 	}
+	return filled
+}
 
-	// Parse cost
-	var cost float64
-	if len(ceResp.ResultsByTime) > 0 {
-		amount := ceResp.ResultsByTime[0].Total["UnblendedCost"].Amount
-		cost, err = strconv.ParseFloat(aws.ToString(amount), 64)
-		if err != nil {
-			return 0.0, fmt.Errorf("error parsing cost for instance %s: %v", instanceID, err)
+// reportRows expands an instance into the rows of the report layout. In the
+// long layout each row carries a single period of the series.
+func reportRows(instance InstanceData, layout string) []InstanceData {
+	if layout != layoutLong {
+		return []InstanceData{instance}
+	}
+
+	rows := make([]InstanceData, len(instance.Series))
+	for i, point := range instance.Series {
+		rows[i] = instance
+		rows[i].Series = []CostPoint{point}
+	}
+	return rows
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
 		}
 	}
-	return cost, nil
+	return items
 }
 
-func formatTags(tags map[string]string) string {
-	var result string
+// formatTags encodes the tags not listed in exclude as a JSON object. Keys
+// are sorted, so the value is stable between runs.
+func formatTags(tags map[string]string, exclude []string) string {
+	remaining := make(map[string]string, len(tags))
 	for k, v := range tags {
-		result += fmt.Sprintf("%s=%s; ", k, v)
+		remaining[k] = v
+	}
+	for _, k := range exclude {
+		delete(remaining, k)
+	}
+
+	data, err := json.Marshal(remaining)
+	if err != nil {
+		return ""
 	}
-	return result
+	return string(data)
 }
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// priceCatalog holds public on-demand hourly rates in USD. It is built from
+// the AWS Pricing API bulk offer files for AmazonEC2 (one index.json per
+// region) and cached on disk, so rate comparisons work offline.
+type priceCatalog struct {
+	Generated time.Time          `json:"generated"`
+	Prices    map[string]float64 `json:"prices"` // keyed by priceKey
+}
+
+// priceKey identifies an on-demand rate by region, instance type, operating
+// system, pre-installed software and tenancy, as named by the Pricing API.
+func priceKey(region, instanceType, operatingSystem, software, tenancy string) string {
+	return strings.Join([]string{region, instanceType, operatingSystem, software, tenancy}, "|")
+}
+
+// offerFile is the subset of a bulk offer file the catalog needs.
+type offerFile struct {
+	Products map[string]struct {
+		ProductFamily string            `json:"productFamily"`
+		Attributes    map[string]string `json:"attributes"`
+	} `json:"products"`
+	Terms struct {
+		OnDemand map[string]map[string]struct {
+			PriceDimensions map[string]struct {
+				Unit         string            `json:"unit"`
+				PricePerUnit map[string]string `json:"pricePerUnit"`
+			} `json:"priceDimensions"`
+		} `json:"OnDemand"`
+	} `json:"terms"`
+}
+
+// buildPriceCatalog reads bulk offer files. Each path may be a file or a
+// directory, in which case every .json file in it is read.
+func buildPriceCatalog(paths []string) (*priceCatalog, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read price file: %v", err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	catalog := &priceCatalog{Generated: time.Now().UTC(), Prices: make(map[string]float64)}
+	for _, file := range files {
+		if err := catalog.addOfferFile(file); err != nil {
+			return nil, err
+		}
+	}
+	return catalog, nil
+}
+
+func (c *priceCatalog) addOfferFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open price file: %v", err)
+	}
+	defer file.Close()
+
+	var offer offerFile
+	if err := json.NewDecoder(file).Decode(&offer); err != nil {
+		return fmt.Errorf("unable to parse price file %s: %v", path, err)
+	}
+
+	for sku, product := range offer.Products {
+		attrs := product.Attributes
+		// Keep plain on-demand capacity; reservations, BYOL and unused
+		// capacity reservation SKUs have their own rates
+		if product.ProductFamily != "Compute Instance" ||
+			attrs["capacitystatus"] != "Used" ||
+			attrs["licenseModel"] == "Bring your own license" {
+			continue
+		}
+
+		for _, term := range offer.Terms.OnDemand[sku] {
+			for _, dimension := range term.PriceDimensions {
+				if dimension.Unit != "Hrs" {
+					continue
+				}
+				price, err := strconv.ParseFloat(dimension.PricePerUnit["USD"], 64)
+				if err != nil {
+					continue
+				}
+				key := priceKey(attrs["regionCode"], attrs["instanceType"], attrs["operatingSystem"], attrs["preInstalledSw"], attrs["tenancy"])
+				c.Prices[key] = price
+			}
+		}
+	}
+	return nil
+}
+
+func loadPriceCatalog(path string) (*priceCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read price catalog: %v", err)
+	}
+
+	var catalog priceCatalog
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("unable to parse price catalog %s: %v", path, err)
+	}
+	return &catalog, nil
+}
+
+func (c *priceCatalog) save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// openPriceCatalog builds the catalog from bulk offer files when any are
+// given and caches it at path; otherwise it loads the cached catalog.
+func openPriceCatalog(path string, offerFiles []string) (*priceCatalog, error) {
+	if len(offerFiles) == 0 {
+		return loadPriceCatalog(path)
+	}
+
+	catalog, err := buildPriceCatalog(offerFiles)
+	if err != nil {
+		return nil, err
+	}
+	if err := catalog.save(path); err != nil {
+		return nil, fmt.Errorf("unable to save price catalog: %v", err)
+	}
+	return catalog, nil
+}
+
+// onDemandRate returns the public hourly rate for an instance, matching its
+// EC2 platform details and tenancy to the Pricing API attributes.
+func (c *priceCatalog) onDemandRate(instance InstanceData) (float64, bool) {
+	operatingSystem, software, ok := pricingPlatform(instance.Platform)
+	if !ok {
+		return 0, false
+	}
+
+	tenancy := "Shared"
+	switch instance.Tenancy {
+	case "dedicated":
+		tenancy = "Dedicated"
+	case "host":
+		tenancy = "Host"
+	}
+
+	price, ok := c.Prices[priceKey(instance.Region, instance.InstanceType, operatingSystem, software, tenancy)]
+	return price, ok
+}
+
+// pricingPlatform maps EC2 PlatformDetails (e.g. "Windows with SQL Server
+// Standard") to the Pricing API operatingSystem and preInstalledSw values.
+func pricingPlatform(platform string) (string, string, bool) {
+	software := "NA"
+	for suffix, sw := range map[string]string{
+		" with SQL Server Standard":   "SQL Std",
+		" with SQL Server Web":        "SQL Web",
+		" with SQL Server Enterprise": "SQL Ent",
+	} {
+		if strings.HasSuffix(platform, suffix) {
+			platform = strings.TrimSuffix(platform, suffix)
+			software = sw
+		}
+	}
+
+	switch platform {
+	case "Linux/UNIX", "Linux":
+		return "Linux", software, true
+	case "Windows":
+		return "Windows", software, true
+	case "Red Hat Enterprise Linux":
+		return "RHEL", software, true
+	case "Red Hat Enterprise Linux with HA":
+		return "Red Hat Enterprise Linux with HA", software, true
+	case "SUSE Linux":
+		return "SUSE", software, true
+	case "Ubuntu Pro":
+		return "Ubuntu Pro", software, true
+	default:
+		// BYOL and unknown platforms have no public on-demand rate
+		return "", "", false
+	}
+}
+
+// addEffectiveRate sets the instance-hours of an instance and its effective
+// hourly rate: its instance-hour cost divided by instance-hours.
+func addEffectiveRate(instance *InstanceData, cost *resourceCost) {
+	instance.RunningHours = cost.InstanceHours
+	if cost.InstanceHours > 0 {
+		instance.EffectiveRate = cost.InstanceHoursCost / cost.InstanceHours
+	}
+}
+
+// addRateComparison compares the effective hourly rate of an instance to the
+// public on-demand rate. DiscountCoverage is the share of the on-demand
+// price saved through Savings Plans, reservations or spot.
+func addRateComparison(instance *InstanceData, catalog *priceCatalog) {
+	rate, ok := catalog.onDemandRate(*instance)
+	if !ok {
+		return
+	}
+	instance.OnDemandRate = rate
+	if instance.RunningHours > 0 && rate > 0 {
+		instance.DiscountCoverage = (1 - instance.EffectiveRate/rate) * 100
+	}
+}
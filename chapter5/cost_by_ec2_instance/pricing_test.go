@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildPriceCatalog(t *testing.T) {
+	catalog, err := buildPriceCatalog([]string{"testdata/prices"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unused capacity reservations, BYOL, non-hourly and non-compute SKUs
+	// are left out
+	if len(catalog.Prices) != 5 {
+		t.Errorf("catalog has %d prices, want 5: %v", len(catalog.Prices), catalog.Prices)
+	}
+
+	tests := []struct {
+		name     string
+		instance InstanceData
+		want     float64
+		wantOK   bool
+	}{
+		{"linux shared", InstanceData{Region: "us-east-1", InstanceType: "t3.micro", Platform: "Linux/UNIX", Tenancy: "default"}, 0.0104, true},
+		{"linux dedicated", InstanceData{Region: "us-east-1", InstanceType: "t3.micro", Platform: "Linux/UNIX", Tenancy: "dedicated"}, 0.0114, true},
+		{"other region", InstanceData{Region: "eu-west-1", InstanceType: "t3.micro", Platform: "Linux/UNIX", Tenancy: "default"}, 0.0114, true},
+		{"windows with sql server", InstanceData{Region: "us-east-1", InstanceType: "m5.large", Platform: "Windows with SQL Server Standard", Tenancy: "default"}, 0.467, true},
+		{"red hat", InstanceData{Region: "us-east-1", InstanceType: "m5.large", Platform: "Red Hat Enterprise Linux", Tenancy: "default"}, 0.156, true},
+		{"byol windows", InstanceData{Region: "us-east-1", InstanceType: "m5.large", Platform: "Windows", Tenancy: "default"}, 0, false},
+		{"host tenancy", InstanceData{Region: "us-east-1", InstanceType: "t3.micro", Platform: "Linux/UNIX", Tenancy: "host"}, 0, false},
+		{"non-hourly price", InstanceData{Region: "us-east-1", InstanceType: "c5.large", Platform: "Linux/UNIX", Tenancy: "default"}, 0, false},
+		{"unknown type", InstanceData{Region: "us-east-1", InstanceType: "x9.huge", Platform: "Linux/UNIX", Tenancy: "default"}, 0, false},
+		{"unknown platform", InstanceData{Region: "us-east-1", InstanceType: "t3.micro", Platform: "Windows BYOL", Tenancy: "default"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := catalog.onDemandRate(tt.instance)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("onDemandRate = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestBuildPriceCatalogMissingFile(t *testing.T) {
+	if _, err := buildPriceCatalog([]string{"testdata/prices/ap-south-1.json"}); err == nil {
+		t.Error("buildPriceCatalog of a missing file succeeded, want an error")
+	}
+}
+
+func TestOpenPriceCatalog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	built, err := openPriceCatalog(path, []string{"testdata/prices/us-east-1.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := openPriceCatalog(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cached.Prices) != len(built.Prices) || cached.Prices[priceKey("us-east-1", "t3.micro", "Linux", "NA", "Shared")] != 0.0104 {
+		t.Errorf("cached catalog = %v, want %v", cached.Prices, built.Prices)
+	}
+}
+
+func TestPricingPlatform(t *testing.T) {
+	tests := []struct {
+		platform     string
+		wantOS       string
+		wantSoftware string
+		wantOK       bool
+	}{
+		{"Linux/UNIX", "Linux", "NA", true},
+		{"Windows", "Windows", "NA", true},
+		{"Windows with SQL Server Web", "Windows", "SQL Web", true},
+		{"Windows with SQL Server Enterprise", "Windows", "SQL Ent", true},
+		{"Linux with SQL Server Standard", "Linux", "SQL Std", true},
+		{"Red Hat Enterprise Linux with HA", "Red Hat Enterprise Linux with HA", "NA", true},
+		{"SUSE Linux", "SUSE", "NA", true},
+		{"Ubuntu Pro", "Ubuntu Pro", "NA", true},
+		{"Windows BYOL", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		operatingSystem, software, ok := pricingPlatform(tt.platform)
+		if operatingSystem != tt.wantOS || software != tt.wantSoftware || ok != tt.wantOK {
+			t.Errorf("pricingPlatform(%q) = %q, %q, %v, want %q, %q, %v",
+				tt.platform, operatingSystem, software, ok, tt.wantOS, tt.wantSoftware, tt.wantOK)
+		}
+	}
+}
+
+func TestAddRateComparison(t *testing.T) {
+	catalog := &priceCatalog{Prices: map[string]float64{
+		priceKey("us-east-1", "m5.large", "Linux", "NA", "Shared"): 0.096,
+	}}
+
+	tests := []struct {
+		name         string
+		instance     InstanceData
+		cost         resourceCost
+		wantRate     float64
+		wantOnDemand float64
+		wantCoverage float64
+	}{
+		{"on demand", InstanceData{InstanceType: "m5.large"}, resourceCost{InstanceHours: 100, InstanceHoursCost: 9.6}, 0.096, 0.096, 0},
+		{"savings plan", InstanceData{InstanceType: "m5.large"}, resourceCost{InstanceHours: 100, InstanceHoursCost: 6.72}, 0.0672, 0.096, 30},
+		{"not running", InstanceData{InstanceType: "m5.large"}, resourceCost{}, 0, 0.096, 0},
+		{"missing price", InstanceData{InstanceType: "m6i.large"}, resourceCost{InstanceHours: 100, InstanceHoursCost: 9.6}, 0.096, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := tt.instance
+			instance.Region, instance.Platform = "us-east-1", "Linux/UNIX"
+			addEffectiveRate(&instance, &tt.cost)
+			addRateComparison(&instance, catalog)
+			if math.Abs(instance.EffectiveRate-tt.wantRate) > 1e-9 {
+				t.Errorf("EffectiveRate = %v, want %v", instance.EffectiveRate, tt.wantRate)
+			}
+			if instance.OnDemandRate != tt.wantOnDemand {
+				t.Errorf("OnDemandRate = %v, want %v", instance.OnDemandRate, tt.wantOnDemand)
+			}
+			if math.Abs(instance.DiscountCoverage-tt.wantCoverage) > 1e-9 {
+				t.Errorf("DiscountCoverage = %v, want %v", instance.DiscountCoverage, tt.wantCoverage)
+			}
+		})
+	}
+}
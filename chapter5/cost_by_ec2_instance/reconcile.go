@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Reconciliation statuses
+const (
+	statusCostWithoutResource = "cost-without-live-resource"
+	statusResourceWithoutCost = "live-resource-without-cost"
+	// Spend on an instance that may be live in a region whose inventory
+	// failed
+	statusInventoryUnavailable = "inventory-unavailable"
+)
+
+// ReconcileEntry is an instance on which Cost Explorer and the live
+// inventory disagree: spend on an instance DescribeInstances no longer
+// returns, typically terminated during the window, or a live instance with
+// no spend in the window.
+type ReconcileEntry struct {
+	Status      string
+	AccountID   string
+	AccountName string
+	ResourceID  string
+	Cost        float64 // primary metric over the window
+	FirstPeriod string  // first period with cost, for cost without a live resource
+	LastPeriod  string  // last period with cost, for cost without a live resource
+
+	// Inventory of a live resource
+	Name string
+	// Region of a live resource, or the regions whose inventory failed for
+	// inventory-unavailable entries
+	Region       string
+	InstanceType string
+	State        string
+	LaunchTime   string
+}
+
+// reconcileAccount diffs the instances with EC2 spend in the window against
+// the live inventory of one account. An account with unknown costs cannot be
+// reconciled, and spend in an account whose inventory failed in some region
+// is only reported as inventory-unavailable, as the instance may live there.
+// Other -resources kinds are not reconciled.
+func reconcileAccount(run *accountRun, metric string) []ReconcileEntry {
+	if run.costsUnknown {
+		return nil
+	}
+	live := make(map[string]bool, len(run.instances))
+	var entries []ReconcileEntry
+	for _, instance := range run.instances {
+		if instance.ResourceType != resourceEC2 {
+			continue
+		}
+		live[instance.InstanceID] = true
+		if instance.Cost != 0 {
+			continue
+		}
+		entries = append(entries, ReconcileEntry{
+			Status:       statusResourceWithoutCost,
+			AccountID:    run.account.ID,
+			AccountName:  run.account.Name,
+			ResourceID:   instance.InstanceID,
+			Name:         instance.Name,
+			Region:       instance.Region,
+			InstanceType: instance.InstanceType,
+			State:        instance.State,
+			LaunchTime:   formatCell(instance.LaunchTime),
+		})
+	}
+
+	// Resource-level results also hold volumes and Elastic IPs; only
+	// instances are reconciled
+	var orphans []ReconcileEntry
+	for resourceID, cost := range run.costs {
+		if !strings.HasPrefix(resourceID, "i-") || live[resourceID] {
+			continue
+		}
+		entry := ReconcileEntry{
+			Status:      statusCostWithoutResource,
+			AccountID:   run.account.ID,
+			AccountName: run.account.Name,
+			ResourceID:  resourceID,
+		}
+		if len(run.inventoryGaps) > 0 {
+			entry.Status = statusInventoryUnavailable
+			entry.Region = strings.Join(run.inventoryGaps, " ")
+		}
+		for _, point := range cost.Series {
+			if point.Costs[metric] == 0 {
+				continue
+			}
+			entry.Cost += point.Costs[metric]
+			if entry.FirstPeriod == "" {
+				entry.FirstPeriod = point.PeriodStart
+			}
+			entry.LastPeriod = point.PeriodStart
+		}
+		if entry.Cost != 0 {
+			orphans = append(orphans, entry)
+		}
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].Cost > orphans[j].Cost })
+	return append(orphans, entries...)
+}
+
+var reconcileHeader = []string{
+	"Status", "AccountID", "AccountName", "ResourceID", "Cost", "FirstPeriod", "LastPeriod",
+	"Name", "Region", "InstanceType", "State", "LaunchTime",
+}
+
+// writeReconcile writes the reconciliation as CSV, a JSON array or NDJSON.
+func writeReconcile(format string, w io.Writer, entries []ReconcileEntry) error {
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write(reconcileHeader); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			record := []string{
+				e.Status, e.AccountID, e.AccountName, e.ResourceID, formatCell(e.Cost), e.FirstPeriod, e.LastPeriod,
+				e.Name, e.Region, e.InstanceType, e.State, e.LaunchTime,
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case "json":
+		if entries == nil {
+			entries = []ReconcileEntry{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		for _, e := range entries {
+			if err := encoder.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported reconciliation format %q, expected csv, json or ndjson", format)
+	}
+}
+
+// reconcileSummary counts the entries and totals the spend of each status.
+func reconcileSummary(entries []ReconcileEntry) string {
+	var orphans, idle, unavailable int
+	var orphanCost, unavailableCost float64
+	for _, e := range entries {
+		switch e.Status {
+		case statusCostWithoutResource:
+			orphans++
+			orphanCost += e.Cost
+		case statusResourceWithoutCost:
+			idle++
+		case statusInventoryUnavailable:
+			unavailable++
+			unavailableCost += e.Cost
+		}
+	}
+	summary := fmt.Sprintf("Cost without a live resource: %d instances, $%.2f\nLive resources without cost: %d instances",
+		orphans, orphanCost, idle)
+	if unavailable > 0 {
+		summary += fmt.Sprintf("\nCost in accounts with failed inventory: %d instances, $%.2f", unavailable, unavailableCost)
+	}
+	return summary
+}
@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+func TestReconcileAccount(t *testing.T) {
+	costs := map[string]*resourceCost{
+		"i-live":       {Series: []CostPoint{{PeriodStart: "2024-05-01", Costs: map[string]float64{"UnblendedCost": 10}}}},
+		"i-terminated": {Series: []CostPoint{{PeriodStart: "2024-05-01", Costs: map[string]float64{"UnblendedCost": 4}}, {PeriodStart: "2024-05-02", Costs: map[string]float64{"UnblendedCost": 2}}}},
+		"vol-1":        {Series: []CostPoint{{PeriodStart: "2024-05-01", Costs: map[string]float64{"UnblendedCost": 1}}}},
+	}
+	instances := []InstanceData{
+		{InstanceID: "i-live", ResourceType: resourceEC2, Cost: 10},
+		{InstanceID: "i-idle", ResourceType: resourceEC2, Region: "us-east-1"},
+	}
+
+	tests := []struct {
+		name string
+		run  accountRun
+		want map[string]string // resource ID to status
+	}{
+		{
+			name: "complete inventory",
+			run:  accountRun{instances: instances, costs: costs},
+			want: map[string]string{"i-terminated": statusCostWithoutResource, "i-idle": statusResourceWithoutCost},
+		},
+		{
+			name: "failed region",
+			run:  accountRun{instances: instances, costs: costs, inventoryGaps: []string{"eu-west-1"}},
+			want: map[string]string{"i-terminated": statusInventoryUnavailable, "i-idle": statusResourceWithoutCost},
+		},
+		{
+			name: "unknown costs",
+			run:  accountRun{instances: instances, costsUnknown: true},
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := reconcileAccount(&tt.run, "UnblendedCost")
+			got := make(map[string]string, len(entries))
+			for _, e := range entries {
+				got[e.ResourceID] = e.Status
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for id, status := range tt.want {
+				if got[id] != status {
+					t.Errorf("%s: status %q, want %q", id, got[id], status)
+				}
+			}
+			for _, e := range entries {
+				if e.ResourceID == "i-terminated" && (e.Cost != 6 || e.FirstPeriod != "2024-05-01" || e.LastPeriod != "2024-05-02") {
+					t.Errorf("i-terminated: got cost %v from %s to %s", e.Cost, e.FirstPeriod, e.LastPeriod)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileAccountMixedResources(t *testing.T) {
+	run := accountRun{
+		instances: []InstanceData{
+			{InstanceID: "i-idle", ResourceType: resourceEC2},
+			{InstanceID: "vol-1", ResourceType: resourceEBS},
+			{InstanceID: "arn:aws:rds:us-east-1:111111111111:db:orders", ResourceType: resourceRDS},
+			{InstanceID: "logs-bucket", ResourceType: resourceS3},
+			{InstanceID: "nat-1", ResourceType: resourceNAT},
+		},
+		costs: map[string]*resourceCost{},
+	}
+	entries := reconcileAccount(&run, "UnblendedCost")
+	if len(entries) != 1 || entries[0].ResourceID != "i-idle" || entries[0].Status != statusResourceWithoutCost {
+		t.Errorf("entries = %+v, want only i-idle as %s", entries, statusResourceWithoutCost)
+	}
+}
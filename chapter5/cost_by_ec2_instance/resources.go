@@ -0,0 +1,573 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancing"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/rds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/mlabouardy/finops-book/chapter5/curdata"
+)
+
+// Resource kinds, as named by -resources and the ResourceType column
+const (
+	resourceEC2    = "ec2"
+	resourceEBS    = "ebs"
+	resourceRDS    = "rds"
+	resourceLambda = "lambda"
+	resourceS3     = "s3"
+	resourceELB    = "elb"
+	resourceNAT    = "nat"
+)
+
+var resourceKinds = []string{resourceEC2, resourceEBS, resourceRDS, resourceLambda, resourceS3, resourceELB, resourceNAT}
+
+// Cost Explorer services billing the resources besides EC2. EBS volumes and
+// NAT gateways are billed under EC2 - Other.
+const (
+	rdsService    = curdata.RDSService
+	lambdaService = curdata.LambdaService
+	s3Service     = curdata.S3Service
+	elbService    = curdata.ELBService
+)
+
+// resourceCollector lists the resources of one kind. Every resource becomes
+// a report row, joined with the costs Cost Explorer reports under its ID,
+// which Collect stores in InstanceID.
+type resourceCollector interface {
+	// Kind is the -resources name of the collector
+	Kind() string
+	// Service is the Cost Explorer service billing the resources
+	Service() string
+	// Regional collectors run in every region, the others once per account
+	Regional() bool
+	// Collect lists the resources in the region of cfg, or of the whole
+	// account for a collector that is not regional
+	Collect(ctx context.Context, cfg aws.Config, account accountInfo) ([]InstanceData, error)
+}
+
+// newCollectors returns the collectors of the comma-separated -resources
+// kinds, in the order given.
+func newCollectors(value string, types *instanceTypeCache, tco bool, failures *failureLog) ([]resourceCollector, error) {
+	var collectors []resourceCollector
+	seen := make(map[string]bool)
+	for _, kind := range splitList(strings.ToLower(value)) {
+		if seen[kind] {
+			continue
+		}
+		seen[kind] = true
+
+		switch kind {
+		case resourceEC2:
+			collectors = append(collectors, &ec2Collector{types: types, tco: tco, failures: failures})
+		case resourceEBS:
+			collectors = append(collectors, ebsCollector{})
+		case resourceRDS:
+			collectors = append(collectors, rdsCollector{})
+		case resourceLambda:
+			collectors = append(collectors, lambdaCollector{})
+		case resourceS3:
+			collectors = append(collectors, s3Collector{})
+		case resourceELB:
+			collectors = append(collectors, elbCollector{})
+		case resourceNAT:
+			collectors = append(collectors, natCollector{})
+		default:
+			return nil, fmt.Errorf("unsupported resource kind %q, expected one of %s", kind, strings.Join(resourceKinds, ", "))
+		}
+	}
+	if len(collectors) == 0 {
+		return nil, fmt.Errorf("at least one resource kind is required")
+	}
+
+	// With -tco an attached volume is part of its instance's total cost, so
+	// it is not reported again as a row of its own
+	if tco && seen[resourceEC2] {
+		for i, collector := range collectors {
+			if _, ok := collector.(ebsCollector); ok {
+				collectors[i] = ebsCollector{skipAttached: true}
+			}
+		}
+	}
+	return collectors, nil
+}
+
+// onlyInstances reports whether -resources selects EC2 instances alone,
+// the original report.
+func onlyInstances(value string) bool {
+	kinds := splitList(strings.ToLower(value))
+	return len(kinds) == 1 && kinds[0] == resourceEC2
+}
+
+// ec2Collector lists EC2 instances, with the hardware of their types for
+// -unit-costs and their Elastic IPs for -tco. Failing to add either is
+// recorded without failing the instances.
+type ec2Collector struct {
+	types    *instanceTypeCache
+	tco      bool
+	failures *failureLog
+}
+
+func (*ec2Collector) Kind() string    { return resourceEC2 }
+func (*ec2Collector) Service() string { return ec2ComputeService }
+func (*ec2Collector) Regional() bool  { return true }
+
+func (c *ec2Collector) Collect(ctx context.Context, cfg aws.Config, account accountInfo) ([]InstanceData, error) {
+	client := ec2.NewFromConfig(cfg)
+	instances, err := describeInstancesInRegion(ctx, client, cfg.Region)
+	if err != nil || len(instances) == 0 {
+		return instances, err
+	}
+
+	if c.types != nil {
+		instanceTypes := make([]string, len(instances))
+		for i, instance := range instances {
+			instanceTypes[i] = instance.InstanceType
+		}
+		c.failures.attempt()
+		specs, err := c.types.lookup(ctx, client, cfg.Region, instanceTypes)
+		if err != nil {
+			c.failures.record(account, cfg.Region, "fetching instance types", err)
+		}
+		addInstanceSpecs(instances, specs)
+	}
+	if c.tco {
+		c.failures.attempt()
+		if err := attachElasticIPs(ctx, client, instances); err != nil {
+			c.failures.record(account, cfg.Region, "fetching Elastic IPs", err)
+		}
+	}
+	return instances, nil
+}
+
+// ebsCollector lists EBS volumes, or only the unattached ones when their
+// instances carry the cost of the others.
+type ebsCollector struct {
+	skipAttached bool
+}
+
+func (ebsCollector) Kind() string    { return resourceEBS }
+func (ebsCollector) Service() string { return ec2OtherService }
+func (ebsCollector) Regional() bool  { return true }
+
+func (c ebsCollector) Collect(ctx context.Context, cfg aws.Config, account accountInfo) ([]InstanceData, error) {
+	paginator := ec2.NewDescribeVolumesPaginator(ec2.NewFromConfig(cfg), &ec2.DescribeVolumesInput{})
+
+	var volumes []InstanceData
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe volumes, %w", err)
+		}
+		for _, volume := range resp.Volumes {
+			if c.skipAttached && len(volume.Attachments) > 0 {
+				continue
+			}
+			tags := ec2Tags(volume.Tags)
+			volumes = append(volumes, InstanceData{
+				InstanceID:       aws.ToString(volume.VolumeId),
+				Name:             tags["Name"],
+				Region:           cfg.Region,
+				ResourceClass:    string(volume.VolumeType),
+				State:            string(volume.State),
+				LaunchTime:       aws.ToTime(volume.CreateTime),
+				AvailabilityZone: aws.ToString(volume.AvailabilityZone),
+				Tags:             tags,
+			})
+		}
+	}
+	return volumes, nil
+}
+
+// natCollector lists NAT gateways. Cost Explorer reports them by ID or ARN.
+type natCollector struct{}
+
+func (natCollector) Kind() string    { return resourceNAT }
+func (natCollector) Service() string { return ec2OtherService }
+func (natCollector) Regional() bool  { return true }
+
+func (natCollector) Collect(ctx context.Context, cfg aws.Config, account accountInfo) ([]InstanceData, error) {
+	paginator := ec2.NewDescribeNatGatewaysPaginator(ec2.NewFromConfig(cfg), &ec2.DescribeNatGatewaysInput{})
+
+	var gateways []InstanceData
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe NAT gateways, %w", err)
+		}
+		for _, gateway := range resp.NatGateways {
+			id := aws.ToString(gateway.NatGatewayId)
+			tags := ec2Tags(gateway.Tags)
+			gateways = append(gateways, InstanceData{
+				InstanceID:    id,
+				Name:          tags["Name"],
+				Region:        cfg.Region,
+				ResourceClass: string(gateway.ConnectivityType),
+				State:         string(gateway.State),
+				LaunchTime:    aws.ToTime(gateway.CreateTime),
+				VpcID:         aws.ToString(gateway.VpcId),
+				SubnetID:      aws.ToString(gateway.SubnetId),
+				Tags:          tags,
+				costIDs:       []string{fmt.Sprintf("arn:aws:ec2:%s:%s:natgateway/%s", cfg.Region, account.ID, id)},
+			})
+		}
+	}
+	return gateways, nil
+}
+
+func ec2Tags(tags []ec2types.Tag) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		m[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return m
+}
+
+// rdsCollector lists RDS DB instances, which Cost Explorer reports by ARN.
+type rdsCollector struct{}
+
+func (rdsCollector) Kind() string    { return resourceRDS }
+func (rdsCollector) Service() string { return rdsService }
+func (rdsCollector) Regional() bool  { return true }
+
+func (rdsCollector) Collect(ctx context.Context, cfg aws.Config, account accountInfo) ([]InstanceData, error) {
+	paginator := rds.NewDescribeDBInstancesPaginator(rds.NewFromConfig(cfg), &rds.DescribeDBInstancesInput{})
+
+	var databases []InstanceData
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe DB instances, %w", err)
+		}
+		for _, db := range resp.DBInstances {
+			tags := make(map[string]string, len(db.TagList))
+			for _, tag := range db.TagList {
+				tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+			}
+			data := InstanceData{
+				InstanceID:       aws.ToString(db.DBInstanceArn),
+				Name:             aws.ToString(db.DBInstanceIdentifier),
+				Region:           cfg.Region,
+				ResourceClass:    aws.ToString(db.DBInstanceClass),
+				State:            aws.ToString(db.DBInstanceStatus),
+				LaunchTime:       aws.ToTime(db.InstanceCreateTime),
+				AvailabilityZone: aws.ToString(db.AvailabilityZone),
+				Engine:           aws.ToString(db.Engine),
+				Tags:             tags,
+			}
+			if db.DBSubnetGroup != nil {
+				data.VpcID = aws.ToString(db.DBSubnetGroup.VpcId)
+			}
+			databases = append(databases, data)
+		}
+	}
+	return databases, nil
+}
+
+// lambdaCollector lists Lambda functions, which Cost Explorer reports by
+// ARN. Tags take one request per function.
+type lambdaCollector struct{}
+
+func (lambdaCollector) Kind() string    { return resourceLambda }
+func (lambdaCollector) Service() string { return lambdaService }
+func (lambdaCollector) Regional() bool  { return true }
+
+func (lambdaCollector) Collect(ctx context.Context, cfg aws.Config, account accountInfo) ([]InstanceData, error) {
+	client := lambda.NewFromConfig(cfg)
+	paginator := lambda.NewListFunctionsPaginator(client, &lambda.ListFunctionsInput{})
+
+	var functions []InstanceData
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list functions, %w", err)
+		}
+		for _, function := range resp.Functions {
+			arn := aws.ToString(function.FunctionArn)
+			tags, err := client.ListTags(ctx, &lambda.ListTagsInput{Resource: aws.String(arn)})
+			if err != nil {
+				return nil, fmt.Errorf("unable to list tags of function %s, %w", aws.ToString(function.FunctionName), err)
+			}
+
+			var architectures []string
+			for _, arch := range function.Architectures {
+				architectures = append(architectures, string(arch))
+			}
+			data := InstanceData{
+				InstanceID:    arn,
+				Name:          aws.ToString(function.FunctionName),
+				Region:        cfg.Region,
+				ResourceClass: string(function.Runtime),
+				State:         string(function.State),
+				Architecture:  strings.Join(architectures, " "),
+				Tags:          tags.Tags,
+			}
+			// LastModified is the closest thing to a creation date
+			if modified, err := time.Parse("2006-01-02T15:04:05.000-0700", aws.ToString(function.LastModified)); err == nil {
+				data.LaunchTime = modified.UTC()
+			}
+			if function.VpcConfig != nil {
+				data.VpcID = aws.ToString(function.VpcConfig.VpcId)
+			}
+			functions = append(functions, data)
+		}
+	}
+	return functions, nil
+}
+
+// elbCollector lists Application, Network and Gateway Load Balancers and
+// Classic Load Balancers, which Cost Explorer reports by ARN. ResourceClass
+// holds the load balancer type.
+type elbCollector struct{}
+
+func (elbCollector) Kind() string    { return resourceELB }
+func (elbCollector) Service() string { return elbService }
+func (elbCollector) Regional() bool  { return true }
+
+// elbTagsLimit is the most load balancers one DescribeTags request accepts.
+const elbTagsLimit = 20
+
+func (elbCollector) Collect(ctx context.Context, cfg aws.Config, account accountInfo) ([]InstanceData, error) {
+	balancers, err := describeLoadBalancers(ctx, elasticloadbalancingv2.NewFromConfig(cfg), cfg.Region)
+	if err != nil {
+		return nil, err
+	}
+	classic, err := describeClassicLoadBalancers(ctx, elasticloadbalancing.NewFromConfig(cfg), cfg.Region, account.ID)
+	if err != nil {
+		return nil, err
+	}
+	return append(balancers, classic...), nil
+}
+
+func describeLoadBalancers(ctx context.Context, client *elasticloadbalancingv2.Client, region string) ([]InstanceData, error) {
+	paginator := elasticloadbalancingv2.NewDescribeLoadBalancersPaginator(client, &elasticloadbalancingv2.DescribeLoadBalancersInput{})
+
+	var balancers []InstanceData
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe load balancers, %w", err)
+		}
+		for _, lb := range resp.LoadBalancers {
+			data := InstanceData{
+				InstanceID:    aws.ToString(lb.LoadBalancerArn),
+				Name:          aws.ToString(lb.LoadBalancerName),
+				Region:        region,
+				ResourceClass: string(lb.Type),
+				LaunchTime:    aws.ToTime(lb.CreatedTime),
+				VpcID:         aws.ToString(lb.VpcId),
+			}
+			if lb.State != nil {
+				data.State = string(lb.State.Code)
+			}
+			balancers = append(balancers, data)
+		}
+	}
+
+	for start := 0; start < len(balancers); start += elbTagsLimit {
+		batch := balancers[start:min(start+elbTagsLimit, len(balancers))]
+		arns := make([]string, len(batch))
+		for i, lb := range batch {
+			arns[i] = lb.InstanceID
+		}
+		resp, err := client.DescribeTags(ctx, &elasticloadbalancingv2.DescribeTagsInput{ResourceArns: arns})
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe load balancer tags, %w", err)
+		}
+		tags := make(map[string]map[string]string, len(resp.TagDescriptions))
+		for _, description := range resp.TagDescriptions {
+			m := make(map[string]string, len(description.Tags))
+			for _, tag := range description.Tags {
+				m[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+			}
+			tags[aws.ToString(description.ResourceArn)] = m
+		}
+		for i := range batch {
+			batch[i].Tags = tags[batch[i].InstanceID]
+		}
+	}
+	return balancers, nil
+}
+
+func describeClassicLoadBalancers(ctx context.Context, client *elasticloadbalancing.Client, region, accountID string) ([]InstanceData, error) {
+	paginator := elasticloadbalancing.NewDescribeLoadBalancersPaginator(client, &elasticloadbalancing.DescribeLoadBalancersInput{})
+
+	var balancers []InstanceData
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe classic load balancers, %w", err)
+		}
+		for _, lb := range resp.LoadBalancerDescriptions {
+			name := aws.ToString(lb.LoadBalancerName)
+			balancers = append(balancers, InstanceData{
+				InstanceID:    fmt.Sprintf("arn:aws:elasticloadbalancing:%s:%s:loadbalancer/%s", region, accountID, name),
+				Name:          name,
+				Region:        region,
+				ResourceClass: "classic",
+				LaunchTime:    aws.ToTime(lb.CreatedTime),
+				VpcID:         aws.ToString(lb.VPCId),
+			})
+		}
+	}
+
+	for start := 0; start < len(balancers); start += elbTagsLimit {
+		batch := balancers[start:min(start+elbTagsLimit, len(balancers))]
+		names := make([]string, len(batch))
+		for i, lb := range batch {
+			names[i] = lb.Name
+		}
+		resp, err := client.DescribeTags(ctx, &elasticloadbalancing.DescribeTagsInput{LoadBalancerNames: names})
+		if err != nil {
+			return nil, fmt.Errorf("unable to describe classic load balancer tags, %w", err)
+		}
+		tags := make(map[string]map[string]string, len(resp.TagDescriptions))
+		for _, description := range resp.TagDescriptions {
+			m := make(map[string]string, len(description.Tags))
+			for _, tag := range description.Tags {
+				m[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+			}
+			tags[aws.ToString(description.LoadBalancerName)] = m
+		}
+		for i := range batch {
+			batch[i].Tags = tags[batch[i].Name]
+		}
+	}
+	return balancers, nil
+}
+
+// s3Collector lists S3 buckets, which Cost Explorer reports by name.
+// Buckets are global, so they are listed once per account and each is
+// placed in its own region.
+type s3Collector struct{}
+
+func (s3Collector) Kind() string    { return resourceS3 }
+func (s3Collector) Service() string { return s3Service }
+func (s3Collector) Regional() bool  { return false }
+
+func (s3Collector) Collect(ctx context.Context, cfg aws.Config, account accountInfo) ([]InstanceData, error) {
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	client := s3.NewFromConfig(cfg)
+	resp, err := client.ListBuckets(ctx, &s3.ListBucketsInput{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list buckets, %w", err)
+	}
+
+	// Tags are read through a client of the bucket's region
+	clients := make(map[string]*s3.Client)
+	var buckets []InstanceData
+	for _, bucket := range resp.Buckets {
+		name := aws.ToString(bucket.Name)
+		location, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(name)})
+		if err != nil {
+			return nil, fmt.Errorf("unable to get the location of bucket %s, %w", name, err)
+		}
+		region := bucketRegion(string(location.LocationConstraint))
+
+		regional, ok := clients[region]
+		if !ok {
+			regionalCfg := cfg.Copy()
+			regionalCfg.Region = region
+			regional = s3.NewFromConfig(regionalCfg)
+			clients[region] = regional
+		}
+		tags := make(map[string]string)
+		tagging, err := regional.GetBucketTagging(ctx, &s3.GetBucketTaggingInput{Bucket: aws.String(name)})
+		var apiErr smithy.APIError
+		switch {
+		case err == nil:
+			for _, tag := range tagging.TagSet {
+				tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+			}
+		case errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchTagSet":
+		default:
+			return nil, fmt.Errorf("unable to get the tags of bucket %s, %w", name, err)
+		}
+
+		buckets = append(buckets, InstanceData{
+			InstanceID: name,
+			Name:       name,
+			Region:     region,
+			LaunchTime: aws.ToTime(bucket.CreationDate),
+			Tags:       tags,
+		})
+	}
+	return buckets, nil
+}
+
+// bucketRegion maps a bucket location constraint to its region: buckets in
+// us-east-1 have none, and "EU" is the legacy name of eu-west-1.
+func bucketRegion(constraint string) string {
+	switch constraint {
+	case "":
+		return "us-east-1"
+	case "EU":
+		return "eu-west-1"
+	}
+	return constraint
+}
+
+// resourceCostOf returns the costs of a resource, merged with those Cost
+// Explorer reports under its other IDs.
+func resourceCostOf(costs map[string]*resourceCost, resource InstanceData) *resourceCost {
+	merged := costs[resource.InstanceID]
+	for _, id := range resource.costIDs {
+		cost, ok := costs[id]
+		if !ok {
+			continue
+		}
+		if merged == nil {
+			merged = cost
+			continue
+		}
+		merged = mergeResourceCosts(merged, cost)
+	}
+	if merged == nil {
+		merged = &resourceCost{}
+	}
+	return merged
+}
+
+func mergeResourceCosts(a, b *resourceCost) *resourceCost {
+	merged := &resourceCost{
+		InstanceHours:     a.InstanceHours + b.InstanceHours,
+		InstanceHoursCost: a.InstanceHoursCost + b.InstanceHoursCost,
+	}
+
+	periods := make(map[string]map[string]float64)
+	for _, point := range append(append([]CostPoint(nil), a.Series...), b.Series...) {
+		amounts := periods[point.PeriodStart]
+		if amounts == nil {
+			amounts = make(map[string]float64, len(point.Costs))
+			periods[point.PeriodStart] = amounts
+			merged.Series = append(merged.Series, CostPoint{PeriodStart: point.PeriodStart, Costs: amounts})
+		}
+		for metric, amount := range point.Costs {
+			amounts[metric] += amount
+		}
+	}
+	sort.Slice(merged.Series, func(i, j int) bool { return merged.Series[i].PeriodStart < merged.Series[j].PeriodStart })
+
+	if a.Breakdown != nil || b.Breakdown != nil {
+		merged.Breakdown = make(map[string]float64)
+		for _, breakdown := range []map[string]float64{a.Breakdown, b.Breakdown} {
+			for category, amount := range breakdown {
+				merged.Breakdown[category] += amount
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestNewCollectorsAttachedVolumes(t *testing.T) {
+	tests := []struct {
+		name         string
+		resources    string
+		tco          bool
+		skipAttached bool
+	}{
+		{"volumes alone", "ebs", false, false},
+		{"volumes alone with tco", "ebs", true, false},
+		{"instances and volumes", "ec2,ebs", false, false},
+		{"instances and volumes with tco", "ebs,ec2", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collectors, err := newCollectors(tt.resources, nil, tt.tco, &failureLog{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, collector := range collectors {
+				if ebs, ok := collector.(ebsCollector); ok && ebs.skipAttached != tt.skipAttached {
+					t.Errorf("skipAttached = %v, want %v", ebs.skipAttached, tt.skipAttached)
+				}
+			}
+		})
+	}
+}
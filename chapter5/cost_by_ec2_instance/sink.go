@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/xuri/excelize/v2"
+)
+
+// sink receives report rows one at a time. Close flushes anything buffered;
+// it does not close the underlying writer.
+type sink interface {
+	Write(instance InstanceData) error
+	Close() error
+}
+
+// column describes one field of the flat (CSV and XLSX) report layout.
+type column struct {
+	header string
+	value  func(instance InstanceData) any
+}
+
+// reportColumns returns the flat layout of InstanceData. Nested formats
+// (JSON, NDJSON and Parquet) encode the struct directly.
+func reportColumns(opts options, periods []string, metrics []string) []column {
+	columns := []column{
+		{"AccountID", func(i InstanceData) any { return i.AccountID }},
+		{"AccountName", func(i InstanceData) any { return i.AccountName }},
+		{"InstanceID", func(i InstanceData) any { return i.InstanceID }},
+		{"Name", func(i InstanceData) any { return i.Name }},
+		{"Region", func(i InstanceData) any { return i.Region }},
+		{"InstanceType", func(i InstanceData) any { return i.InstanceType }},
+		{"State", func(i InstanceData) any { return i.State }},
+		{"Lifecycle", func(i InstanceData) any { return i.Lifecycle }},
+		{"LaunchTime", func(i InstanceData) any { return i.LaunchTime }},
+		{"AgeDays", func(i InstanceData) any { return i.AgeDays }},
+		{"AvailabilityZone", func(i InstanceData) any { return i.AvailabilityZone }},
+		{"Platform", func(i InstanceData) any { return i.Platform }},
+		{"Tenancy", func(i InstanceData) any { return i.Tenancy }},
+		{"Architecture", func(i InstanceData) any { return i.Architecture }},
+		{"VpcID", func(i InstanceData) any { return i.VpcID }},
+		{"SubnetID", func(i InstanceData) any { return i.SubnetID }},
+	}
+	if !onlyInstances(opts.resources) {
+		columns = slices.Insert(columns, 2, column{"ResourceType", func(i InstanceData) any { return i.ResourceType }})
+		columns = insertAfter(columns, "InstanceType", column{"ResourceClass", func(i InstanceData) any { return i.ResourceClass }})
+		columns = insertAfter(columns, "Platform", column{"Engine", func(i InstanceData) any { return i.Engine }})
+	}
+
+	// Chosen tag keys get a column each; the rest stay in Tags
+	tagKeys := splitList(opts.tagColumns)
+	for _, key := range tagKeys {
+		columns = append(columns, column{"Tag:" + key, func(i InstanceData) any {
+			if value, ok := i.Tags[key]; ok {
+				return value
+			}
+			return opts.missingTag
+		}})
+	}
+	columns = append(columns, column{"Tags", func(i InstanceData) any { return formatTags(i.Tags, tagKeys) }})
+
+	// One column per metric, named after the metric
+	for _, metric := range metrics {
+		columns = append(columns, column{metric, costValue(func(i InstanceData) any { return i.Costs[metric] })})
+	}
+
+	if opts.tco {
+		columns = append(columns,
+			column{"Volumes", func(i InstanceData) any { return strings.Join(i.Volumes, " ") }},
+			column{"ElasticIPs", func(i InstanceData) any { return strings.Join(i.ElasticIPs, " ") }},
+			column{"AttachedCost", costValue(func(i InstanceData) any { return i.AttachedCost })},
+			column{"TotalCost", costValue(func(i InstanceData) any { return i.TotalCost })},
+		)
+	}
+
+	if opts.prices != "" {
+		columns = append(columns,
+			column{"RunningHours", costValue(func(i InstanceData) any { return i.RunningHours })},
+			column{"EffectiveRate", costValue(func(i InstanceData) any { return hourlyRate(i.EffectiveRate) })},
+			column{"OnDemandRate", func(i InstanceData) any { return hourlyRate(i.OnDemandRate) }},
+			column{"DiscountCoverage", costValue(func(i InstanceData) any { return i.DiscountCoverage })},
+		)
+	}
+
+	if opts.unitCosts {
+		// RunningHours and EffectiveRate already come with -price-catalog
+		if opts.prices == "" {
+			columns = append(columns,
+				column{"RunningHours", costValue(func(i InstanceData) any { return i.RunningHours })},
+				column{"EffectiveRate", costValue(func(i InstanceData) any { return hourlyRate(i.EffectiveRate) })},
+			)
+		}
+		columns = append(columns,
+			column{"VCPUs", func(i InstanceData) any { return i.VCPUs }},
+			column{"MemoryGiB", func(i InstanceData) any { return i.MemoryGiB }},
+			column{"CostPerVCPUHour", costValue(func(i InstanceData) any { return hourlyRate(i.CostPerVCPUHour) })},
+			column{"CostPerGiBHour", costValue(func(i InstanceData) any { return hourlyRate(i.CostPerGiBHour) })},
+		)
+	}
+
+	if opts.breakdown {
+		for _, category := range usageCategories {
+			columns = append(columns, column{category + "Cost", costValue(func(i InstanceData) any { return i.Breakdown[category] })})
+		}
+	}
+
+	switch opts.layout {
+	case layoutLong:
+		columns = append(columns, column{"PeriodStart", func(i InstanceData) any { return i.Series[0].PeriodStart }})
+		for _, metric := range metrics {
+			columns = append(columns, column{"Period" + metric, costValue(func(i InstanceData) any { return i.Series[0].Costs[metric] })})
+		}
+	case layoutPivot:
+		for _, metric := range metrics {
+			for p, period := range periods {
+				columns = append(columns, column{metric + " " + period, costValue(func(i InstanceData) any { return i.Series[p].Costs[metric] })})
+			}
+		}
+	}
+	return columns
+}
+
+// flatFormats are the formats written through reportColumns.
+var flatFormats = []string{"csv", "xlsx"}
+
+// checkTagColumns rejects -tag-columns where it has no effect: nested
+// formats, FOCUS rows and reconciliations keep every tag in one field.
+func checkTagColumns(opts options) error {
+	if opts.tagColumns == "" {
+		return nil
+	}
+	if opts.focus || opts.reconcile || !slices.Contains(flatFormats, opts.format) {
+		return fmt.Errorf("-tag-columns only applies to %s reports", strings.Join(flatFormats, " and "))
+	}
+	return nil
+}
+
+// insertAfter inserts a column after the one with the header.
+func insertAfter(columns []column, header string, c column) []column {
+	i := slices.IndexFunc(columns, func(c column) bool { return c.header == header })
+	return slices.Insert(columns, i+1, c)
+}
+
+// unknownCost is written in the cost columns of resources whose costs could
+// not be fetched, so they are not mistaken for resources without cost.
+const unknownCost = "unknown"
+
+// costValue wraps the value of a cost column.
+func costValue(value func(instance InstanceData) any) func(instance InstanceData) any {
+	return func(instance InstanceData) any {
+		if instance.CostUnknown {
+			return unknownCost
+		}
+		return value(instance)
+	}
+}
+
+// hourlyRate is a per-hour price, which needs more precision than a total.
+type hourlyRate float64
+
+func formatCell(value any) string {
+	switch v := value.(type) {
+	case float64:
+		return fmt.Sprintf("%.2f", v)
+	case hourlyRate:
+		return fmt.Sprintf("%.4f", v)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+var formatExtensions = map[string]string{
+	"csv":     "csv",
+	"json":    "json",
+	"ndjson":  "ndjson",
+	"parquet": "parquet",
+	"xlsx":    "xlsx",
+}
+
+// openOutput resolves the -out flag: "-" is stdout and an empty value falls
+// back to the given name with the extension of the format.
+func openOutput(format, path, name string) (io.WriteCloser, string, error) {
+	ext, ok := formatExtensions[format]
+	if !ok {
+		return nil, "", fmt.Errorf("unsupported format %q", format)
+	}
+	if path == "-" {
+		return nopCloser{os.Stdout}, "stdout", nil
+	}
+	if path == "" {
+		path = name + "." + ext
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to create output file: %v", err)
+	}
+	return file, path, nil
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func newSink(format string, w io.Writer, columns []column) (sink, error) {
+	switch format {
+	case "csv":
+		return newCSVSink(w, columns)
+	case "json":
+		return &jsonSink{w: w}, nil
+	case "ndjson":
+		return &ndjsonSink{encoder: json.NewEncoder(w)}, nil
+	case "parquet":
+		return &parquetSink{writer: parquet.NewGenericWriter[InstanceData](w)}, nil
+	case "xlsx":
+		return newXLSXSink(w, columns)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+type csvSink struct {
+	writer  *csv.Writer
+	columns []column
+}
+
+func newCSVSink(w io.Writer, columns []column) (*csvSink, error) {
+	s := &csvSink{writer: csv.NewWriter(w), columns: columns}
+
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.header
+	}
+	if err := s.writer.Write(header); err != nil {
+		return nil, fmt.Errorf("unable to write CSV header: %v", err)
+	}
+	return s, nil
+}
+
+func (s *csvSink) Write(instance InstanceData) error {
+	record := make([]string, len(s.columns))
+	for i, c := range s.columns {
+		record[i] = formatCell(c.value(instance))
+	}
+	return s.writer.Write(record)
+}
+
+func (s *csvSink) Close() error {
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+// jsonSink streams a single JSON array so large reports are not held in memory.
+type jsonSink struct {
+	w     io.Writer
+	count int
+}
+
+func (s *jsonSink) Write(instance InstanceData) error {
+	data, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+
+	prefix := ",\n  "
+	if s.count == 0 {
+		prefix = "[\n  "
+	}
+	s.count++
+	if _, err := io.WriteString(s.w, prefix); err != nil {
+		return err
+	}
+	_, err = s.w.Write(data)
+	return err
+}
+
+func (s *jsonSink) Close() error {
+	closing := "\n]\n"
+	if s.count == 0 {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(s.w, closing)
+	return err
+}
+
+type ndjsonSink struct {
+	encoder *json.Encoder
+}
+
+func (s *ndjsonSink) Write(instance InstanceData) error {
+	return s.encoder.Encode(instance)
+}
+
+func (s *ndjsonSink) Close() error {
+	return nil
+}
+
+type parquetSink struct {
+	writer *parquet.GenericWriter[InstanceData]
+}
+
+func (s *parquetSink) Write(instance InstanceData) error {
+	_, err := s.writer.Write([]InstanceData{instance})
+	return err
+}
+
+func (s *parquetSink) Close() error {
+	return s.writer.Close()
+}
+
+// xlsxSink assembles the workbook with excelize and writes it out on Close,
+// since the XLSX container cannot be streamed to a plain writer.
+type xlsxSink struct {
+	w       io.Writer
+	file    *excelize.File
+	stream  *excelize.StreamWriter
+	columns []column
+	row     int
+}
+
+const xlsxSheet = "Sheet1"
+
+func newXLSXSink(w io.Writer, columns []column) (*xlsxSink, error) {
+	file := excelize.NewFile()
+	stream, err := file.NewStreamWriter(xlsxSheet)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create XLSX sheet: %v", err)
+	}
+
+	s := &xlsxSink{w: w, file: file, stream: stream, columns: columns, row: 1}
+	header := make([]any, len(columns))
+	for i, c := range columns {
+		header[i] = c.header
+	}
+	if err := s.writeRow(header); err != nil {
+		return nil, fmt.Errorf("unable to write XLSX header: %v", err)
+	}
+	return s, nil
+}
+
+func (s *xlsxSink) writeRow(values []any) error {
+	cell, err := excelize.CoordinatesToCellName(1, s.row)
+	if err != nil {
+		return err
+	}
+	s.row++
+	return s.stream.SetRow(cell, values)
+}
+
+func (s *xlsxSink) Write(instance InstanceData) error {
+	values := make([]any, len(s.columns))
+	for i, c := range s.columns {
+		values[i] = c.value(instance)
+		if rate, ok := values[i].(hourlyRate); ok {
+			values[i] = float64(rate)
+		}
+	}
+	return s.writeRow(values)
+}
+
+func (s *xlsxSink) Close() error {
+	if err := s.stream.Flush(); err != nil {
+		return err
+	}
+	if err := s.file.Write(s.w); err != nil {
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReportColumnsResourceFields(t *testing.T) {
+	headers := func(opts options) []string {
+		var names []string
+		for _, c := range reportColumns(opts, nil, []string{"UnblendedCost"}) {
+			names = append(names, c.header)
+		}
+		return names
+	}
+
+	instances := headers(options{resources: resourceEC2})
+	for _, name := range []string{"ResourceType", "ResourceClass", "Engine"} {
+		if slices.Contains(instances, name) {
+			t.Errorf("instance report has a %s column", name)
+		}
+	}
+
+	resources := headers(options{resources: "ec2,rds"})
+	for _, pair := range [][2]string{{"InstanceType", "ResourceClass"}, {"Platform", "Engine"}} {
+		i := slices.Index(resources, pair[0])
+		if i < 0 || i+1 >= len(resources) || resources[i+1] != pair[1] {
+			t.Errorf("%s does not follow %s in %v", pair[1], pair[0], resources)
+		}
+	}
+}
+
+func TestFormatTags(t *testing.T) {
+	tags := map[string]string{
+		"Owner":   "alice",
+		"Project": `checkout, "v2"`,
+		"Cost":    "team:web",
+		"App":     "a\\b",
+	}
+	want := `{"App":"a\\b","Cost":"team:web","Owner":"alice","Project":"checkout, \"v2\""}`
+	for i := 0; i < 10; i++ {
+		if got := formatTags(tags, nil); got != want {
+			t.Fatalf("formatTags = %s, want %s", got, want)
+		}
+	}
+	if got := formatTags(tags, []string{"Owner", "Project", "Missing"}); got != `{"App":"a\\b","Cost":"team:web"}` {
+		t.Errorf("formatTags excluding tag columns = %s", got)
+	}
+	if got := formatTags(nil, nil); got != "{}" {
+		t.Errorf("formatTags of no tags = %s, want {}", got)
+	}
+}
+
+func TestCSVSinkTags(t *testing.T) {
+	opts := options{resources: resourceEC2, tagColumns: "Owner,Team", missingTag: "n/a"}
+	var b strings.Builder
+	sink, err := newSink("csv", &b, reportColumns(opts, nil, []string{"UnblendedCost"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := InstanceData{InstanceID: "i-1", Tags: map[string]string{"Owner": `Smith, "Al"`, "Project": "a,b"}}
+	if err := sink.Write(instance); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(strings.NewReader(b.String())).ReadAll()
+	if err != nil {
+		t.Fatalf("report is not valid CSV: %v\n%s", err, b.String())
+	}
+	row := make(map[string]string)
+	for i, name := range records[0] {
+		row[name] = records[1][i]
+	}
+	if row["Tag:Owner"] != `Smith, "Al"` || row["Tag:Team"] != "n/a" {
+		t.Errorf("tag columns = %q, %q", row["Tag:Owner"], row["Tag:Team"])
+	}
+	var rest map[string]string
+	if err := json.Unmarshal([]byte(row["Tags"]), &rest); err != nil || len(rest) != 1 || rest["Project"] != "a,b" {
+		t.Errorf("Tags = %s, want only Project", row["Tags"])
+	}
+}
+
+func TestCheckTagColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    options
+		wantErr bool
+	}{
+		{"no tag columns", options{format: "json"}, false},
+		{"csv", options{format: "csv", tagColumns: "Owner"}, false},
+		{"xlsx", options{format: "xlsx", tagColumns: "Owner"}, false},
+		{"json", options{format: "json", tagColumns: "Owner"}, true},
+		{"ndjson", options{format: "ndjson", tagColumns: "Owner"}, true},
+		{"parquet", options{format: "parquet", tagColumns: "Owner"}, true},
+		{"focus", options{format: "csv", tagColumns: "Owner", focus: true}, true},
+		{"reconcile", options{format: "csv", tagColumns: "Owner", reconcile: true}, true},
+	}
+	for _, tt := range tests {
+		if err := checkTagColumns(tt.opts); (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkTagColumns = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+)
+
+// ceRequestPrice is what AWS charges per Cost Explorer API request, in USD.
+const ceRequestPrice = 0.01
+
+// Cost Explorer does not document how many groups a page of resource-level
+// results holds. Unless a full page of the lookup is cached, the estimate
+// is a range between these page sizes.
+const (
+	minGroupsPerPage = 500
+	maxGroupsPerPage = 5000
+)
+
+// maxUsageTypesPerResource bounds the usage types a resource has when
+// results are also grouped by usage type; it has at least one.
+const maxUsageTypesPerResource = 4
+
+// countingCostExplorer counts the requests that actually reach Cost
+// Explorer. It sits below the response cache, so cache hits are free.
+type countingCostExplorer struct {
+	api   costExplorerAPI
+	calls *atomic.Int64
+}
+
+func (c *countingCostExplorer) GetCostAndUsageWithResources(ctx context.Context, params *costexplorer.GetCostAndUsageWithResourcesInput, optFns ...func(*costexplorer.Options)) (*costexplorer.GetCostAndUsageWithResourcesOutput, error) {
+	c.calls.Add(1)
+	return c.api.GetCostAndUsageWithResources(ctx, params, optFns...)
+}
+
+// callEstimate is a range of billed Cost Explorer requests.
+type callEstimate struct {
+	low, high int
+}
+
+func (e callEstimate) add(other callEstimate) callEstimate {
+	return callEstimate{low: e.low + other.low, high: e.high + other.high}
+}
+
+func (e callEstimate) String() string {
+	if e.low == e.high {
+		return fmt.Sprintf("%d (about $%.2f)", e.high, e.charge())
+	}
+	return fmt.Sprintf("%d to %d (about $%.2f to $%.2f)", e.low, e.high, float64(e.low)*ceRequestPrice, e.charge())
+}
+
+// charge is the price of the high end of the estimate.
+func (e callEstimate) charge() float64 {
+	return float64(e.high) * ceRequestPrice
+}
+
+// cachedLookup is the part of a paginated lookup found in the cache.
+type cachedLookup struct {
+	pages    int
+	groups   int
+	complete bool // the last cached page ends the lookup
+	pageSize int  // groups of a full page, when one is cached
+}
+
+// estimateResourceCalls estimates the billed requests of a resource-level
+// lookup covering the given number of resources. Every resource may appear
+// in every period the API returns, once per usage type when grouped by it.
+// Cached pages are free; pageSize is the groups of a full page when known.
+func estimateResourceCalls(query costQuery, resources int, cached cachedLookup, pageSize int) callEstimate {
+	if cached.complete {
+		return callEstimate{}
+	}
+
+	periods := 0
+	for day := query.Window.Start; day.Before(query.Window.End); day = day.AddDate(0, 0, 1) {
+		if query.Window.Granularity != "MONTHLY" || day == query.Window.Start || day.Day() == 1 {
+			periods++
+		}
+	}
+
+	fewest := resources * periods
+	most := fewest
+	if query.groupsByUsageType() {
+		most *= maxUsageTypesPerResource
+	}
+	smallest, largest := minGroupsPerPage, maxGroupsPerPage
+	if pageSize > 0 {
+		smallest, largest = pageSize, pageSize
+	}
+	return callEstimate{
+		low:  uncachedPages(fewest-cached.groups, largest),
+		high: uncachedPages(most-cached.groups, smallest),
+	}
+}
+
+// uncachedPages returns the pages holding the groups left after the cached
+// pages. The first uncached page is always requested.
+func uncachedPages(groups, pageSize int) int {
+	return max((groups+pageSize-1)/pageSize, 1)
+}
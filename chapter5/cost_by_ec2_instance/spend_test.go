@@ -0,0 +1,92 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+func TestEstimateResourceCalls(t *testing.T) {
+	// 10 days of daily results
+	window := costWindow{
+		Start:       time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		End:         time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC),
+		Granularity: "DAILY",
+	}
+	query := costQuery{Window: window}
+	byUsageType := costQuery{Window: window, Breakdown: true}
+
+	tests := []struct {
+		name      string
+		query     costQuery
+		resources int
+		cached    cachedLookup
+		pageSize  int
+		want      callEstimate
+	}{
+		{"no resources", query, 0, cachedLookup{}, 0, callEstimate{1, 1}},
+		{"unknown page size", query, 100, cachedLookup{}, 0, callEstimate{1, 2}},
+		{"known page size", query, 100, cachedLookup{}, 300, callEstimate{4, 4}},
+		{"grouped by usage type", byUsageType, 100, cachedLookup{}, 300, callEstimate{4, 14}},
+		{"first pages cached", query, 100, cachedLookup{pages: 2, groups: 600, pageSize: 300}, 300, callEstimate{2, 2}},
+		{"cached beyond the estimate", query, 100, cachedLookup{pages: 4, groups: 1200, pageSize: 300}, 300, callEstimate{1, 1}},
+		{"fully cached", query, 100, cachedLookup{pages: 4, groups: 1000, complete: true}, 300, callEstimate{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := estimateResourceCalls(tt.query, tt.resources, tt.cached, tt.pageSize); got != tt.want {
+				t.Errorf("estimate = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachedPages(t *testing.T) {
+	cache, err := openResponseCache(filepath.Join(t.TempDir(), "cache.db"), false, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	client := &cachedCostExplorer{cache: cache, scope: "111111111111"}
+
+	input := &costexplorer.GetCostAndUsageWithResourcesInput{
+		TimePeriod: &types.DateInterval{Start: aws.String("2024-05-01"), End: aws.String("2024-05-11")},
+	}
+	page := func(groups int, next *string) *costexplorer.GetCostAndUsageWithResourcesOutput {
+		return &costexplorer.GetCostAndUsageWithResourcesOutput{
+			ResultsByTime: []types.ResultByTime{{Groups: make([]types.Group, groups)}},
+			NextPageToken: next,
+		}
+	}
+	store := func(token *string, resp *costexplorer.GetCostAndUsageWithResourcesOutput) {
+		params := *input
+		params.NextPageToken = token
+		key, err := cacheKey("GetCostAndUsageWithResources", client.scope, normalizeResourcesInput(&params))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.put(key, "2024-05-11", resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := client.cachedPages(input); got != (cachedLookup{}) {
+		t.Errorf("empty cache = %+v, want nothing cached", got)
+	}
+
+	store(nil, page(300, aws.String("2")))
+	want := cachedLookup{pages: 1, groups: 300, pageSize: 300}
+	if got := client.cachedPages(input); got != want {
+		t.Errorf("first page cached = %+v, want %+v", got, want)
+	}
+
+	store(aws.String("2"), page(120, nil))
+	want = cachedLookup{pages: 2, groups: 420, complete: true, pageSize: 300}
+	if got := client.cachedPages(input); got != want {
+		t.Errorf("all pages cached = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,617 @@
+package curdata
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/deprecated"
+)
+
+// Record is a Cost and Usage Report line item reduced to the fields the
+// cost queries use. Amounts are in USD.
+type Record struct {
+	Start        time.Time
+	Payer        string
+	Account      string
+	Service      string // as named by the Cost Explorer SERVICE dimension
+	Region       string
+	UsageType    string
+	RecordType   string // the line item type, e.g. Usage, Credit or RIFee
+	ResourceID   string
+	InstanceType string
+
+	Unblended    float64
+	Blended      float64
+	NetUnblended float64
+	Amortized    float64
+	NetAmortized float64
+	Usage        float64
+
+	Tags           map[string]string // keyed by Name, e.g. user_project
+	CostCategories map[string]string // keyed by Name
+}
+
+// Filter selects the line items Load keeps. An empty field keeps all.
+type Filter struct {
+	Periods  []Period // usage starting within any of them
+	Accounts []string // linked accounts
+	Services []string // SERVICE dimension values
+}
+
+// keeps reports whether a line item passes the filter.
+func (f Filter) keeps(r *Record) bool {
+	if len(f.Accounts) > 0 && !slices.Contains(f.Accounts, r.Account) {
+		return false
+	}
+	if len(f.Services) > 0 && !slices.Contains(f.Services, r.Service) {
+		return false
+	}
+	if len(f.Periods) == 0 {
+		return true
+	}
+	for _, period := range f.Periods {
+		if period.contains(r.Start) {
+			return true
+		}
+	}
+	return false
+}
+
+// Data holds the line items of a CUR export that passed the load filter.
+type Data struct {
+	records []Record
+	payer   string
+	read    int // line items read, kept or not
+}
+
+// Load reads every CUR file under dir: CUR 2.0 (Data Exports) and legacy
+// CUR, as Parquet or as plain or gzipped CSV. Line items are filtered as
+// they are read, so only the ones the queries can use are held in memory.
+func Load(dir string, filter Filter) (*Data, error) {
+	data := &Data{}
+	stale, err := staleAssemblies(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CUR manifests in %s: %v", dir, err)
+	}
+
+	err = filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if stale[path] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch {
+		case strings.HasSuffix(path, ".parquet"):
+			return data.readParquet(path, filter)
+		case strings.HasSuffix(path, ".csv"), strings.HasSuffix(path, ".csv.gz"):
+			return data.readCSV(path, filter)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CUR files in %s: %v", dir, err)
+	}
+	if data.read == 0 {
+		return nil, fmt.Errorf("no CUR line items found in %s", dir)
+	}
+	return data, nil
+}
+
+// add keeps a line item read from a file if it passes the filter.
+func (d *Data) add(r Record, filter Filter) {
+	d.read++
+	if d.payer == "" {
+		d.payer = r.Payer
+	}
+	if filter.keeps(&r) {
+		d.records = append(d.records, r)
+	}
+}
+
+// Payer returns the payer account of the line items, which is the same for
+// every line item of one CUR export.
+func (d *Data) Payer() string {
+	return d.payer
+}
+
+// staleAssemblies finds superseded legacy CUR deliveries. Without report
+// versioning set to overwrite, every refresh of a billing period lands in a
+// new assembly directory next to the old ones; the period's manifest names
+// the current one, and reading the others would count costs twice.
+func staleAssemblies(dir string) (map[string]bool, error) {
+	manifests, err := filepath.Glob(filepath.Join(dir, "*", "*-Manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	more, err := filepath.Glob(filepath.Join(dir, "*-Manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+	manifests = append(manifests, more...)
+
+	stale := make(map[string]bool)
+	for _, manifest := range manifests {
+		content, err := os.ReadFile(manifest)
+		if err != nil {
+			return nil, err
+		}
+		var current struct {
+			AssemblyID string `json:"assemblyId"`
+		}
+		if json.Unmarshal(content, &current) != nil || current.AssemblyID == "" {
+			continue
+		}
+
+		period := filepath.Dir(manifest)
+		entries, err := os.ReadDir(period)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || entry.Name() == current.AssemblyID {
+				continue
+			}
+			// Only directories holding their own manifest are assemblies
+			nested, _ := filepath.Glob(filepath.Join(period, entry.Name(), "*-Manifest.json"))
+			if len(nested) > 0 {
+				stale[filepath.Join(period, entry.Name())] = true
+			}
+		}
+	}
+	return stale, nil
+}
+
+func (d *Data) readCSV(path string, filter Filter) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	layout := newColumnLayout(header)
+
+	for {
+		values, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		record, err := layout.record(values)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		d.add(record, filter)
+	}
+}
+
+// parquetLeaf tells where a Parquet leaf column goes in a row: a top-level
+// column is a field of its own, while the keys and values of a CUR 2.0 map
+// column (resource_tags, cost_category, product) are gathered into one
+// field encoded as a JSON object, as CUR 2.0 CSV files have it.
+type parquetLeaf struct {
+	field      int
+	mapKey     bool
+	mapValue   bool
+	entryLevel int // definition level at which a map entry exists
+	timeUnit   time.Duration
+	date       bool
+}
+
+func (d *Data) readParquet(path string, filter Filter) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	pf, err := parquet.OpenFile(file, info.Size())
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	var fields []string
+	fieldIndex := make(map[string]int)
+	leaves := make(map[int]parquetLeaf)
+	for column, columnPath := range pf.Schema().Columns() {
+		name := columnPath[0]
+		isMap := len(columnPath) == 3 && (columnPath[2] == "key" || columnPath[2] == "value")
+		if len(columnPath) != 1 && !isMap {
+			continue
+		}
+		if _, ok := fieldIndex[name]; !ok {
+			fieldIndex[name] = len(fields)
+			fields = append(fields, name)
+		}
+
+		leafColumn, _ := pf.Schema().Lookup(columnPath...)
+		leaf := parquetLeaf{
+			field:      fieldIndex[name],
+			mapKey:     isMap && columnPath[2] == "key",
+			mapValue:   isMap && columnPath[2] == "value",
+			entryLevel: leafColumn.MaxDefinitionLevel,
+		}
+		if leafColumn.Node.Optional() {
+			leaf.entryLevel--
+		}
+		leaf.timeUnit, leaf.date = parquetTimeType(leafColumn.Node)
+		leaves[column] = leaf
+	}
+	layout := newColumnLayout(fields)
+
+	values := make([]string, len(fields))
+	keys := make(map[int][]string)
+	items := make(map[int][]string)
+	buffer := make([]parquet.Row, 256)
+	for _, rowGroup := range pf.RowGroups() {
+		rows := rowGroup.Rows()
+		for {
+			n, err := rows.ReadRows(buffer)
+			for _, row := range buffer[:n] {
+				clear(values)
+				clear(keys)
+				clear(items)
+				for _, value := range row {
+					leaf, ok := leaves[value.Column()]
+					if !ok {
+						continue
+					}
+					switch {
+					case leaf.mapKey:
+						if !value.IsNull() {
+							keys[leaf.field] = append(keys[leaf.field], parquetString(value, leaf))
+						}
+					case leaf.mapValue:
+						if value.DefinitionLevel() >= leaf.entryLevel {
+							item := ""
+							if !value.IsNull() {
+								item = parquetString(value, leaf)
+							}
+							items[leaf.field] = append(items[leaf.field], item)
+						}
+					case !value.IsNull():
+						values[leaf.field] = parquetString(value, leaf)
+					}
+				}
+				for field, names := range keys {
+					entries := make(map[string]string, len(names))
+					for i, name := range names {
+						if i < len(items[field]) {
+							entries[name] = items[field][i]
+						}
+					}
+					encoded, _ := json.Marshal(entries)
+					values[field] = string(encoded)
+				}
+
+				record, err := layout.record(values)
+				if err != nil {
+					rows.Close()
+					return fmt.Errorf("%s: %v", path, err)
+				}
+				d.add(record, filter)
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				rows.Close()
+				return fmt.Errorf("%s: %v", path, err)
+			}
+		}
+		rows.Close()
+	}
+	return nil
+}
+
+// parquetTimeType returns the unit of a timestamp column, or whether it
+// holds dates, from its logical or legacy converted type.
+func parquetTimeType(node parquet.Node) (time.Duration, bool) {
+	if logical := node.Type().LogicalType(); logical != nil {
+		switch {
+		case logical.Timestamp != nil && logical.Timestamp.Unit.Millis != nil:
+			return time.Millisecond, false
+		case logical.Timestamp != nil && logical.Timestamp.Unit.Micros != nil:
+			return time.Microsecond, false
+		case logical.Timestamp != nil && logical.Timestamp.Unit.Nanos != nil:
+			return time.Nanosecond, false
+		case logical.Date != nil:
+			return 0, true
+		}
+	}
+	if converted := node.Type().ConvertedType(); converted != nil {
+		switch *converted {
+		case deprecated.TimestampMillis:
+			return time.Millisecond, false
+		case deprecated.TimestampMicros:
+			return time.Microsecond, false
+		case deprecated.Date:
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func parquetString(value parquet.Value, leaf parquetLeaf) string {
+	switch value.Kind() {
+	case parquet.ByteArray, parquet.FixedLenByteArray:
+		return string(value.ByteArray())
+	case parquet.Double:
+		return strconv.FormatFloat(value.Double(), 'f', -1, 64)
+	case parquet.Float:
+		return strconv.FormatFloat(float64(value.Float()), 'f', -1, 32)
+	case parquet.Int32:
+		if leaf.date {
+			return time.Unix(int64(value.Int32())*86400, 0).UTC().Format(dateLayout)
+		}
+		return strconv.FormatInt(int64(value.Int32()), 10)
+	case parquet.Int64:
+		if leaf.timeUnit > 0 {
+			return time.Unix(0, value.Int64()*int64(leaf.timeUnit)).UTC().Format(time.RFC3339)
+		}
+		return strconv.FormatInt(value.Int64(), 10)
+	case parquet.Int96:
+		// Legacy Parquet timestamps: nanoseconds of the day, then the
+		// Julian day number
+		i := value.Int96()
+		nanos := int64(uint64(i[1])<<32 | uint64(i[0]))
+		days := int64(i[2]) - 2440588
+		return time.Unix(days*86400, nanos).UTC().Format(time.RFC3339)
+	case parquet.Boolean:
+		return strconv.FormatBool(value.Boolean())
+	default:
+		return value.String()
+	}
+}
+
+// Name normalizes a CUR column name to the snake case of Athena and CUR
+// 2.0, so legacy CSV headers such as "lineItem/UnblendedCost" and
+// "resourceTags/user:CostCenter" read as "line_item_unblended_cost" and
+// "resource_tags_user_cost_center". Tag keys are normalized the same way.
+func Name(column string) string {
+	var b strings.Builder
+	underscore := func() {
+		if b.Len() > 0 && !strings.HasSuffix(b.String(), "_") {
+			b.WriteByte('_')
+		}
+	}
+	for _, r := range column {
+		switch {
+		case unicode.IsUpper(r):
+			underscore()
+			b.WriteRune(unicode.ToLower(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			underscore()
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
+
+// TagKey is the normalized name of a cost allocation tag: user-defined
+// tags carry the "user:" prefix in CUR, AWS-generated ones "aws:".
+func TagKey(key string) string {
+	if strings.HasPrefix(key, "aws:") {
+		return Name(key)
+	}
+	return Name("user:" + key)
+}
+
+// mapColumns are the CUR 2.0 columns holding a map, encoded as JSON in
+// CSV files. Legacy CUR flattens them into a column per key instead.
+var mapColumns = []string{"resource_tags", "cost_category", "product"}
+
+// columnLayout locates the CUR fields among the columns of one file.
+type columnLayout struct {
+	index      map[string]int
+	tags       map[int]string // flattened tag columns, by normalized tag key
+	categories map[int]string // flattened cost category columns
+}
+
+func newColumnLayout(columns []string) *columnLayout {
+	l := &columnLayout{
+		index:      make(map[string]int),
+		tags:       make(map[int]string),
+		categories: make(map[int]string),
+	}
+	for i, column := range columns {
+		name := Name(column)
+		switch {
+		case strings.HasPrefix(name, "resource_tags_"):
+			l.tags[i] = strings.TrimPrefix(name, "resource_tags_")
+		case strings.HasPrefix(name, "cost_category_"):
+			l.categories[i] = strings.TrimPrefix(name, "cost_category_")
+		default:
+			l.index[name] = i
+		}
+	}
+	return l
+}
+
+func (l *columnLayout) get(values []string, name string) string {
+	if i, ok := l.index[name]; ok && i < len(values) {
+		return values[i]
+	}
+	return ""
+}
+
+// record converts the values of one row into a line item.
+func (l *columnLayout) record(values []string) (Record, error) {
+	maps := make(map[string]map[string]string)
+	for _, name := range mapColumns {
+		encoded := l.get(values, name)
+		if encoded == "" {
+			continue
+		}
+		var entries map[string]string
+		if err := json.Unmarshal([]byte(encoded), &entries); err != nil {
+			return Record{}, fmt.Errorf("invalid %s value %q: %v", name, encoded, err)
+		}
+		maps[name] = entries
+	}
+
+	// Product attributes are columns of their own in legacy CUR and partly
+	// live in the product map in CUR 2.0
+	product := func(name string) string {
+		if value := l.get(values, "product_"+name); value != "" {
+			return value
+		}
+		return maps["product"][name]
+	}
+
+	var err error
+	amount := func(name string, fallback float64) float64 {
+		value := l.get(values, name)
+		if value == "" || err != nil {
+			return fallback
+		}
+		var parsed float64
+		parsed, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			err = fmt.Errorf("invalid %s value %q", name, value)
+		}
+		return parsed
+	}
+
+	r := Record{
+		Payer:        l.get(values, "bill_payer_account_id"),
+		Account:      l.get(values, "line_item_usage_account_id"),
+		Region:       product("region_code"),
+		UsageType:    l.get(values, "line_item_usage_type"),
+		RecordType:   l.get(values, "line_item_line_item_type"),
+		ResourceID:   l.get(values, "line_item_resource_id"),
+		InstanceType: product("instance_type"),
+	}
+	if r.Region == "" {
+		r.Region = product("region")
+	}
+	r.Start, err = parseTime(l.get(values, "line_item_usage_start_date"))
+	if err != nil {
+		return r, err
+	}
+
+	productName := product("product_name")
+	if productName == "" {
+		productName = l.get(values, "line_item_product_code")
+	}
+	r.Service = Service(productName, r.UsageType)
+
+	r.Unblended = amount("line_item_unblended_cost", 0)
+	r.Blended = amount("line_item_blended_cost", r.Unblended)
+	r.NetUnblended = amount("line_item_net_unblended_cost", r.Unblended)
+	r.Usage = amount("line_item_usage_amount", 0)
+
+	// Amortized cost spreads commitments over the usage they cover, the
+	// way Cost Explorer computes AmortizedCost from the same columns
+	switch r.RecordType {
+	case "SavingsPlanCoveredUsage":
+		r.Amortized = amount("savings_plan_savings_plan_effective_cost", 0)
+		r.NetAmortized = amount("savings_plan_net_savings_plan_effective_cost", r.Amortized)
+	case "DiscountedUsage":
+		r.Amortized = amount("reservation_effective_cost", 0)
+		r.NetAmortized = amount("reservation_net_effective_cost", r.Amortized)
+	case "SavingsPlanNegation", "SavingsPlanUpfrontFee":
+		// Already counted in the covered usage
+	case "SavingsPlanRecurringFee":
+		r.Amortized = amount("savings_plan_total_commitment_to_date", 0) - amount("savings_plan_used_commitment", 0)
+		r.NetAmortized = r.Amortized
+	case "RIFee":
+		r.Amortized = amount("reservation_unused_amortized_upfront_fee_for_billing_period", 0) +
+			amount("reservation_unused_recurring_fee", 0)
+		r.NetAmortized = amount("reservation_net_unused_amortized_upfront_fee_for_billing_period", 0) +
+			amount("reservation_net_unused_recurring_fee", 0)
+		if l.get(values, "reservation_net_unused_recurring_fee") == "" {
+			r.NetAmortized = r.Amortized
+		}
+	case "Fee":
+		// Upfront reservation fees are amortized into the usage
+		if l.get(values, "reservation_reservation_a_r_n") == "" {
+			r.Amortized = r.Unblended
+			r.NetAmortized = r.NetUnblended
+		}
+	default:
+		r.Amortized = r.Unblended
+		r.NetAmortized = r.NetUnblended
+	}
+	if err != nil {
+		return r, err
+	}
+
+	r.Tags = l.labels(values, l.tags, maps["resource_tags"])
+	r.CostCategories = l.labels(values, l.categories, maps["cost_category"])
+	return r, nil
+}
+
+// labels gathers non-empty tag or cost category values, from flattened
+// columns or from a CUR 2.0 map, keyed by their normalized names.
+func (l *columnLayout) labels(values []string, columns map[int]string, entries map[string]string) map[string]string {
+	labels := make(map[string]string)
+	for i, key := range columns {
+		if i < len(values) && values[i] != "" {
+			labels[key] = values[i]
+		}
+	}
+	for key, value := range entries {
+		if value != "" {
+			labels[Name(key)] = value
+		}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+// timeLayouts are the timestamp formats of CUR exports: legacy CSV uses
+// RFC 3339, CUR 2.0 CSV a space-separated form.
+var timeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", dateLayout}
+
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid usage start date %q", value)
+}
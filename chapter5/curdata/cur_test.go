@@ -0,0 +1,259 @@
+package curdata
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeGzip(t *testing.T, path, content string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	if _, err := gz.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func date(value string) time.Time {
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// legacyCSV is a legacy CUR file with flattened tag and cost category
+// columns.
+const legacyCSV = `identity/LineItemId,bill/PayerAccountId,lineItem/UsageAccountId,lineItem/LineItemType,lineItem/UsageStartDate,lineItem/ProductCode,lineItem/UsageType,lineItem/ResourceId,lineItem/UsageAmount,lineItem/UnblendedCost,product/ProductName,product/region,product/instanceType,resourceTags/user:Project,costCategory/Team
+1,999999999999,111111111111,Usage,2024-05-01T00:00:00Z,AmazonEC2,USE1-BoxUsage:t3.micro,i-1,24,0.25,Amazon Elastic Compute Cloud,us-east-1,t3.micro,web,platform
+2,999999999999,111111111111,Usage,2024-05-02T00:00:00Z,AmazonEC2,USE1-EBS:VolumeUsage.gp3,vol-1,1,0.08,Amazon Elastic Compute Cloud,us-east-1,,web,
+3,999999999999,222222222222,Usage,2024-05-02T00:00:00Z,AmazonS3,TimedStorage-ByteHrs,bucket,10,0.5,Amazon Simple Storage Service,us-east-1,,,
+4,999999999999,111111111111,Usage,2024-06-01T00:00:00Z,AmazonEC2,USE1-BoxUsage:t3.micro,i-1,24,0.25,Amazon Elastic Compute Cloud,us-east-1,t3.micro,web,platform
+`
+
+// cur2CSV is a CUR 2.0 file with map columns encoded as JSON.
+const cur2CSV = `bill_payer_account_id,line_item_usage_account_id,line_item_line_item_type,line_item_usage_start_date,line_item_product_code,line_item_usage_type,line_item_resource_id,line_item_unblended_cost,line_item_net_unblended_cost,resource_tags,cost_category,product
+999999999999,111111111111,Usage,2024-05-03 00:00:00,AmazonEC2,BoxUsage:m5.large,i-2,2.5,2.25,"{""user_project"":""data""}","{""team"":""analytics""}","{""product_name"":""Amazon Elastic Compute Cloud"",""region"":""eu-west-1""}"
+999999999999,111111111111,Credit,2024-05-03 00:00:00,AmazonEC2,BoxUsage:m5.large,,-1,-1,,,"{""product_name"":""Amazon Elastic Compute Cloud""}"
+`
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "legacy", "report.csv"), legacyCSV)
+	writeGzip(t, filepath.Join(dir, "report-00001.csv.gz"), cur2CSV)
+
+	data, err := Load(dir, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.records) != 6 {
+		t.Fatalf("loaded %d line items, want 6", len(data.records))
+	}
+	if data.Payer() != "999999999999" {
+		t.Errorf("payer = %q", data.Payer())
+	}
+
+	byResource := make(map[string]Record)
+	for _, r := range data.records {
+		if r.ResourceID != "" && r.Start.Month() == time.May {
+			byResource[r.ResourceID] = r
+		}
+	}
+	instance := byResource["i-1"]
+	if instance.Service != EC2ComputeService || instance.Region != "us-east-1" || instance.InstanceType != "t3.micro" ||
+		instance.Unblended != 0.25 || instance.Amortized != 0.25 || instance.Usage != 24 {
+		t.Errorf("legacy instance = %+v", instance)
+	}
+	if instance.Tags["user_project"] != "web" || instance.CostCategories["team"] != "platform" {
+		t.Errorf("legacy labels = %v, %v", instance.Tags, instance.CostCategories)
+	}
+	if volume := byResource["vol-1"]; volume.Service != EC2OtherService || volume.CostCategories != nil {
+		t.Errorf("legacy volume = %+v", volume)
+	}
+	if bucket := byResource["bucket"]; bucket.Service != S3Service || bucket.Account != "222222222222" {
+		t.Errorf("legacy bucket = %+v", bucket)
+	}
+	cur2 := byResource["i-2"]
+	if cur2.Service != EC2ComputeService || cur2.Region != "eu-west-1" || cur2.NetUnblended != 2.25 ||
+		!cur2.Start.Equal(date("2024-05-03")) {
+		t.Errorf("CUR 2.0 instance = %+v", cur2)
+	}
+	if cur2.Tags["user_project"] != "data" || cur2.CostCategories["team"] != "analytics" {
+		t.Errorf("CUR 2.0 labels = %v, %v", cur2.Tags, cur2.CostCategories)
+	}
+}
+
+func TestLoadFilter(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "report.csv"), legacyCSV)
+	may := Period{Start: date("2024-05-01"), End: date("2024-06-01")}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string // line item resource IDs, in file order
+	}{
+		{"everything", Filter{}, []string{"i-1", "vol-1", "bucket", "i-1"}},
+		{"period", Filter{Periods: []Period{may}}, []string{"i-1", "vol-1", "bucket"}},
+		{"end is exclusive", Filter{Periods: []Period{{Start: date("2024-05-02"), End: date("2024-06-01")}}}, []string{"vol-1", "bucket"}},
+		{"several periods", Filter{Periods: []Period{{Start: date("2024-05-01"), End: date("2024-05-02")}, {Start: date("2024-06-01"), End: date("2024-06-02")}}}, []string{"i-1", "i-1"}},
+		{"account", Filter{Accounts: []string{"222222222222"}}, []string{"bucket"}},
+		{"service", Filter{Services: []string{EC2OtherService, S3Service}}, []string{"vol-1", "bucket"}},
+		{"all at once", Filter{Periods: []Period{may}, Accounts: []string{"111111111111"}, Services: []string{EC2ComputeService}}, []string{"i-1"}},
+		{"nothing", Filter{Accounts: []string{"333333333333"}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Load(dir, tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range data.records {
+				got = append(got, r.ResourceID)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("kept %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("kept %v, want %v", got, tt.want)
+					break
+				}
+			}
+			// The payer is known even when its line items are filtered out
+			if data.Payer() != "999999999999" {
+				t.Errorf("payer = %q", data.Payer())
+			}
+		})
+	}
+}
+
+func TestLoadEmpty(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "notes.txt"), "not a report")
+	if _, err := Load(dir, Filter{}); err == nil {
+		t.Error("a directory without line items loaded")
+	}
+}
+
+func TestLoadSkipsStaleAssemblies(t *testing.T) {
+	dir := t.TempDir()
+	period := filepath.Join(dir, "20240501-20240601")
+	writeFile(t, filepath.Join(period, "report-Manifest.json"), `{"assemblyId": "new"}`)
+	writeFile(t, filepath.Join(period, "old", "report-Manifest.json"), `{"assemblyId": "old"}`)
+	writeFile(t, filepath.Join(period, "old", "report.csv"), legacyCSV)
+	writeFile(t, filepath.Join(period, "new", "report-Manifest.json"), `{"assemblyId": "new"}`)
+	writeFile(t, filepath.Join(period, "new", "report.csv"), legacyCSV)
+
+	data, err := Load(dir, Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.records) != 4 {
+		t.Errorf("loaded %d line items, want the 4 of the current assembly", len(data.records))
+	}
+}
+
+func TestName(t *testing.T) {
+	tests := map[string]string{
+		"lineItem/UnblendedCost":       "line_item_unblended_cost",
+		"resourceTags/user:CostCenter": "resource_tags_user_cost_center",
+		"line_item_usage_start_date":   "line_item_usage_start_date",
+		"product/instanceType":         "product_instance_type",
+	}
+	for column, want := range tests {
+		if got := Name(column); got != want {
+			t.Errorf("Name(%q) = %q, want %q", column, got, want)
+		}
+	}
+	if got := TagKey("Project"); got != "user_project" {
+		t.Errorf("TagKey(Project) = %q", got)
+	}
+	if got := TagKey("aws:createdBy"); got != "aws_created_by" {
+		t.Errorf("TagKey(aws:createdBy) = %q", got)
+	}
+}
+
+func TestService(t *testing.T) {
+	tests := []struct {
+		product, usageType, want string
+	}{
+		{"Amazon Elastic Compute Cloud", "EUC1-BoxUsage:t3.medium", EC2ComputeService},
+		{"AmazonEC2", "HeavyUsage:m5.large", EC2ComputeService},
+		{"Amazon Elastic Compute Cloud", "EUC1-EBS:VolumeUsage.gp3", EC2OtherService},
+		{"AmazonEC2", "NatGateway-Hours", EC2OtherService},
+		{"AmazonVPC", "PublicIPv4:InUseAddress", VPCService},
+		{"AWSELB", "LoadBalancerUsage", ELBService},
+		{"AmazonRDS", "InstanceUsage:db.t3.micro", RDSService},
+		{"AWSLambda", "Lambda-GB-Second", LambdaService},
+		{"AmazonS3", "TimedStorage-ByteHrs", S3Service},
+		{"Amazon DynamoDB", "ReadCapacityUnit-Hrs", "Amazon DynamoDB"},
+	}
+	for _, tt := range tests {
+		if got := Service(tt.product, tt.usageType); got != tt.want {
+			t.Errorf("Service(%q, %q) = %q, want %q", tt.product, tt.usageType, got, tt.want)
+		}
+	}
+}
+
+func TestTrimUsageRegion(t *testing.T) {
+	tests := []struct {
+		usageType, want string
+	}{
+		{"EUC1-BoxUsage:t3.medium", "BoxUsage:t3.medium"},
+		{"USE1-EUC1-AWS-Out-Bytes", "EUC1-AWS-Out-Bytes"},
+		{"EU-SpotUsage:m5.large", "SpotUsage:m5.large"},
+		{"APS3-EBS:VolumeUsage.gp3", "EBS:VolumeUsage.gp3"},
+		{"BoxUsage:t3.micro", "BoxUsage:t3.micro"},
+		{"DataTransfer-Out-Bytes", "DataTransfer-Out-Bytes"},
+		{"NatGateway-Hours", "NatGateway-Hours"},
+		{"TimedStorage-ByteHrs", "TimedStorage-ByteHrs"},
+		{"Lambda-GB-Second", "Lambda-GB-Second"},
+		{"EBS:SnapshotUsage", "EBS:SnapshotUsage"},
+	}
+	for _, tt := range tests {
+		if got := TrimUsageRegion(tt.usageType); got != tt.want {
+			t.Errorf("TrimUsageRegion(%q) = %q, want %q", tt.usageType, got, tt.want)
+		}
+	}
+}
+
+func TestIsComputeUsage(t *testing.T) {
+	tests := []struct {
+		usageType string
+		want      bool
+	}{
+		{"BoxUsage:t3.micro", true},
+		{"EUW2-SpotUsage:c5.large", true},
+		{"USE2-CPUCredits:t3", true},
+		{"DataTransfer-Out-Bytes", false},
+		{"EUC1-EBS:VolumeUsage.gp3", false},
+		{"NatGateway-Hours", false},
+	}
+	for _, tt := range tests {
+		if got := IsComputeUsage(tt.usageType); got != tt.want {
+			t.Errorf("IsComputeUsage(%q) = %v, want %v", tt.usageType, got, tt.want)
+		}
+	}
+}
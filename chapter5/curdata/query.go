@@ -0,0 +1,303 @@
+package curdata
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+// Period is a time period; End is exclusive.
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (p Period) contains(t time.Time) bool {
+	return !t.Before(p.Start) && t.Before(p.End)
+}
+
+// splitPeriod splits a time period the way Cost Explorer does: by day, or by
+// calendar month with the first and last months cut to the period.
+func splitPeriod(interval *types.DateInterval, granularity types.Granularity) ([]Period, error) {
+	if interval == nil {
+		return nil, fmt.Errorf("a time period is required")
+	}
+	start, err := time.Parse(dateLayout, aws.ToString(interval.Start))
+	if err != nil {
+		return nil, fmt.Errorf("invalid start date %q: %v", aws.ToString(interval.Start), err)
+	}
+	end, err := time.Parse(dateLayout, aws.ToString(interval.End))
+	if err != nil {
+		return nil, fmt.Errorf("invalid end date %q: %v", aws.ToString(interval.End), err)
+	}
+
+	var periods []Period
+	for day := start; day.Before(end); {
+		var next time.Time
+		switch granularity {
+		case types.GranularityDaily:
+			next = day.AddDate(0, 0, 1)
+		case types.GranularityMonthly:
+			next = time.Date(day.Year(), day.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		default:
+			return nil, fmt.Errorf("granularity %s is not supported with CUR data", granularity)
+		}
+		if next.After(end) {
+			next = end
+		}
+		periods = append(periods, Period{Start: day, End: next})
+		day = next
+	}
+	return periods, nil
+}
+
+// resultGroup accumulates the metrics of one group in one period.
+type resultGroup struct {
+	keys    []string
+	amounts []float64
+}
+
+// CostAndUsage evaluates a Cost Explorer request against the line items.
+// A non-empty account limits them to that linked account, like the Cost
+// Explorer view of a member account. Everything is returned in one page.
+func (d *Data) CostAndUsage(interval *types.DateInterval, granularity types.Granularity, metrics []string, filter *types.Expression, groupBy []types.GroupDefinition, account string) ([]types.ResultByTime, error) {
+	periods, err := splitPeriod(interval, granularity)
+	if err != nil {
+		return nil, err
+	}
+	for _, metric := range metrics {
+		if _, ok := (&Record{}).metric(metric); !ok {
+			return nil, fmt.Errorf("metric %s is not supported with CUR data", metric)
+		}
+	}
+	if len(periods) == 0 {
+		return nil, nil
+	}
+
+	groups := make([]map[string]*resultGroup, len(periods))
+	totals := make([][]float64, len(periods))
+	for p := range periods {
+		groups[p] = make(map[string]*resultGroup)
+		totals[p] = make([]float64, len(metrics))
+	}
+
+	start, end := periods[0].Start, periods[len(periods)-1].End
+	for i := range d.records {
+		r := &d.records[i]
+		if r.Start.Before(start) || !r.Start.Before(end) || (account != "" && r.Account != account) {
+			continue
+		}
+		if filter != nil {
+			ok, err := r.matches(filter)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		p := sort.Search(len(periods), func(j int) bool { return periods[j].End.After(r.Start) })
+		keys := make([]string, len(groupBy))
+		for g, group := range groupBy {
+			if keys[g], err = r.groupKey(group); err != nil {
+				return nil, err
+			}
+		}
+		id := strings.Join(keys, "\x00")
+		group := groups[p][id]
+		if group == nil {
+			group = &resultGroup{keys: keys, amounts: make([]float64, len(metrics))}
+			groups[p][id] = group
+		}
+		for m, metric := range metrics {
+			amount, _ := r.metric(metric)
+			group.amounts[m] += amount
+			totals[p][m] += amount
+		}
+	}
+
+	results := make([]types.ResultByTime, len(periods))
+	for p, period := range periods {
+		results[p] = types.ResultByTime{
+			TimePeriod: &types.DateInterval{
+				Start: aws.String(period.Start.Format(dateLayout)),
+				End:   aws.String(period.End.Format(dateLayout)),
+			},
+		}
+		if len(groupBy) == 0 {
+			results[p].Total = metricValues(metrics, totals[p])
+			continue
+		}
+
+		ids := make([]string, 0, len(groups[p]))
+		for id := range groups[p] {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			group := groups[p][id]
+			results[p].Groups = append(results[p].Groups, types.Group{
+				Keys:    group.keys,
+				Metrics: metricValues(metrics, group.amounts),
+			})
+		}
+	}
+	return results, nil
+}
+
+func metricValues(metrics []string, amounts []float64) map[string]types.MetricValue {
+	values := make(map[string]types.MetricValue, len(metrics))
+	for m, metric := range metrics {
+		unit := "USD"
+		if metric == UsageMetric {
+			unit = "N/A"
+		}
+		values[metric] = types.MetricValue{
+			Amount: aws.String(strconv.FormatFloat(amounts[m], 'f', -1, 64)),
+			Unit:   aws.String(unit),
+		}
+	}
+	return values
+}
+
+// metric returns the amount of a Cost Explorer metric.
+func (r *Record) metric(name string) (float64, bool) {
+	switch name {
+	case "UnblendedCost":
+		return r.Unblended, true
+	case "BlendedCost":
+		return r.Blended, true
+	case "NetUnblendedCost":
+		return r.NetUnblended, true
+	case "AmortizedCost":
+		return r.Amortized, true
+	case "NetAmortizedCost":
+		return r.NetAmortized, true
+	case UsageMetric:
+		return r.Usage, true
+	}
+	return 0, false
+}
+
+// dimension returns the value of a Cost Explorer dimension.
+func (r *Record) dimension(key types.Dimension) (string, error) {
+	switch key {
+	case types.DimensionService:
+		return r.Service, nil
+	case types.DimensionLinkedAccount:
+		return r.Account, nil
+	case types.DimensionRegion:
+		return r.Region, nil
+	case types.DimensionUsageType:
+		return r.UsageType, nil
+	case types.DimensionResourceId:
+		return r.ResourceID, nil
+	case types.DimensionRecordType:
+		return r.RecordType, nil
+	case types.DimensionInstanceType:
+		return r.InstanceType, nil
+	}
+	return "", fmt.Errorf("dimension %s is not supported with CUR data", key)
+}
+
+// groupKey returns the key of the group a line item falls in. Tag and cost
+// category keys read "Key$value", with an empty value when unset.
+func (r *Record) groupKey(group types.GroupDefinition) (string, error) {
+	key := aws.ToString(group.Key)
+	switch group.Type {
+	case types.GroupDefinitionTypeDimension:
+		return r.dimension(types.Dimension(key))
+	case types.GroupDefinitionTypeTag:
+		return key + "$" + r.Tags[TagKey(key)], nil
+	case types.GroupDefinitionTypeCostCategory:
+		return key + "$" + r.CostCategories[Name(key)], nil
+	}
+	return "", fmt.Errorf("group type %s is not supported with CUR data", group.Type)
+}
+
+// matches evaluates a Cost Explorer filter expression.
+func (r *Record) matches(e *types.Expression) (bool, error) {
+	switch {
+	case len(e.And) > 0:
+		for i := range e.And {
+			if ok, err := r.matches(&e.And[i]); err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case len(e.Or) > 0:
+		for i := range e.Or {
+			if ok, err := r.matches(&e.Or[i]); err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case e.Not != nil:
+		ok, err := r.matches(e.Not)
+		return !ok, err
+	case e.Dimensions != nil:
+		value, err := r.dimension(e.Dimensions.Key)
+		if err != nil {
+			return false, err
+		}
+		return matchValue(value, e.Dimensions.Values, e.Dimensions.MatchOptions), nil
+	case e.Tags != nil:
+		value := r.Tags[TagKey(aws.ToString(e.Tags.Key))]
+		return matchValue(value, e.Tags.Values, e.Tags.MatchOptions), nil
+	case e.CostCategories != nil:
+		value := r.CostCategories[Name(aws.ToString(e.CostCategories.Key))]
+		return matchValue(value, e.CostCategories.Values, e.CostCategories.MatchOptions), nil
+	}
+	return true, nil
+}
+
+// matchValue applies Cost Explorer match options; the default is EQUALS.
+func matchValue(value string, values []string, options []types.MatchOption) bool {
+	mode := types.MatchOptionEquals
+	caseSensitive := true
+	for _, option := range options {
+		switch option {
+		case types.MatchOptionAbsent:
+			return value == ""
+		case types.MatchOptionCaseInsensitive:
+			caseSensitive = false
+		case types.MatchOptionCaseSensitive:
+		default:
+			mode = option
+		}
+	}
+	if !caseSensitive {
+		value = strings.ToLower(value)
+	}
+
+	for _, candidate := range values {
+		if !caseSensitive {
+			candidate = strings.ToLower(candidate)
+		}
+		switch mode {
+		case types.MatchOptionStartsWith:
+			if strings.HasPrefix(value, candidate) {
+				return true
+			}
+		case types.MatchOptionEndsWith:
+			if strings.HasSuffix(value, candidate) {
+				return true
+			}
+		case types.MatchOptionContains:
+			if strings.Contains(value, candidate) {
+				return true
+			}
+		default:
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package curdata
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/costexplorer/types"
+)
+
+func TestSplitPeriod(t *testing.T) {
+	tests := []struct {
+		name        string
+		start, end  string
+		granularity types.Granularity
+		want        []string // start dates
+		last        string   // end of the last period
+	}{
+		{"days", "2024-05-30", "2024-06-02", types.GranularityDaily, []string{"2024-05-30", "2024-05-31", "2024-06-01"}, "2024-06-02"},
+		{"whole months", "2024-04-01", "2024-06-01", types.GranularityMonthly, []string{"2024-04-01", "2024-05-01"}, "2024-06-01"},
+		{"partial months", "2024-04-15", "2024-06-10", types.GranularityMonthly, []string{"2024-04-15", "2024-05-01", "2024-06-01"}, "2024-06-10"},
+		{"across a year", "2023-12-20", "2024-01-05", types.GranularityMonthly, []string{"2023-12-20", "2024-01-01"}, "2024-01-05"},
+		{"empty", "2024-05-01", "2024-05-01", types.GranularityDaily, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			periods, err := splitPeriod(&types.DateInterval{Start: aws.String(tt.start), End: aws.String(tt.end)}, tt.granularity)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(periods) != len(tt.want) {
+				t.Fatalf("got %d periods %v, want %v", len(periods), periods, tt.want)
+			}
+			for i, p := range periods {
+				if got := p.Start.Format(dateLayout); got != tt.want[i] {
+					t.Errorf("period %d starts %s, want %s", i, got, tt.want[i])
+				}
+				if i > 0 && !periods[i-1].End.Equal(p.Start) {
+					t.Errorf("period %d does not start where the previous one ends", i)
+				}
+			}
+			if len(periods) > 0 {
+				if got := periods[len(periods)-1].End.Format(dateLayout); got != tt.last {
+					t.Errorf("last period ends %s, want %s", got, tt.last)
+				}
+			}
+		})
+	}
+
+	for _, bad := range []struct {
+		interval    *types.DateInterval
+		granularity types.Granularity
+	}{
+		{nil, types.GranularityDaily},
+		{&types.DateInterval{Start: aws.String("May 1"), End: aws.String("2024-06-01")}, types.GranularityDaily},
+		{&types.DateInterval{Start: aws.String("2024-05-01"), End: aws.String("2024-06-01")}, types.GranularityHourly},
+	} {
+		if _, err := splitPeriod(bad.interval, bad.granularity); err == nil {
+			t.Errorf("splitPeriod(%v, %s) succeeded", bad.interval, bad.granularity)
+		}
+	}
+}
+
+func TestMatchValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		values  []string
+		options []types.MatchOption
+		want    bool
+	}{
+		{"equals by default", "web", []string{"data", "web"}, nil, true},
+		{"equals is case sensitive", "Web", []string{"web"}, nil, false},
+		{"case insensitive", "Web", []string{"web"}, []types.MatchOption{types.MatchOptionCaseInsensitive}, true},
+		{"starts with", "web-prod", []string{"web"}, []types.MatchOption{types.MatchOptionStartsWith}, true},
+		{"ends with", "web-prod", []string{"prod"}, []types.MatchOption{types.MatchOptionEndsWith}, true},
+		{"contains case insensitive", "WEB-prod", []string{"b-P"}, []types.MatchOption{types.MatchOptionContains, types.MatchOptionCaseInsensitive}, true},
+		{"absent", "", nil, []types.MatchOption{types.MatchOptionAbsent}, true},
+		{"absent with a value", "web", nil, []types.MatchOption{types.MatchOptionAbsent}, false},
+		{"no candidates", "web", nil, nil, false},
+	}
+	for _, tt := range tests {
+		if got := matchValue(tt.value, tt.values, tt.options); got != tt.want {
+			t.Errorf("%s: matchValue(%q, %q) = %v, want %v", tt.name, tt.value, tt.values, got, tt.want)
+		}
+	}
+}
+
+// queryData holds line items of two accounts over two months.
+func queryData() *Data {
+	record := func(start, account, service, project string, cost float64) Record {
+		r := Record{Start: date(start), Account: account, Service: service, Unblended: cost, Amortized: cost, RecordType: "Usage"}
+		if project != "" {
+			r.Tags = map[string]string{"user_project": project}
+		}
+		return r
+	}
+	return &Data{records: []Record{
+		record("2024-05-01", "111111111111", EC2ComputeService, "web", 10),
+		record("2024-05-02", "111111111111", EC2ComputeService, "data", 20),
+		record("2024-05-03", "111111111111", S3Service, "web", 1),
+		record("2024-05-03", "222222222222", S3Service, "", 2),
+		record("2024-06-01", "111111111111", EC2ComputeService, "web", 40),
+	}}
+}
+
+func TestCostAndUsage(t *testing.T) {
+	interval := &types.DateInterval{Start: aws.String("2024-05-01"), End: aws.String("2024-07-01")}
+	service := types.GroupDefinition{Type: types.GroupDefinitionTypeDimension, Key: aws.String("SERVICE")}
+	project := types.GroupDefinition{Type: types.GroupDefinitionTypeTag, Key: aws.String("Project")}
+	dimension := func(key types.Dimension, values ...string) *types.Expression {
+		return &types.Expression{Dimensions: &types.DimensionValues{Key: key, Values: values}}
+	}
+	tag := func(key string, values ...string) *types.Expression {
+		return &types.Expression{Tags: &types.TagValues{Key: aws.String(key), Values: values}}
+	}
+
+	tests := []struct {
+		name    string
+		filter  *types.Expression
+		groupBy []types.GroupDefinition
+		account string
+		want    []map[string]string // amount by joined group keys, per month
+	}{
+		{"totals", nil, nil, "",
+			[]map[string]string{{"": "33"}, {"": "40"}}},
+		{"by service", nil, []types.GroupDefinition{service}, "",
+			[]map[string]string{{EC2ComputeService: "30", S3Service: "3"}, {EC2ComputeService: "40"}}},
+		{"by tag, untagged with an empty value", nil, []types.GroupDefinition{project}, "",
+			[]map[string]string{{"Project$web": "11", "Project$data": "20", "Project$": "2"}, {"Project$web": "40"}}},
+		{"account view", nil, []types.GroupDefinition{service}, "222222222222",
+			[]map[string]string{{S3Service: "2"}, {}}},
+		{"dimension filter", dimension(types.DimensionService, S3Service), []types.GroupDefinition{project}, "",
+			[]map[string]string{{"Project$web": "1", "Project$": "2"}, {}}},
+		{"and", &types.Expression{And: []types.Expression{*dimension(types.DimensionService, EC2ComputeService), *tag("Project", "web")}}, nil, "",
+			[]map[string]string{{"": "10"}, {"": "40"}}},
+		{"or", &types.Expression{Or: []types.Expression{*dimension(types.DimensionLinkedAccount, "222222222222"), *tag("Project", "data")}}, nil, "",
+			[]map[string]string{{"": "22"}, {"": "0"}}},
+		{"not", &types.Expression{Not: tag("Project", "web")}, nil, "",
+			[]map[string]string{{"": "22"}, {"": "0"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := queryData().CostAndUsage(interval, types.GranularityMonthly, []string{"UnblendedCost"}, tt.filter, tt.groupBy, tt.account)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(tt.want) {
+				t.Fatalf("got %d periods, want %d", len(results), len(tt.want))
+			}
+			for p, result := range results {
+				got := make(map[string]string)
+				if len(tt.groupBy) == 0 {
+					got[""] = aws.ToString(result.Total["UnblendedCost"].Amount)
+				}
+				for _, group := range result.Groups {
+					got[group.Keys[0]] = aws.ToString(group.Metrics["UnblendedCost"].Amount)
+				}
+				if len(got) != len(tt.want[p]) {
+					t.Errorf("period %d = %v, want %v", p, got, tt.want[p])
+					continue
+				}
+				for key, amount := range tt.want[p] {
+					if got[key] != amount {
+						t.Errorf("period %d %q = %s, want %s", p, key, got[key], amount)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCostAndUsageUnsupported(t *testing.T) {
+	interval := &types.DateInterval{Start: aws.String("2024-05-01"), End: aws.String("2024-06-01")}
+	data := queryData()
+	if _, err := data.CostAndUsage(interval, types.GranularityMonthly, []string{"NormalizedUsageAmount"}, nil, nil, ""); err == nil {
+		t.Error("unsupported metric accepted")
+	}
+	groupBy := []types.GroupDefinition{{Type: types.GroupDefinitionTypeDimension, Key: aws.String("OPERATING_SYSTEM")}}
+	if _, err := data.CostAndUsage(interval, types.GranularityMonthly, []string{"UnblendedCost"}, nil, groupBy, ""); err == nil {
+		t.Error("unsupported dimension accepted")
+	}
+}
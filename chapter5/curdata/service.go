@@ -0,0 +1,88 @@
+package curdata
+
+import "strings"
+
+// Cost Explorer names of the services the tools look up
+const (
+	EC2ComputeService = "Amazon Elastic Compute Cloud - Compute"
+	// EBS, data transfer and Elastic IP charges are billed under EC2 - Other
+	EC2OtherService = "EC2 - Other"
+	VPCService      = "Amazon Virtual Private Cloud"
+	ELBService      = "Amazon Elastic Load Balancing"
+	RDSService      = "Amazon Relational Database Service"
+	LambdaService   = "AWS Lambda"
+	S3Service       = "Amazon Simple Storage Service"
+)
+
+// UsageMetric is the Cost Explorer metric of usage quantities, which is
+// summed per usage type, never across them.
+const UsageMetric = "UsageQuantity"
+
+const dateLayout = "2006-01-02"
+
+// Service names the service of a line item as Cost Explorer does. CUR bills
+// all of EC2 under one product, which Cost Explorer splits into instance
+// usage and everything else. Product codes stand in for missing product
+// names.
+func Service(productName, usageType string) string {
+	switch productName {
+	case "Amazon Elastic Compute Cloud", "AmazonEC2":
+		if IsComputeUsage(usageType) || strings.HasPrefix(TrimUsageRegion(usageType), "HeavyUsage") {
+			return EC2ComputeService
+		}
+		return EC2OtherService
+	case "AmazonVPC":
+		return VPCService
+	case "Elastic Load Balancing", "AWSELB":
+		return ELBService
+	case "AmazonRDS":
+		return RDSService
+	case "AWSLambda":
+		return LambdaService
+	case "AmazonS3":
+		return S3Service
+	}
+	return productName
+}
+
+// computeUsage are the usage type prefixes of EC2 compute, as Cost
+// Explorer's USAGE_TYPE_GROUP buckets them.
+var computeUsage = []string{
+	"BoxUsage", "SpotUsage", "DedicatedUsage", "HostUsage", "HostBoxUsage", "ReservedHostUsage",
+	"SchedUsage", "UnusedBox", "CPUCredits", "EBSOptimized",
+}
+
+// IsComputeUsage reports whether a usage type such as
+// "EUC1-BoxUsage:t3.medium" is EC2 compute.
+func IsComputeUsage(usageType string) bool {
+	usageType = TrimUsageRegion(usageType)
+	for _, prefix := range computeUsage {
+		if strings.HasPrefix(usageType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// usageRegions are the region prefixes of usage types, e.g. "EUC1" in
+// "EUC1-BoxUsage:t3.medium". Most us-east-1 usage types have none, and
+// eu-west-1 ones use "EU".
+var usageRegions = map[string]bool{
+	"USE1": true, "USE2": true, "USW1": true, "USW2": true, "UGE1": true, "UGW1": true,
+	"CAN1": true, "CAW1": true, "MXC1": true, "SAE1": true,
+	"EU": true, "EUW2": true, "EUW3": true, "EUC1": true, "EUC2": true,
+	"EUN1": true, "EUS1": true, "EUS2": true,
+	"AFS1": true, "MES1": true, "MEC1": true, "ILC1": true,
+	"APE1": true, "APN1": true, "APN2": true, "APN3": true, "APS1": true, "APS2": true,
+	"APS3": true, "APS4": true, "APS5": true, "APS6": true, "APS7": true,
+}
+
+// TrimUsageRegion drops the region prefix of a usage type, e.g. "EUC1-".
+// Usage types without a known region prefix, such as the us-east-1
+// "DataTransfer-Out-Bytes", are returned as is.
+func TrimUsageRegion(usageType string) string {
+	if prefix, rest, ok := strings.Cut(usageType, "-"); ok && usageRegions[prefix] {
+		return rest
+	}
+	return usageType
+}
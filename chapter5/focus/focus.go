@@ -0,0 +1,212 @@
+package focus
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Row is one cost record in the FinOps Open Cost and Usage
+// Specification (FOCUS) 1.0. Empty strings and nil pointers are nulls;
+// fields are written under their FOCUS column names.
+type Row struct {
+	BilledCost         float64
+	BillingAccountID   string
+	BillingAccountName string
+	BillingCurrency    string
+	BillingPeriodStart time.Time
+	BillingPeriodEnd   time.Time
+	ChargeCategory     string
+	ChargeClass        string
+	ChargeDescription  string
+	ChargePeriodStart  time.Time
+	ChargePeriodEnd    time.Time
+	ContractedCost     float64
+	EffectiveCost      float64
+	InvoiceIssuerName  string
+	ListCost           float64
+	ListUnitPrice      *float64
+	PricingQuantity    *float64
+	PricingUnit        string
+	ProviderName       string
+	PublisherName      string
+	RegionID           string
+	RegionName         string
+	AvailabilityZone   string
+	ResourceID         string
+	ResourceName       string
+	ResourceType       string
+	ServiceCategory    string
+	ServiceName        string
+	SubAccountID       string
+	SubAccountName     string
+	Tags               map[string]string
+}
+
+// FOCUS column types
+const (
+	kindDecimal  = "Decimal"
+	kindDateTime = "DateTime"
+	kindString   = "String"
+	kindCurrency = "Currency"
+	kindJSON     = "JSON"
+)
+
+// column describes one FOCUS column: its data type, whether the spec
+// requires it in every dataset, whether it may be null, and the values it
+// is restricted to, if any.
+type column struct {
+	name      string
+	kind      string
+	mandatory bool
+	nullable  bool
+	allowed   []string
+	value     func(Row) string
+}
+
+var serviceCategories = []string{
+	"AI and Machine Learning", "Analytics", "Business Applications", "Compute", "Databases",
+	"Developer Tools", "Multicloud", "Identity", "Integration", "Internet of Things",
+	"Management and Governance", "Media", "Migration", "Mobile", "Networking", "Security",
+	"Storage", "Web", "Other",
+}
+
+// columns lists the FOCUS 1.0 columns the tools write, in output
+// order. Mandatory columns must be present even when the tools can only
+// write nulls; the others are conditional in the spec.
+var columns = []column{
+	{"BilledCost", kindDecimal, true, false, nil, func(r Row) string { return formatDecimal(r.BilledCost) }},
+	{"BillingAccountId", kindString, true, false, nil, func(r Row) string { return r.BillingAccountID }},
+	{"BillingAccountName", kindString, true, true, nil, func(r Row) string { return r.BillingAccountName }},
+	{"BillingCurrency", kindCurrency, true, false, nil, func(r Row) string { return r.BillingCurrency }},
+	{"BillingPeriodStart", kindDateTime, true, false, nil, func(r Row) string { return formatTime(r.BillingPeriodStart) }},
+	{"BillingPeriodEnd", kindDateTime, true, false, nil, func(r Row) string { return formatTime(r.BillingPeriodEnd) }},
+	{"ChargeCategory", kindString, true, false, []string{"Usage", "Purchase", "Tax", "Credit", "Adjustment"}, func(r Row) string { return r.ChargeCategory }},
+	{"ChargeClass", kindString, true, true, []string{"Correction"}, func(r Row) string { return r.ChargeClass }},
+	{"ChargeDescription", kindString, true, true, nil, func(r Row) string { return r.ChargeDescription }},
+	{"ChargePeriodStart", kindDateTime, true, false, nil, func(r Row) string { return formatTime(r.ChargePeriodStart) }},
+	{"ChargePeriodEnd", kindDateTime, true, false, nil, func(r Row) string { return formatTime(r.ChargePeriodEnd) }},
+	{"ContractedCost", kindDecimal, true, false, nil, func(r Row) string { return formatDecimal(r.ContractedCost) }},
+	{"EffectiveCost", kindDecimal, true, false, nil, func(r Row) string { return formatDecimal(r.EffectiveCost) }},
+	{"InvoiceIssuerName", kindString, true, false, nil, func(r Row) string { return r.InvoiceIssuerName }},
+	{"ListCost", kindDecimal, true, false, nil, func(r Row) string { return formatDecimal(r.ListCost) }},
+	{"ListUnitPrice", kindDecimal, false, true, nil, func(r Row) string { return formatOptionalDecimal(r.ListUnitPrice) }},
+	{"PricingQuantity", kindDecimal, true, true, nil, func(r Row) string { return formatOptionalDecimal(r.PricingQuantity) }},
+	{"PricingUnit", kindString, true, true, nil, func(r Row) string { return r.PricingUnit }},
+	{"ProviderName", kindString, true, false, nil, func(r Row) string { return r.ProviderName }},
+	{"PublisherName", kindString, true, false, nil, func(r Row) string { return r.PublisherName }},
+	{"RegionId", kindString, false, true, nil, func(r Row) string { return r.RegionID }},
+	{"RegionName", kindString, false, true, nil, func(r Row) string { return r.RegionName }},
+	{"AvailabilityZone", kindString, false, true, nil, func(r Row) string { return r.AvailabilityZone }},
+	{"ResourceId", kindString, false, true, nil, func(r Row) string { return r.ResourceID }},
+	{"ResourceName", kindString, false, true, nil, func(r Row) string { return r.ResourceName }},
+	{"ResourceType", kindString, false, true, nil, func(r Row) string { return r.ResourceType }},
+	{"ServiceCategory", kindString, true, false, serviceCategories, func(r Row) string { return r.ServiceCategory }},
+	{"ServiceName", kindString, true, false, nil, func(r Row) string { return r.ServiceName }},
+	{"SubAccountId", kindString, false, true, nil, func(r Row) string { return r.SubAccountID }},
+	{"SubAccountName", kindString, false, true, nil, func(r Row) string { return r.SubAccountName }},
+	{"Tags", kindJSON, false, true, nil, func(r Row) string { return formatTags(r.Tags) }},
+}
+
+// timeLayout is the ISO 8601 UTC form FOCUS requires for date/times.
+const timeLayout = "2006-01-02T15:04:05Z"
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+func formatDecimal(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func formatOptionalDecimal(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return formatDecimal(*value)
+}
+
+func formatTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(tags)
+	return string(data)
+}
+
+// MarshalJSON writes date/times in the FOCUS layout and nulls for empty
+// nullable strings, so JSON output validates like CSV output.
+func (r Row) MarshalJSON() ([]byte, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, c := range columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, _ := json.Marshal(c.name)
+		b.Write(name)
+		b.WriteByte(':')
+
+		value := c.value(r)
+		switch {
+		case value == "":
+			b.WriteString("null")
+		case c.kind == kindDecimal, c.kind == kindJSON:
+			b.WriteString(value)
+		default:
+			encoded, _ := json.Marshal(value)
+			b.Write(encoded)
+		}
+	}
+	b.WriteByte('}')
+	return []byte(b.String()), nil
+}
+
+// Formats are the output formats of FOCUS rows.
+var Formats = []string{"csv", "json", "ndjson"}
+
+// Write writes FOCUS rows as CSV, a JSON array or NDJSON.
+func Write(format string, w io.Writer, rows []Row) error {
+	switch format {
+	case "csv":
+		writer := csv.NewWriter(w)
+		record := make([]string, len(columns))
+		for i, c := range columns {
+			record[i] = c.name
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			for i, c := range columns {
+				record[i] = c.value(row)
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case "json":
+		if rows == nil {
+			rows = []Row{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(rows)
+	case "ndjson":
+		encoder := json.NewEncoder(w)
+		for _, row := range rows {
+			if err := encoder.Encode(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported FOCUS format %q, expected one of %s", format, strings.Join(Formats, ", "))
+	}
+}
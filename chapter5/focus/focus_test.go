@@ -0,0 +1,133 @@
+package focus
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fixture() []Row {
+	quantity := 24.0
+	return []Row{{
+		BilledCost:         2.5,
+		BillingAccountID:   "111111111111",
+		BillingAccountName: "payer",
+		BillingCurrency:    "USD",
+		BillingPeriodStart: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		BillingPeriodEnd:   time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+		ChargeCategory:     "Usage",
+		ChargePeriodStart:  time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		ChargePeriodEnd:    time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC),
+		ContractedCost:     2.5,
+		EffectiveCost:      2.4,
+		InvoiceIssuerName:  "Amazon Web Services",
+		ListCost:           2.5,
+		PricingQuantity:    &quantity,
+		ProviderName:       "Amazon Web Services",
+		PublisherName:      "Amazon Web Services",
+		RegionID:           "us-east-1",
+		ServiceCategory:    "Compute",
+		ServiceName:        "Amazon Elastic Compute Cloud",
+		Tags:               map[string]string{"team": "data"},
+	}}
+}
+
+func writeFixture(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidateFocusWrittenRows(t *testing.T) {
+	for _, format := range Formats {
+		t.Run(format, func(t *testing.T) {
+			var b strings.Builder
+			if err := Write(format, &b, fixture()); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			path := writeFixture(t, "focus."+format, b.String())
+			rows, problems, err := Validate(path)
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if rows != 1 || len(problems) != 0 {
+				t.Errorf("Validate = %d rows, problems %q, want 1 row and none", rows, problems)
+			}
+		})
+	}
+}
+
+func TestValidateFocusProblems(t *testing.T) {
+	var b strings.Builder
+	if err := Write("csv", &b, fixture()); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	lines := strings.SplitN(strings.TrimSpace(b.String()), "\n", 2)
+	header, row := lines[0], lines[1]
+
+	tests := []struct {
+		name   string
+		header string
+		row    string
+		want   string
+	}{
+		{"missing mandatory column", strings.Replace(header, "ServiceName", "x_ServiceName", 1), row,
+			"mandatory column ServiceName is missing"},
+		{"null non-nullable value", header, strings.Replace(row, "111111111111", "", 1),
+			"row 1: BillingAccountId must not be null"},
+		{"bad decimal", header, strings.Replace(row, "2.4", "2.4.0", 1),
+			`row 1: EffectiveCost is not a decimal: "2.4.0"`},
+		{"non-UTC date/time", header, strings.Replace(row, "2024-05-02T00:00:00Z", "2024-05-02T02:00:00+02:00", 1),
+			`row 1: ChargePeriodEnd is not an ISO 8601 UTC date/time: "2024-05-02T02:00:00+02:00"`},
+		{"lowercase currency", header, strings.Replace(row, "USD", "usd", 1),
+			`row 1: BillingCurrency is not an ISO 4217 currency code: "usd"`},
+		{"value outside allowed values", header, strings.Replace(row, "Usage", "Fee", 1),
+			`row 1: ChargeCategory has a value outside the allowed values: "Fee"`},
+		{"end before start", header, strings.Replace(row, "2024-05-02T00:00:00Z", "2024-04-30T00:00:00Z", 1),
+			"row 1: ChargePeriodEnd must be after ChargePeriodStart"},
+		{"custom column without prefix", header + ",CostCenter", row + ",cc-1",
+			"column CostCenter is not a FOCUS column and lacks the x_ prefix of custom columns"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFixture(t, "focus.csv", tt.header+"\n"+tt.row+"\n")
+			_, problems, err := Validate(path)
+			if err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if len(problems) != 1 || problems[0] != tt.want {
+				t.Errorf("problems = %q, want [%q]", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateFocusUnsupportedFile(t *testing.T) {
+	path := writeFixture(t, "focus.txt", "BilledCost\n1\n")
+	if _, _, err := Validate(path); err == nil {
+		t.Error("Validate of a .txt file succeeded, want an error")
+	}
+}
+
+func TestServiceCategory(t *testing.T) {
+	tests := []struct {
+		service string
+		want    string
+	}{
+		{"Amazon Elastic Compute Cloud - Compute", "Compute"},
+		{"Elastic Load Balancing", "Networking"},
+		{"Amazon Elastic Load Balancing", "Networking"},
+		{"Amazon Relational Database Service", "Databases"},
+		{"Tax", "Other"},
+	}
+	for _, tt := range tests {
+		if got := ServiceCategory(tt.service); got != tt.want {
+			t.Errorf("ServiceCategory(%q) = %s, want %s", tt.service, got, tt.want)
+		}
+	}
+}
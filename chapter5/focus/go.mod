@@ -0,0 +1,3 @@
+module github.com/mlabouardy/finops-book/chapter5/focus
+
+go 1.22.6
@@ -0,0 +1,59 @@
+package focus
+
+// Provider is AWS as the provider, publisher and invoice issuer of every
+// charge.
+const Provider = "AWS"
+
+// ServiceCategory maps an AWS service name to its FOCUS service
+// category. Services not listed fall in Other.
+func ServiceCategory(service string) string {
+	categories := map[string]string{
+		"Amazon Elastic Compute Cloud":                   "Compute",
+		"Amazon Elastic Compute Cloud - Compute":         "Compute",
+		"EC2 - Other":                                    "Compute",
+		"AWS Lambda":                                     "Compute",
+		"Amazon Elastic Container Service":               "Compute",
+		"Amazon Elastic Kubernetes Service":              "Compute",
+		"Amazon Lightsail":                               "Compute",
+		"Amazon Simple Storage Service":                  "Storage",
+		"Amazon Elastic File System":                     "Storage",
+		"Amazon FSx":                                     "Storage",
+		"AWS Backup":                                     "Storage",
+		"Amazon Relational Database Service":             "Databases",
+		"Amazon DynamoDB":                                "Databases",
+		"Amazon ElastiCache":                             "Databases",
+		"Amazon Redshift":                                "Databases",
+		"Amazon DocumentDB (with MongoDB compatibility)": "Databases",
+		"Amazon Virtual Private Cloud":                   "Networking",
+		"Amazon CloudFront":                              "Networking",
+		"Elastic Load Balancing":                         "Networking",
+		"Amazon Elastic Load Balancing":                  "Networking",
+		"Amazon Route 53":                                "Networking",
+		"AWS Data Transfer":                              "Networking",
+		"Amazon API Gateway":                             "Networking",
+		"Amazon CloudWatch":                              "Management and Governance",
+		"AWS CloudTrail":                                 "Management and Governance",
+		"AWS Config":                                     "Management and Governance",
+		"AWS Key Management Service":                     "Security",
+		"AWS Secrets Manager":                            "Security",
+		"Amazon GuardDuty":                               "Security",
+		"AWS WAF":                                        "Security",
+		"AWS Security Hub":                               "Security",
+		"Amazon Simple Queue Service":                    "Integration",
+		"Amazon Simple Notification Service":             "Integration",
+		"AWS Step Functions":                             "Integration",
+		"Amazon EventBridge":                             "Integration",
+		"Amazon Kinesis":                                 "Analytics",
+		"Amazon Athena":                                  "Analytics",
+		"AWS Glue":                                       "Analytics",
+		"Amazon OpenSearch Service":                      "Analytics",
+		"Amazon SageMaker":                               "AI and Machine Learning",
+		"Amazon Bedrock":                                 "AI and Machine Learning",
+		"Amazon Elastic Container Registry (ECR)":        "Developer Tools",
+		"AWS CodeBuild":                                  "Developer Tools",
+	}
+	if category, ok := categories[service]; ok {
+		return category
+	}
+	return "Other"
+}
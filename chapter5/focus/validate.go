@@ -0,0 +1,202 @@
+package focus
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxProblems caps the problems a validation reports.
+const maxProblems = 50
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Validate checks a FOCUS file written as CSV, a JSON array or NDJSON
+// (by extension): every mandatory column is present, non-nullable columns
+// have values, values have the column's type and allowed values, periods
+// end after they start, and custom columns carry the x_ prefix. It returns
+// the number of rows checked and the problems found.
+func Validate(path string) (int, []string, error) {
+	rows, err := readFile(path)
+	if err != nil {
+		return 0, nil, fmt.Errorf("unable to read FOCUS file %s: %v", path, err)
+	}
+
+	var problems []string
+	report := func(format string, args ...any) {
+		if len(problems) < maxProblems {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	known := make(map[string]column, len(columns))
+	for _, c := range columns {
+		known[c.name] = c
+	}
+	for _, name := range rows.columns {
+		if _, ok := known[name]; !ok && !strings.HasPrefix(name, "x_") {
+			report("column %s is not a FOCUS column and lacks the x_ prefix of custom columns", name)
+		}
+	}
+	present := make(map[string]bool, len(rows.columns))
+	for _, name := range rows.columns {
+		present[name] = true
+	}
+	for _, c := range columns {
+		if c.mandatory && !present[c.name] {
+			report("mandatory column %s is missing", c.name)
+		}
+	}
+
+	for i, row := range rows.values {
+		line := i + 1
+		for _, c := range columns {
+			value, ok := row[c.name]
+			if !ok && !present[c.name] {
+				continue
+			}
+			if value == "" {
+				if !c.nullable {
+					report("row %d: %s must not be null", line, c.name)
+				}
+				continue
+			}
+			if problem := checkValue(c, value); problem != "" {
+				report("row %d: %s %s", line, c.name, problem)
+			}
+		}
+		for _, period := range []string{"ChargePeriod", "BillingPeriod"} {
+			start, errStart := time.Parse(time.RFC3339, row[period+"Start"])
+			end, errEnd := time.Parse(time.RFC3339, row[period+"End"])
+			if errStart == nil && errEnd == nil && !end.After(start) {
+				report("row %d: %sEnd must be after %sStart", line, period, period)
+			}
+		}
+	}
+	return len(rows.values), problems, nil
+}
+
+// checkValue checks a non-null value against its column and describes
+// the problem, if any.
+func checkValue(c column, value string) string {
+	switch c.kind {
+	case kindDecimal:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Sprintf("is not a decimal: %q", value)
+		}
+	case kindDateTime:
+		if _, err := time.Parse(time.RFC3339, value); err != nil || !strings.HasSuffix(value, "Z") {
+			return fmt.Sprintf("is not an ISO 8601 UTC date/time: %q", value)
+		}
+	case kindCurrency:
+		if !currencyCode.MatchString(value) {
+			return fmt.Sprintf("is not an ISO 4217 currency code: %q", value)
+		}
+	case kindJSON:
+		var object map[string]any
+		if err := json.Unmarshal([]byte(value), &object); err != nil {
+			return fmt.Sprintf("is not a JSON object: %q", value)
+		}
+	}
+	if len(c.allowed) > 0 {
+		for _, allowed := range c.allowed {
+			if value == allowed {
+				return ""
+			}
+		}
+		return fmt.Sprintf("has a value outside the allowed values: %q", value)
+	}
+	return ""
+}
+
+// fileRows are the rows of a FOCUS file with every value as text, the way
+// CSV has them; nulls are empty.
+type fileRows struct {
+	columns []string
+	values  []map[string]string
+}
+
+func readFile(path string) (*fileRows, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rows := &fileRows{}
+	switch {
+	case strings.HasSuffix(path, ".csv"):
+		reader := csv.NewReader(file)
+		header, err := reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		rows.columns = append(rows.columns, header...)
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				return rows, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			row := make(map[string]string, len(header))
+			for i, name := range header {
+				row[name] = record[i]
+			}
+			rows.values = append(rows.values, row)
+		}
+	case strings.HasSuffix(path, ".json"):
+		var objects []map[string]json.RawMessage
+		if err := json.NewDecoder(file).Decode(&objects); err != nil {
+			return nil, err
+		}
+		for _, object := range objects {
+			rows.add(object)
+		}
+		return rows, nil
+	case strings.HasSuffix(path, ".ndjson"), strings.HasSuffix(path, ".jsonl"):
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			var object map[string]json.RawMessage
+			if err := json.Unmarshal(scanner.Bytes(), &object); err != nil {
+				return nil, err
+			}
+			rows.add(object)
+		}
+		return rows, scanner.Err()
+	default:
+		return nil, fmt.Errorf("unsupported file type, expected .csv, .json or .ndjson")
+	}
+}
+
+// add converts a JSON object to text values: strings are unquoted, null is
+// empty and numbers and objects keep their JSON text.
+func (r *fileRows) add(object map[string]json.RawMessage) {
+	row := make(map[string]string, len(object))
+	for name, raw := range object {
+		if _, ok := row[name]; !ok && len(r.values) == 0 {
+			r.columns = append(r.columns, name)
+		}
+		var text string
+		switch {
+		case string(raw) == "null":
+		case json.Unmarshal(raw, &text) == nil:
+		default:
+			text = string(raw)
+		}
+		row[name] = text
+	}
+	r.values = append(r.values, row)
+}